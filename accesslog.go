@@ -0,0 +1,321 @@
+package filelogger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLogFormat アクセスログの形式
+type AccessLogFormat int
+
+// アクセスログの形式
+const (
+	AccessLogCommon   AccessLogFormat = iota // Apacheのcommon形式
+	AccessLogCombined                        // Apacheのcombined形式。commonにRefererとUser-Agentを加えたもの
+	AccessLogJSON                            // 一行に一つのJSON。応答時間とリクエストIDも出力する
+)
+
+// defaultRequestIDHeader リクエストIDを読むヘッダー
+const defaultRequestIDHeader = "X-Request-ID"
+
+// AccessLog http.Handlerのアクセスログを専用のファイルに出力する。
+// ファイルへの出力はConfigの設定でローテーション、圧縮され、Sinksにも同じ内容をFieldsを付けて出力する。
+// ログレベルはステータスが5xxならERROR、4xxならWARN、それ以外はINFOで、LogLevelConfで出力の有無を切り替えられる。
+// 日時は行に含まれるので、LoggerFlagsは使わない
+type AccessLog struct {
+	RequestIDHeader string // リクエストIDを読むヘッダー。空の場合は"X-Request-ID"
+
+	logger *fileLogger
+	format AccessLogFormat
+}
+
+// NewAccessLog confの設定でformatの形式で出力するAccessLogを作成する。
+// アクセスログの行には連番とハッシュを付けられないので、Audit.Enabledが設定されていればエラーを出力して無効にする
+func NewAccessLog(conf *Config, format AccessLogFormat) *AccessLog {
+	if conf.Audit.Enabled {
+		logPrintln("Audit is not supported by AccessLog, access lines are written without seq and hash")
+		c := *conf
+		c.Audit = AuditConfig{}
+		conf = &c
+	}
+	if conf.CreateDir {
+		if err := conf.prepareDir(); err != nil {
+			logPrintln(err.Error())
+		}
+	}
+	return &AccessLog{
+		logger: newFileLogger(conf),
+		format: format,
+	}
+}
+
+// Close 実行中の圧縮を待ってSinkを閉じる
+func (a *AccessLog) Close() error {
+	return a.logger.close()
+}
+
+// Handler nextへのリクエストをアクセスログに出力するhttp.Handlerを返す。
+// リクエストIDのヘッダーがあれば、ContextWithRequestIDでリクエストのcontextに入れてからnextを呼ぶ。
+// nextがpanicした場合はステータスを500として出力し、同じ値でpanicし直す
+func (a *AccessLog) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		header := a.RequestIDHeader
+		if header == "" {
+			header = defaultRequestIDHeader
+		}
+		if id := r.Header.Get(header); id != "" {
+			r = r.WithContext(ContextWithRequestID(r.Context(), id))
+		}
+
+		rw := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			// nextがpanicした場合は応答が中断されるので、書き込んだステータスに関係なく500として出力してからpanicし直す
+			p := recover()
+			rec := newAccessRecord(r, rw, start)
+			if p != nil {
+				rec.Status = http.StatusInternalServerError
+			}
+			a.write(rec)
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// accessRecord アクセスログの一件。JSON形式ではこのまま出力する
+type accessRecord struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remote_ip"`
+	User      string    `json:"user,omitempty"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Size      int64     `json:"size"`
+	Latency   float64   `json:"latency_ms"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+func newAccessRecord(r *http.Request, rw *responseRecorder, start time.Time) *accessRecord {
+	rec := &accessRecord{
+		Time:      start,
+		RemoteIP:  r.RemoteAddr,
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Path:      r.URL.Path,
+		Proto:     r.Proto,
+		Status:    rw.status,
+		Size:      rw.size,
+		Latency:   float64(time.Since(start).Microseconds()) / 1000,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		rec.RemoteIP = host
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		rec.User = user
+	} else if r.URL.User != nil {
+		rec.User = r.URL.User.Username()
+	}
+	if rec.URI == "" {
+		rec.URI = r.URL.RequestURI()
+	}
+	if rec.Status == 0 {
+		rec.Status = http.StatusOK
+	}
+	rec.RequestID, _ = RequestIDFromContext(r.Context())
+	return rec
+}
+
+func (rec *accessRecord) level() string {
+	switch {
+	case rec.Status >= 500:
+		return ERROR
+	case rec.Status >= 400:
+		return WARN
+	}
+	return INFO
+}
+
+func (rec *accessRecord) fields() Fields {
+	fields := Fields{
+		"remote_ip":  rec.RemoteIP,
+		"method":     rec.Method,
+		"uri":        rec.URI,
+		"status":     rec.Status,
+		"size":       rec.Size,
+		"latency_ms": rec.Latency,
+	}
+	for k, v := range map[string]string{"user": rec.User, "referer": rec.Referer, "user_agent": rec.UserAgent, "request_id": rec.RequestID} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// formatLine 一行にする。commonは%h %l %u %t "%r" %>s %b、combinedは後ろに"%{Referer}i" "%{User-agent}i"を続ける
+func (a *AccessLog) formatLine(rec *accessRecord) string {
+	if a.format == AccessLogJSON {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err.Error()
+		}
+		return string(b)
+	}
+
+	size := "-"
+	if rec.Size > 0 {
+		size = strconv.FormatInt(rec.Size, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		rec.RemoteIP,
+		dashIfEmpty(escapeLogItem(rec.User)),
+		rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(rec.Method+" "+rec.URI+" "+rec.Proto),
+		rec.Status,
+		size,
+	)
+	if a.format == AccessLogCombined {
+		line += " " + strconv.Quote(rec.Referer) + " " + strconv.Quote(rec.UserAgent)
+	}
+	return line
+}
+
+// escapeLogItem Apacheと同じように、"と\と表示できない文字をエスケープする。
+// 空白で区切った項目がずれないように、空白も\x20にする
+func escapeLogItem(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c <= ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// write 一行をそのままファイルに出力し、Sinksには同じ行をメッセージにしてFieldsを付けたEntryを出力する
+func (a *AccessLog) write(rec *accessRecord) {
+	line := a.formatLine(rec)
+	e := &Entry{Time: rec.Time, Level: rec.level(), Message: line, Fields: rec.fields()}
+	l := a.logger
+	l.logOutput(e.Level, func() {
+		e, ok := l.prepare(e)
+		if !ok {
+			return
+		}
+		e.Mode = l.Conf.Mode
+		l.Logger.Print(e.Message)
+		l.writeSinks(e)
+	})
+}
+
+// responseRecorder ステータスと書き込んだバイト数を記録するhttp.ResponseWriter。
+// 元のResponseWriterが対応していればFlush、Hijack、Push、ReadFromも使える
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+// ReadFrom 元のResponseWriterがio.ReaderFromならsendfileなどを使えるようにそのまま渡す
+func (rw *responseRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(rw.ResponseWriter, r)
+	}
+	rw.size += n
+	return n, err
+}
+
+// Flush 元のResponseWriterがhttp.Flusherでなければ何もしない
+func (rw *responseRecorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack 元のResponseWriterがhttp.Hijackerでなければエラーを返す。ステータスを書く前に乗っ取った場合は101として記録する
+func (rw *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("filelogger: ResponseWriter does not implement http.Hijacker")
+	}
+	if rw.status == 0 {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Push 元のResponseWriterがhttp.Pusherでなければhttp.ErrNotSupportedを返す
+func (rw *responseRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := rw.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap http.ResponseControllerが元のResponseWriterを使えるようにする
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,204 @@
+package filelogger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func accessLogHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := RequestIDFromContext(r.Context())
+		w.Write([]byte("hello " + id))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		w.Write([]byte("chunk"))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("handler failed")
+	})
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi")
+		buf.Flush()
+	})
+	return mux
+}
+
+func readAccessLog(t *testing.T, path string) []string {
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access", "access.log")
+	a := NewAccessLog(&Config{FilePath: path, CreateDir: true}, AccessLogCombined)
+	ts := httptest.NewServer(a.Handler(accessLogHandler()))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/ok?x=1", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("X-Request-ID", "abc")
+	req.SetBasicAuth("alice", "pw")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "hello abc", string(body))
+
+	resp, err = http.Get(ts.URL + "/stream")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + "/hijack")
+	assert.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "hi", string(body))
+	assert.NoError(t, a.Close())
+
+	lines := readAccessLog(t, path)
+	assert.Len(t, lines, 3)
+	combined := regexp.MustCompile(`^127\.0\.0\.1 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /ok\?x=1 HTTP/1\.1" 200 9 "http://example.com/" "test-agent"$`)
+	assert.Regexp(t, combined, lines[0])
+	assert.Contains(t, lines[1], `"GET /stream HTTP/1.1" 200 5 `)
+	assert.Contains(t, lines[2], `"GET /hijack HTTP/1.1" 101 - `)
+}
+
+// ユーザー名に改行や空白があっても、一行のままで項目がずれないか
+func TestAccessLogEscapeUser(t *testing.T) {
+	a := &AccessLog{format: AccessLogCommon}
+	rec := &accessRecord{
+		Time:     time.Date(2019, 12, 3, 8, 25, 15, 0, time.UTC),
+		RemoteIP: "127.0.0.1",
+		Method:   http.MethodGet,
+		URI:      "/",
+		Proto:    "HTTP/1.1",
+		Status:   http.StatusOK,
+		User:     "bob\n127.0.0.1 - admin \"x\\\x7f",
+	}
+	assert.Equal(t, `127.0.0.1 - bob\n127.0.0.1\x20-\x20admin\x20\"x\\\x7f [03/Dec/2019:08:25:15 +0000] "GET / HTTP/1.1" 200 -`, a.formatLine(rec))
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	sink := &memorySink{}
+	a := NewAccessLog(&Config{
+		FilePath:     path,
+		Rotate:       RotateConfig{MaxLine: 2, MaxRotation: 5},
+		Mode:         ModeProduction,
+		LogLevelConf: LogLevelConfig{{Mode: ModeProduction, ExcludedLevel: []string{WARN}}},
+		Sinks:        []Sink{sink},
+	}, AccessLogJSON)
+	a.RequestIDHeader = "X-Trace"
+
+	handler := a.Handler(accessLogHandler())
+	for _, target := range []string{"/ok", "/missing", "/ok", "/ok"} {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.Header.Set("X-Trace", "t1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.NoError(t, a.Close())
+
+	// 404はWARNなので出力されず、MaxLineでローテーションされる
+	assert.Len(t, logFileList(dir, "access.log"), 2)
+	lines := readAccessLog(t, path)
+	assert.Len(t, lines, 1)
+
+	var rec map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "POST", rec["method"])
+	assert.Equal(t, "/ok", rec["path"])
+	assert.Equal(t, float64(200), rec["status"])
+	assert.Equal(t, float64(8), rec["size"])
+	assert.Equal(t, "192.0.2.1", rec["remote_ip"])
+	assert.Equal(t, "t1", rec["request_id"])
+	assert.Contains(t, rec, "latency_ms")
+
+	entries := sink.Entries()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, INFO, entries[0].Level)
+	assert.Equal(t, ModeProduction, entries[0].Mode)
+	assert.Equal(t, "t1", entries[0].Fields["request_id"])
+}
+
+// ハンドラーがpanicした場合は500として出力し、panicし直すか
+func TestAccessLogPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	a := NewAccessLog(&Config{FilePath: path}, AccessLogCommon)
+	handler := a.Handler(accessLogHandler())
+	assert.PanicsWithValue(t, "handler failed", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+	assert.NoError(t, a.Close())
+
+	lines := readAccessLog(t, path)
+	assert.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"GET /panic HTTP/1.1" 500 7`)
+}
+
+// 監査ログの設定はアクセスログでは無効にし、呼び出し元の設定は変えないか
+func TestAccessLogAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{FilePath: filepath.Join(dir, "access.log"), Audit: AuditConfig{Enabled: true}}
+	a := NewAccessLog(conf, AccessLogCommon)
+	assert.False(t, a.logger.Conf.Audit.Enabled)
+	assert.True(t, conf.Audit.Enabled)
+
+	a.Handler(accessLogHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.NoError(t, a.Close())
+	lines := readAccessLog(t, conf.FilePath)
+	assert.Len(t, lines, 1)
+	assert.NotContains(t, lines[0], AuditHashField+"=")
+}
+
+// 元のResponseWriterが対応していない場合でもFlushとHijackで止まらないか
+func TestResponseRecorderUnsupported(t *testing.T) {
+	rw := &responseRecorder{ResponseWriter: struct{ http.ResponseWriter }{httptest.NewRecorder()}}
+	rw.Flush()
+	_, _, err := rw.Hijack()
+	assert.Error(t, err)
+	assert.Equal(t, http.ErrNotSupported, rw.Push("/x", nil))
+
+	n, err := rw.ReadFrom(bufio.NewReader(strings.NewReader("abc")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, http.StatusOK, rw.status)
+}
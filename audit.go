@@ -0,0 +1,375 @@
+package filelogger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// auditHeaderPrefix 監査ログのファイルの先頭に書く行。前のファイルから続く連鎖の先頭を持つ
+const auditHeaderPrefix = "#filelogger-audit "
+
+// 監査ログの各行に付けるFieldsの名前
+const (
+	AuditSeqField  = "audit_seq"
+	AuditHashField = "audit_hash"
+)
+
+// AuditConfig 改ざんを検出できる監査ログの設定。
+// 各行に連番と、前の行のハッシュをつないだハッシュを付ける。ローテーションした場合は、新しいファイルの先頭に連鎖の続きを書く。
+// 一行ごとに検証するので、メッセージの改行などの制御文字はSanitizeの設定に関わらずエスケープする
+type AuditConfig struct {
+	Enabled bool
+	Key     []byte // 設定されていればHMAC-SHA256、空の場合はSHA-256でハッシュを計算する
+}
+
+// auditHead 連鎖の先頭。最後に書いた行の連番とハッシュ
+type auditHead struct {
+	seq  uint64
+	hash []byte
+}
+
+// auditChain 出力中のファイルの連鎖の先頭を覚えておく。
+// 他のプロセスが書き込んだ場合や再起動した場合は、ファイルの大きさが変わるのでファイルの最後の行から読み直す
+type auditChain struct {
+	head   auditHead
+	file   os.FileInfo // 最後に書き込んだファイル
+	size   int64       // 最後に書き込んだ後の大きさ
+	loaded bool
+}
+
+// auditSum prevとlineをつないだハッシュを計算する
+func auditSum(key, prev []byte, line string) []byte {
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(prev)
+	h.Write([]byte(line))
+	return h.Sum(nil)
+}
+
+// formatAuditHeader 連鎖の先頭を持つファイルの先頭の行
+func formatAuditHeader(head auditHead) string {
+	return fmt.Sprintf("%sseq=%d prev=%s\n", auditHeaderPrefix, head.seq+1, hex.EncodeToString(head.hash))
+}
+
+// parseAuditHeader ファイルの先頭の行から、前のファイルの最後の行の連番とハッシュを読む
+func parseAuditHeader(line string) (auditHead, bool) {
+	if !strings.HasPrefix(line, auditHeaderPrefix) {
+		return auditHead{}, false
+	}
+	fields, ok := parseFields(line[len(auditHeaderPrefix):])
+	if !ok {
+		return auditHead{}, false
+	}
+	seq, err := strconv.ParseUint(fmt.Sprint(fields["seq"]), 10, 64)
+	if err != nil || seq == 0 {
+		return auditHead{}, false
+	}
+	prev, err := hex.DecodeString(fmt.Sprint(fields["prev"]))
+	if err != nil {
+		return auditHead{}, false
+	}
+	return auditHead{seq: seq - 1, hash: prev}, true
+}
+
+// splitAuditLine 監査ログの一行を、ハッシュの対象になる部分と連番、ハッシュに分ける。対象はPrefixを含む行全体から" audit_hash=..."を除いたもの
+func splitAuditLine(line string) (body string, seq uint64, sum []byte, err error) {
+	i := strings.LastIndex(line, " "+AuditHashField+"=")
+	if i < 0 {
+		return "", 0, nil, errors.New("missing " + AuditHashField)
+	}
+	body = line[:i]
+	if sum, err = hex.DecodeString(line[i+len(AuditHashField)+2:]); err != nil || len(sum) != sha256.Size {
+		return "", 0, nil, errors.New("invalid " + AuditHashField)
+	}
+	_, fields := splitFields(body)
+	if seq, err = strconv.ParseUint(fmt.Sprint(fields[AuditSeqField]), 10, 64); err != nil {
+		return "", 0, nil, errors.New("missing " + AuditSeqField)
+	}
+	return body, seq, sum, nil
+}
+
+// lastAuditHead ファイルの最後の行から連鎖の先頭を読む。空のファイルの場合はfalseを返す
+func lastAuditHead(path string, keys KeyProvider) (auditHead, bool, error) {
+	line, err := lastLine(path, keys)
+	if err != nil || line == "" {
+		return auditHead{}, false, err
+	}
+	if head, ok := parseAuditHeader(line); ok {
+		return head, true, nil
+	}
+	_, seq, sum, err := splitAuditLine(line)
+	if err != nil {
+		return auditHead{}, false, fmt.Errorf("audit: last line of %s: %v", path, err)
+	}
+	return auditHead{seq: seq, hash: sum}, true, nil
+}
+
+// lastLine ファイルの最後の行を返す。圧縮も暗号化もされていなければ後ろから読む
+func lastLine(path string, keys KeyProvider) (string, error) {
+	if isArchivedFile(path) {
+		r, err := openLogFile(path, keys)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		var last string
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line = trimNewline(line); line != "" {
+				last = line
+			}
+			if err == io.EOF {
+				return last, nil
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := fi.Size()
+	for window := int64(4096); ; window *= 2 {
+		start := size - window
+		if start < 0 {
+			start = 0
+		}
+		b := make([]byte, size-start)
+		if _, err := f.ReadAt(b, start); err != nil && err != io.EOF {
+			return "", err
+		}
+		b = bytes.TrimRight(b, "\n")
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return string(b[i+1:]), nil
+		}
+		if start == 0 {
+			return string(b), nil
+		}
+	}
+}
+
+// auditLoad 出力中のファイルの最後の行から連鎖の先頭を読む。ローテーションした直後で空の場合は、一つ前のファイルから読む
+func (l *fileLogger) auditLoad(size int64) (auditHead, error) {
+	if size > 0 {
+		head, _, err := lastAuditHead(l.file.fm.path, l.Conf.Encrypt.Keys)
+		return head, err
+	}
+	files := LogFiles(l.file.fm.path)
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i] == l.file.fm.path {
+			continue
+		}
+		head, ok, err := lastAuditHead(files[i], l.Conf.Encrypt.Keys)
+		if ok || err != nil {
+			return head, err
+		}
+	}
+	return auditHead{}, nil
+}
+
+// auditPrint eに連番を付けて、前の行とつないだハッシュと一緒に出力する。Sinkに出力するために連番とハッシュを付けたEntryを返す。
+// ファイルが空の場合は、先に連鎖の先頭を書く。ロック中にファイルを開いてから呼ぶこと
+func (l *fileLogger) auditPrint(e *Entry) (*Entry, error) {
+	a := &l.audit
+	fi, err := l.file.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// 最後に書いた後にローテーションしただけなら、覚えている先頭から続ける
+	current := a.loaded && a.size == fi.Size() && os.SameFile(a.file, fi)
+	if !current && a.loaded && fi.Size() == 0 {
+		if files := LogFiles(l.file.fm.path); len(files) > 1 {
+			prev, err := os.Stat(files[len(files)-2])
+			current = err == nil && os.SameFile(a.file, prev) && prev.Size() == a.size
+		}
+	}
+	if !current {
+		if a.head, err = l.auditLoad(fi.Size()); err != nil {
+			// 連鎖が読めなくても出力は止めない。検証で途切れたことがわかる
+			logPrintln(err.Error())
+		}
+		a.loaded = true
+	}
+
+	size := fi.Size()
+	if size == 0 {
+		header := formatAuditHeader(a.head)
+		if _, err := io.WriteString(l.file.file, header); err != nil {
+			return nil, err
+		}
+		size += int64(len(header))
+	}
+
+	audited := *e
+	seq := a.head.seq + 1
+	audited.Fields = copyFields(e.Fields, Fields{AuditSeqField: seq})
+	line := strings.TrimSuffix(formatEntry(&audited, l.Conf.LoggerFlags), "\n")
+	sum := auditSum(l.Conf.Audit.Key, a.head.hash, l.Conf.Prefix+line)
+	line += " " + AuditHashField + "=" + hex.EncodeToString(sum)
+	l.Logger.Print(line)
+	audited.Fields[AuditHashField] = hex.EncodeToString(sum)
+
+	a.head = auditHead{seq: seq, hash: sum}
+	a.file = fi
+	a.size = size + int64(len(l.Conf.Prefix)+len(line)+1)
+	return &audited, nil
+}
+
+// AuditError 検証で見つかった問題
+type AuditError struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`          // 1から数えた行番号。ファイル全体の問題の場合は0
+	Seq    uint64 `json:"seq,omitempty"` // わかる場合は行の連番
+	Reason string `json:"reason"`
+}
+
+func (e *AuditError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Reason)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Reason)
+}
+
+// AuditReport VerifyAuditの結果
+type AuditReport struct {
+	Files    int           `json:"files"`
+	Entries  int           `json:"entries"`
+	FirstSeq uint64        `json:"first_seq"` // 残っている最も古い行の連番。古いファイルが削除されていれば1より大きい
+	LastSeq  uint64        `json:"last_seq"`
+	Errors   []*AuditError `json:"errors"`
+}
+
+// OK 問題が見つからなかったか
+func (r *AuditReport) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// VerifyAudit confのFilePathのローテーションしたファイルと出力中のファイルを古い順に読み、監査ログの連鎖を検証する。
+// 行の削除、並べ替え、書き換えと、途中のファイルの削除を検出する。最も古いファイルより前と、最後の行より後は検証できない。
+// 問題はAuditReport.Errorsに入れ、読めなかった場合だけエラーを返す
+func VerifyAudit(conf *Config) (*AuditReport, error) {
+	if conf.FilePath == "" {
+		return nil, errors.New("filelogger: FilePath is empty")
+	}
+	v := &auditVerifier{key: conf.Audit.Key, keys: conf.Encrypt.Keys, report: &AuditReport{}}
+	for _, path := range LogFiles(conf.FilePath) {
+		if err := v.file(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+	}
+	return v.report, nil
+}
+
+type auditVerifier struct {
+	key     []byte
+	keys    KeyProvider // 暗号化されたファイルを読む鍵
+	head    auditHead
+	started bool // 最初の連鎖の先頭を読んだか
+	report  *AuditReport
+}
+
+func (v *auditVerifier) errorf(path string, line int, seq uint64, format string, args ...interface{}) {
+	v.report.Errors = append(v.report.Errors, &AuditError{File: path, Line: line, Seq: seq, Reason: fmt.Sprintf(format, args...)})
+}
+
+// file 一つのファイルを検証する。先頭の行で前のファイルから続いているかを確かめてから各行をつなぐ
+func (v *auditVerifier) file(path string) error {
+	r, err := openLogFile(path, v.keys)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	v.report.Files++
+
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" && err == io.EOF {
+			if n == 1 {
+				return nil
+			}
+			break
+		}
+		line = trimNewline(line)
+		if n == 1 {
+			v.header(path, line)
+			continue
+		}
+		v.line(path, n, line)
+		if err == io.EOF {
+			break
+		}
+	}
+	return nil
+}
+
+func (v *auditVerifier) header(path, line string) {
+	head, ok := parseAuditHeader(line)
+	if !ok {
+		v.errorf(path, 1, 0, "missing audit header")
+		return
+	}
+	if !v.started {
+		// 最も古いファイルより前は削除されていても検証できないので、先頭の行から始める
+		v.started = true
+		v.head = head
+		v.report.FirstSeq = head.seq + 1
+		return
+	}
+	if head.seq != v.head.seq || !bytes.Equal(head.hash, v.head.hash) {
+		v.errorf(path, 1, head.seq+1, "header does not continue from the previous file (last seq %d)", v.head.seq)
+		v.head = head
+	}
+}
+
+func (v *auditVerifier) line(path string, n int, line string) {
+	body, seq, sum, err := splitAuditLine(line)
+	if err != nil {
+		v.errorf(path, n, 0, "%v", err)
+		return
+	}
+	v.report.Entries++
+	if !v.started {
+		v.started = true
+		v.report.FirstSeq = seq
+		v.head = auditHead{seq: seq - 1}
+	}
+	switch {
+	case seq != v.head.seq+1:
+		v.errorf(path, n, seq, "expected seq %d, lines are missing or reordered", v.head.seq+1)
+	case !hmac.Equal(sum, auditSum(v.key, v.head.hash, body)):
+		v.errorf(path, n, seq, "hash mismatch, the line or a previous line was modified")
+	}
+	// 途切れた場合は、その行から連鎖を続けて後ろの行を検証する
+	v.head = auditHead{seq: seq, hash: sum}
+	v.report.LastSeq = seq
+}
@@ -0,0 +1,136 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var auditTestConf = Config{
+	FilePath:    "audit.log",
+	LoggerFlags: LoggerFlags,
+	Prefix:      "app ",
+	Rotate:      RotateConfig{MaxLine: 5, MaxRotation: 20},
+	Compress:    true,
+	Audit:       AuditConfig{Enabled: true, Key: []byte("secret key")},
+}
+
+func writeAudit(l *fileLogger, from, n int) {
+	writeEntries(l, from, n, func(i int) *Entry {
+		return newEntry(1, INFO, "event "+strconv.Itoa(i), Fields{"user": "alice"})
+	})
+}
+
+// ローテーションと圧縮、再起動をまたいで連鎖が続き、Readerでも読めるか
+func TestAudit(t *testing.T) {
+	conf, cleanup := newTempConf(t, auditTestConf)
+	defer cleanup()
+
+	l := newFileLogger(conf)
+	writeAudit(l, 0, 12)
+	l.write(newEntry(1, ERROR, "forged\n2019/12/03 08:00:00 [INFO] line", nil))
+	assert.NoError(t, l.close())
+	l = newFileLogger(conf)
+	writeAudit(l, 13, 7)
+	assert.NoError(t, l.close())
+
+	files := LogFiles(conf.FilePath)
+	assert.True(t, len(files) > 3)
+	assert.True(t, isGzipFile(files[0]))
+	report, err := VerifyAudit(conf)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.True(t, report.OK())
+	assert.Equal(t, len(files), report.Files)
+	assert.Equal(t, 20, report.Entries)
+	assert.Equal(t, uint64(1), report.FirstSeq)
+	assert.Equal(t, uint64(20), report.LastSeq)
+
+	r, err := NewReader(conf)
+	assert.NoError(t, err)
+	entries := readAllEntries(t, r)
+	if assert.Len(t, entries, 20) {
+		assert.Equal(t, "event 0", entries[0].Message)
+		assert.Equal(t, "1", entries[0].Fields[AuditSeqField])
+		assert.Len(t, entries[0].Fields[AuditHashField], 64)
+		assert.Equal(t, `forged\n2019/12/03 08:00:00 [INFO] line`, entries[12].Message)
+	}
+
+	// 鍵が違えば検証できない
+	report, err = VerifyAudit(&Config{FilePath: conf.FilePath, Audit: AuditConfig{Key: []byte("other")}})
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+
+	// 最も古いファイルが削除されても、残りは検証できる
+	assert.NoError(t, os.Remove(files[0]))
+	report, err = VerifyAudit(conf)
+	assert.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Errors)
+	assert.True(t, report.FirstSeq > 1)
+}
+
+// 行の書き換え、削除、並べ替えと、途中のファイルの削除を検出するか
+func TestAuditTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, files []string)
+		reason string
+	}{
+		{"modify", func(t *testing.T, files []string) {
+			editLines(t, files[1], func(lines []string) []string {
+				lines[2] = strings.Replace(lines[2], "alice", "mallory", 1)
+				return lines
+			})
+		}, "hash mismatch"},
+		{"delete line", func(t *testing.T, files []string) {
+			editLines(t, files[1], func(lines []string) []string {
+				return append(lines[:2], lines[3:]...)
+			})
+		}, "expected seq"},
+		{"reorder", func(t *testing.T, files []string) {
+			editLines(t, files[1], func(lines []string) []string {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			})
+		}, "expected seq"},
+		{"truncate", func(t *testing.T, files []string) {
+			editLines(t, files[1], func(lines []string) []string {
+				return lines[:len(lines)-1]
+			})
+		}, "does not continue"},
+		{"delete file", func(t *testing.T, files []string) {
+			assert.NoError(t, os.Remove(files[1]))
+		}, "does not continue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, cleanup := newTempConf(t, auditTestConf)
+			defer cleanup()
+			conf.Compress = false
+			l := newFileLogger(conf)
+			writeAudit(l, 0, 15)
+			assert.NoError(t, l.close())
+
+			files := LogFiles(conf.FilePath)
+			assert.True(t, len(files) > 2)
+			tt.tamper(t, files)
+
+			report, err := VerifyAudit(conf)
+			assert.NoError(t, err)
+			if assert.NotEmpty(t, report.Errors) {
+				assert.Contains(t, report.Errors[0].Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func editLines(t *testing.T, path string, edit func([]string) []string) {
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := edit(strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"))
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+}
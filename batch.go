@@ -0,0 +1,590 @@
+package filelogger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BatchConfig ネットワーク越しにまとめて送るSinkの共通の設定
+type BatchConfig struct {
+	BatchSize     int           // 一度に送る最大件数。0の場合は100
+	FlushInterval time.Duration // バッファを送る間隔。0の場合は1秒
+	MaxBuffer     int           // 送信待ちでメモリに保持する最大件数。超えた場合は古いものから捨てる。0の場合はBatchSizeの10倍
+	MaxRetries    int           // 送信に失敗した場合に再試行する回数。0の場合は3、負の場合は再試行しない
+	RetryWait     time.Duration // 最初の再試行までの待ち時間。再試行のたびに倍にする。0の場合は500ミリ秒
+	MaxRetryWait  time.Duration // 再試行の待ち時間の上限。0の場合は30秒
+	SpoolDir      string        // 送れなかったEntryを保存しておくディレクトリ。空の場合は保存せずに捨てる
+	SpoolRotate   RotateConfig  // スプールのローテーションの設定。MaxLineが0の場合は10000行、MaxRotationが0の場合は10ファイル
+}
+
+// BatchConfigの初期値
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultMaxRetries    = 3
+	defaultRetryWait     = 500 * time.Millisecond
+	defaultMaxRetryWait  = 30 * time.Second
+	defaultSpoolMaxLine  = 10000
+	defaultSpoolMaxFiles = 10
+)
+
+func (c BatchConfig) withDefaults() BatchConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = defaultFlushInterval
+	}
+	if c.MaxBuffer <= 0 {
+		c.MaxBuffer = c.BatchSize * 10
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultMaxRetries
+	} else if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+	if c.RetryWait <= 0 {
+		c.RetryWait = defaultRetryWait
+	}
+	if c.MaxRetryWait <= 0 {
+		c.MaxRetryWait = defaultMaxRetryWait
+	}
+	if c.SpoolRotate.MaxLine == 0 {
+		c.SpoolRotate.MaxLine = defaultSpoolMaxLine
+	}
+	if c.SpoolRotate.MaxRotation == 0 {
+		c.SpoolRotate.MaxRotation = defaultSpoolMaxFiles
+	}
+	return c
+}
+
+// permanentError 再試行しても成功しない送信のエラー。スプールせずに捨てる
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func isPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// partialError 一部だけ送れなかった場合のエラー。retryだけを送り直し、dropped件は再試行しても成功しないので捨てる
+type partialError struct {
+	err     error
+	retry   []*Entry
+	dropped int
+	dropErr error // droppedを捨てる理由。nilの場合はerr
+}
+
+func (e *partialError) Error() string {
+	return e.err.Error()
+}
+
+// encodedBatch batchを一件ずつ変換した結果。変換できないEntryは再試行しても変わらないので除き、送らずに捨てる
+type encodedBatch struct {
+	entries []*Entry // 変換できたEntry
+	data    [][]byte // entriesを変換したもの
+	dropped int
+	err     error // 最後に変換できなかった理由
+}
+
+// encodeEach batchを一件ずつencodeで変換する。一件が変換できなくても、ほかのEntryは送れるようにする
+func encodeEach(batch []*Entry, encode func(*Entry) ([]byte, error)) *encodedBatch {
+	eb := &encodedBatch{}
+	for _, e := range batch {
+		b, err := encode(e)
+		if err != nil {
+			eb.dropped++
+			eb.err = err
+			continue
+		}
+		eb.entries = append(eb.entries, e)
+		eb.data = append(eb.data, b)
+	}
+	return eb
+}
+
+// result entriesを送った結果のerrに、変換できずに除いたEntryを捨てるものとして加える
+func (eb *encodedBatch) result(err error) error {
+	if eb.dropped == 0 {
+		return err
+	}
+	if err == nil {
+		return &partialError{err: eb.err, dropped: eb.dropped}
+	}
+	var pe *partialError
+	if errors.As(err, &pe) {
+		if pe.dropped == 0 {
+			pe.dropErr = eb.err
+		}
+		pe.dropped += eb.dropped
+		return pe
+	}
+	return &partialError{err: err, retry: eb.entries, dropped: eb.dropped, dropErr: eb.err}
+}
+
+// errBatcherClosed Closeの後に書き込んだ場合のエラー
+var errBatcherClosed = errors.New("filelogger: sink closed")
+
+// batcher Entryをメモリにためて、BatchSize件ごとかFlushIntervalごとにsendで送る。
+// 送れなかった場合は再試行し、それでも送れなければスプールに保存して、送れるようになってから古い順に送り直す。
+// 送信とスプールの操作はすべてrunのgoroutineで行う
+type batcher struct {
+	conf  BatchConfig
+	send  func([]*Entry) error
+	spool *spool
+
+	mu      sync.Mutex
+	buf     []*Entry
+	dropped uint64
+	closed  bool
+
+	kick    chan struct{}
+	flushCh chan chan error
+	done    chan struct{}
+	stopped chan struct{}
+
+	// runのgoroutineだけが使う
+	failures    int
+	nextAttempt time.Time
+}
+
+// newBatcher batcherを作成して送信用のgoroutineを開始する。SpoolDirが設定されていれば、name(スプールのファイル名)で保存する
+func newBatcher(conf BatchConfig, name string, send func([]*Entry) error) (*batcher, error) {
+	conf = conf.withDefaults()
+	b := &batcher{
+		conf:    conf,
+		send:    send,
+		kick:    make(chan struct{}, 1),
+		flushCh: make(chan chan error),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if conf.SpoolDir != "" {
+		s, err := newSpool(filepath.Join(conf.SpoolDir, name), conf.SpoolRotate)
+		if err != nil {
+			return nil, err
+		}
+		b.spool = s
+	}
+	go b.run()
+	return b, nil
+}
+
+// Write Entryをバッファに追加する。MaxBufferを超えた場合は古いものから捨てる
+func (b *batcher) Write(e *Entry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errBatcherClosed
+	}
+
+	b.buf = append(b.buf, e)
+	if n := len(b.buf) - b.conf.MaxBuffer; n > 0 {
+		b.buf = append([]*Entry(nil), b.buf[n:]...)
+		b.dropped += uint64(n)
+	}
+	if len(b.buf) >= b.conf.BatchSize {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush バッファとスプールのEntryを送る。送れずにスプールに残ったり捨てたりした場合はエラーを返す
+func (b *batcher) Flush() error {
+	ch := make(chan error, 1)
+	select {
+	case b.flushCh <- ch:
+		return <-ch
+	case <-b.stopped:
+		return nil
+	}
+}
+
+// Close バッファのEntryを送ってから送信用のgoroutineを止める。送れなかったものはスプールに残り、次に作成したときに送る
+func (b *batcher) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	err := b.Flush()
+	close(b.done)
+	<-b.stopped
+	if b.spool != nil {
+		if e := b.spool.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Dropped 送れずに捨てたEntryの数を返す
+func (b *batcher) Dropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// bufferSpooler 終了までに送り終わらなかったEntryをスプールに保存できるSink
+type bufferSpooler interface {
+	spoolBuffered() error
+}
+
+// spoolBuffered まだ送っていないバッファのEntryをスプールに保存し、次に作成したときに送る。
+// runのgoroutineが送信中で止まっていても呼べるように、pendingは変更しない。スプールがない場合は何もしない
+func (b *batcher) spoolBuffered() error {
+	if b.spool == nil {
+		return nil
+	}
+	b.mu.Lock()
+	batch := b.buf
+	b.buf = nil
+	b.mu.Unlock()
+	return b.spool.write(batch)
+}
+
+func (b *batcher) run() {
+	defer close(b.stopped)
+	ticker := time.NewTicker(b.conf.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.kick:
+			b.process(false)
+		case <-ticker.C:
+			b.process(false)
+		case ch := <-b.flushCh:
+			ch <- b.process(true)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// process スプールに残っているものを送り直してから、バッファのEntryをBatchSize件ずつ送る。
+// forceでなければ、前回の失敗から待ち時間が過ぎるまでスプールは送り直さない
+func (b *batcher) process(force bool) error {
+	var err error
+	if b.spool != nil && b.spool.pending && (force || !time.Now().Before(b.nextAttempt)) {
+		err = b.replay()
+	}
+	for {
+		batch := b.take()
+		if len(batch) == 0 {
+			return err
+		}
+		if e := b.deliver(batch); e != nil {
+			err = e
+		}
+	}
+}
+
+// take バッファからBatchSize件までを取り出す
+func (b *batcher) take() []*Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.buf)
+	if n > b.conf.BatchSize {
+		n = b.conf.BatchSize
+	}
+	batch := b.buf[:n:n]
+	b.buf = b.buf[n:]
+	return batch
+}
+
+// deliver batchを送る。スプールに送り直していないものがあれば、順番が入れ替わらないようにスプールに続けて保存する
+func (b *batcher) deliver(batch []*Entry) error {
+	if b.spool != nil && b.spool.pending {
+		return b.save(batch, errors.New("filelogger: endpoint unavailable, spooled"))
+	}
+
+	rest, err := b.sendWithRetry(batch)
+	if err == nil {
+		b.failures = 0
+		return nil
+	}
+	if b.spool != nil && !isPermanent(err) {
+		b.backoff()
+		return b.save(rest, err)
+	}
+	b.drop(len(rest), err)
+	return err
+}
+
+// save batchをスプールに保存する。保存できなかった場合は捨てる。errは送れなかった理由
+func (b *batcher) save(batch []*Entry, err error) error {
+	if e := b.spool.append(batch); e != nil {
+		b.drop(len(batch), e)
+		return e
+	}
+	return err
+}
+
+func (b *batcher) drop(n int, err error) {
+	logPrintln(err.Error())
+	b.mu.Lock()
+	b.dropped += uint64(n)
+	b.mu.Unlock()
+}
+
+// sendWithRetry 送信に失敗した場合、RetryWaitから倍にしながら待ってMaxRetries回まで再試行する。
+// 送れなかった場合は、送れなかったEntryを返す
+func (b *batcher) sendWithRetry(batch []*Entry) ([]*Entry, error) {
+	wait := b.conf.RetryWait
+	for i := 0; ; i++ {
+		rest, err := b.sendOnce(batch)
+		if err == nil || isPermanent(err) || i >= b.conf.MaxRetries {
+			return rest, err
+		}
+		batch = rest
+		time.Sleep(wait)
+		if wait *= 2; wait > b.conf.MaxRetryWait {
+			wait = b.conf.MaxRetryWait
+		}
+	}
+}
+
+// sendOnce batchを一度送り、送れなかったEntryを返す。一部だけ送れなかった場合は、再試行しても成功しないものを捨てて残りを返す
+func (b *batcher) sendOnce(batch []*Entry) ([]*Entry, error) {
+	err := b.send(batch)
+	var pe *partialError
+	if !errors.As(err, &pe) {
+		return batch, err
+	}
+	if pe.dropped > 0 {
+		reason := pe.err
+		if pe.dropErr != nil {
+			reason = pe.dropErr
+		}
+		b.drop(pe.dropped, reason)
+	}
+	if len(pe.retry) == 0 {
+		return nil, nil
+	}
+	return pe.retry, pe.err
+}
+
+// backoff 送信に失敗したことを記録し、スプールを送り直すまでの待ち時間を失敗するたびに倍にする
+func (b *batcher) backoff() {
+	wait := b.conf.RetryWait
+	for i := 0; i < b.failures && wait < b.conf.MaxRetryWait; i++ {
+		wait *= 2
+	}
+	if wait > b.conf.MaxRetryWait {
+		wait = b.conf.MaxRetryWait
+	}
+	b.failures++
+	b.nextAttempt = time.Now().Add(wait)
+}
+
+// replay スプールのファイルを古い順に送り、送り終えたファイルを削除する。
+// 途中で送れなくなった場合は残りをファイルに書き戻して次の機会に送る。再試行しても成功しないものは捨てる。
+// 出力中のファイルは切り離してから送るので、送っている間にspoolBufferedが書き込んだEntryは新しいファイルに入り、次の機会に送る
+func (b *batcher) replay() error {
+	for _, path := range b.spool.files() {
+		path, err := b.spool.detach(path)
+		if err != nil {
+			return err
+		}
+		entries, err := readSpoolFile(path)
+		if err != nil {
+			return err
+		}
+		for len(entries) > 0 {
+			n := len(entries)
+			if n > b.conf.BatchSize {
+				n = b.conf.BatchSize
+			}
+			rest, err := b.sendOnce(entries[:n])
+			if err != nil {
+				if !isPermanent(err) {
+					b.backoff()
+					if e := writeSpoolFile(path, append(append([]*Entry(nil), rest...), entries[n:]...)); e != nil {
+						logPrintln(e.Error())
+					}
+					return err
+				}
+				b.drop(len(rest), err)
+			}
+			entries = entries[n:]
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	b.failures = 0
+	b.spool.pending = false
+	return nil
+}
+
+// spool 送れなかったEntryを一行に一つのJSONで保存する。
+// 書き込みはfileLoggerで行うので、Rotateの設定でファイルの大きさと数が制限され、古いものから削除される
+type spool struct {
+	logger  *fileLogger
+	pending bool // 送り直していないEntryがある
+}
+
+func newSpool(path string, rotate RotateConfig) (*spool, error) {
+	conf := &Config{
+		FilePath:  path,
+		FilePerm:  0600,
+		Rotate:    rotate,
+		CreateDir: true,
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	if err := conf.prepareDir(); err != nil {
+		return nil, err
+	}
+	s := &spool{logger: newFileLogger(conf)}
+	for _, path := range s.files() {
+		if fi, err := os.Stat(path); err == nil && fi.Size() > 0 {
+			s.pending = true
+		}
+	}
+	return s, nil
+}
+
+// append Entryを書き込み、送り直すものがあることを記録する
+func (s *spool) append(batch []*Entry) error {
+	if err := s.write(batch); err != nil {
+		return err
+	}
+	s.pending = true
+	return nil
+}
+
+// write Entryを一件ずつ書き込む。一件ごとにローテーションが必要かをチェックする
+func (s *spool) write(batch []*Entry) error {
+	for _, e := range batch {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		s.logger.logOutput(e.Level, func() {
+			s.logger.Logger.Print(string(b))
+		})
+	}
+	return nil
+}
+
+// files スプールのファイルを古い順に返す。ローテーションしたファイルのあとに出力中のファイルが続く
+func (s *spool) files() []string {
+	fm := s.logger.file.fm
+	var rotated []string
+	var active string
+	for _, fi := range logFileList(fm.dir, fm.name) {
+		if fi.Name() == fm.name {
+			active = fm.path
+			continue
+		}
+		rotated = append(rotated, filepath.Join(fm.dir, fi.Name()))
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		ti, _ := rotatedTime(filepath.Base(rotated[i]))
+		tj, _ := rotatedTime(filepath.Base(rotated[j]))
+		return ti.Before(tj)
+	})
+	if active != "" {
+		rotated = append(rotated, active)
+	}
+	return rotated
+}
+
+// detach pathが出力中のファイルであれば、ローテーションしたファイルと同じ名前に変えて切り離し、変えた後の名前を返す。
+// この後の書き込みは新しいファイルに入るので、返したファイルは送り終えてから削除してよい
+func (s *spool) detach(path string) (string, error) {
+	fm := s.logger.file.fm
+	if path != fm.path {
+		return path, nil
+	}
+	s.logger.Mutex.Lock()
+	defer s.logger.Mutex.Unlock()
+	detached := filepath.Join(fm.dir, fm.getNameAddTimeNow())
+	if err := os.Rename(path, detached); err != nil {
+		return "", err
+	}
+	return detached, nil
+}
+
+func (s *spool) close() error {
+	return s.logger.close()
+}
+
+// readSpoolFile スプールのファイルからEntryを読み込む。壊れた行は読み飛ばす
+func readSpoolFile(path string) ([]*Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, bufSize), 64*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		e := &Entry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			logPrintln("spool: " + err.Error())
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// writeSpoolFile まだ送っていないEntryでスプールのファイルを置き換える
+func writeSpoolFile(path string, entries []*Entry) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".spool-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err = enc.Encode(e); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := tmp.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	filelogger "github.com/ha-ya4/file-logger"
+)
+
+// filter grepとstatsの絞り込みの条件。指定されたものをすべて満たすEntryだけを通す
+type filter struct {
+	levels  map[string]bool
+	since   time.Time
+	until   time.Time
+	fields  map[string]string
+	pattern *regexp.Regexp
+}
+
+func newFilter(opts *options, now time.Time) (*filter, error) {
+	f := &filter{}
+	if opts.levels != "" {
+		f.levels = map[string]bool{}
+		for _, l := range strings.Split(opts.levels, ",") {
+			f.levels[strings.ToUpper(strings.TrimSpace(l))] = true
+		}
+	}
+
+	var err error
+	if opts.since != "" {
+		if f.since, err = parseTime(opts.since, now); err != nil {
+			return nil, fmt.Errorf("-since: %v", err)
+		}
+	}
+	if opts.until != "" {
+		if f.until, err = parseTime(opts.until, now); err != nil {
+			return nil, fmt.Errorf("-until: %v", err)
+		}
+	}
+
+	for _, kv := range opts.fields {
+		i := strings.IndexByte(kv, '=')
+		if f.fields == nil {
+			f.fields = map[string]string{}
+		}
+		f.fields[kv[:i]] = kv[i+1:]
+	}
+
+	if opts.pattern != "" {
+		if f.pattern, err = regexp.Compile(opts.pattern); err != nil {
+			return nil, fmt.Errorf("-e: %v", err)
+		}
+	}
+	return f, nil
+}
+
+func (f *filter) match(e *filelogger.Entry) bool {
+	if f.levels != nil && !f.levels[e.Level] {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !e.Time.Before(f.until) {
+		return false
+	}
+	for k, want := range f.fields {
+		v, ok := e.Fields[k]
+		if !ok || fmt.Sprint(v) != want {
+			return false
+		}
+	}
+	if f.pattern != nil && !f.pattern.MatchString(e.Message) {
+		return false
+	}
+	return true
+}
+
+// timeLayouts -sinceと-untilで受け付ける日時の形式。タイムゾーンがなければローカル時刻とする
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime 日時か、nowからさかのぼる期間("90m"、"7d"など)を読む
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := filelogger.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
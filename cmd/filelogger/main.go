@@ -0,0 +1,434 @@
+// filelogger file-loggerで出力したログファイルを表示、検索、集計するコマンド。
+// ローテーションしたファイルを古い順に読み、圧縮されたファイルは解凍して読むので、どのファイルが圧縮されているかを気にせずに使える。
+//
+//	filelogger cat [options] <file>
+//	filelogger tail [-f] [-n 10] [options] <file>
+//	filelogger grep [-level ERROR,WARN] [-since 1h] [-until 2019-12-03T09:00:00] [-field key=value] [-e regexp] [options] <file>
+//	filelogger stats [grepと同じ条件] [options] <file>
+//	filelogger verify [-key-file path] [options] <file>
+//
+// <file>は出力中のファイルのパス。共通のoptionsは以下の通り。
+//
+//	-config  LoadConfigJSONの形式の設定ファイル。file_path、logger_flags、prefixを使う
+//	-flags   ログを出力したときのLoggerFlags。省略した場合はfilelogger.LoggerFlags
+//	-prefix  ログを出力したときのPrefix
+//	-json    一件を一行のJSONで出力する
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	filelogger "github.com/ha-ya4/file-logger"
+)
+
+const usage = `usage: filelogger <command> [options] <file>
+
+commands:
+  cat    ローテーションしたファイルも含めて古い順にすべて表示する
+  tail   最後のn件を表示する。-fで追記を表示し続ける
+  grep   ログレベル、期間、Fields、メッセージで絞り込んで表示する
+  stats  1時間ごとのログレベル別の件数を表示する
+  verify 監査ログの連鎖を検証し、行の削除、並べ替え、書き換えを検出する
+
+"filelogger <command> -h"でコマンドのoptionsを表示する
+`
+
+func main() {
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, stop))
+}
+
+// run コマンドを実行して終了コードを返す。stopが閉じられるとtail -fを終える
+func run(args []string, stdout, stderr io.Writer, stop <-chan struct{}) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var cmd func(*options, *output, <-chan struct{}) error
+	switch args[0] {
+	case "cat":
+		cmd = catCmd
+	case "tail":
+		cmd = tailCmd
+	case "grep":
+		cmd = grepCmd
+	case "stats":
+		cmd = statsCmd
+	case "verify":
+		cmd = verifyCmd
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "filelogger: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	opts := newOptions(args[0], stderr)
+	if err := opts.fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if err := opts.load(); err != nil {
+		fmt.Fprintln(stderr, "filelogger:", err)
+		return 2
+	}
+
+	out := &output{w: bufio.NewWriter(stdout), conf: opts.conf, json: opts.json}
+	err := cmd(opts, out, stop)
+	if ferr := out.w.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "filelogger:", err)
+		return 1
+	}
+	return 0
+}
+
+// options コマンドに共通のoptionsと、grepとstatsの絞り込みの条件
+type options struct {
+	fs   *flag.FlagSet
+	conf *filelogger.Config
+
+	config string
+	flags  int
+	prefix string
+	json   bool
+
+	follow bool
+	lines  int
+
+	keyFile string
+
+	levels  string
+	since   string
+	until   string
+	fields  fieldFlags
+	pattern string
+	filter  *filter
+}
+
+func newOptions(name string, stderr io.Writer) *options {
+	o := &options{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+	fs := o.fs
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.config, "config", "", "JSON config file (file_path, logger_flags and prefix are used)")
+	fs.IntVar(&o.flags, "flags", filelogger.LoggerFlags, "LoggerFlags the log was written with")
+	fs.StringVar(&o.prefix, "prefix", "", "Prefix the log was written with")
+	fs.BoolVar(&o.json, "json", false, "print entries as JSON lines")
+
+	switch name {
+	case "tail":
+		fs.BoolVar(&o.follow, "f", false, "keep printing appended entries")
+		fs.IntVar(&o.lines, "n", 10, "number of last entries to print")
+	case "grep", "stats":
+		fs.StringVar(&o.levels, "level", "", "comma separated levels to include")
+		fs.StringVar(&o.since, "since", "", "include entries at or after this time (RFC3339, \"2006-01-02 15:04\" or a duration such as 1h or 7d before now)")
+		fs.StringVar(&o.until, "until", "", "include entries before this time, same format as -since")
+		fs.Var(&o.fields, "field", "key=value the entry's Fields must contain (repeatable)")
+		fs.StringVar(&o.pattern, "e", "", "regular expression the message must match")
+	case "verify":
+		fs.StringVar(&o.keyFile, "key-file", "", "HMAC key file the audit log was written with (overrides audit.key_file in -config)")
+	}
+	return o
+}
+
+// load 設定ファイルと引数からConfigと絞り込みの条件を作る。-flagsと-prefixは設定ファイルより優先する
+func (o *options) load() error {
+	conf := &filelogger.Config{LoggerFlags: filelogger.LoggerFlags}
+	if o.config != "" {
+		c, err := filelogger.LoadConfigJSON(o.config)
+		if err != nil {
+			return err
+		}
+		conf = c
+	}
+	o.fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "flags":
+			conf.LoggerFlags = o.flags
+		case "prefix":
+			conf.Prefix = o.prefix
+		}
+	})
+	if o.keyFile != "" {
+		key, err := filelogger.ReadKeyFile(o.keyFile)
+		if err != nil {
+			return err
+		}
+		conf.Audit.Key = key
+	}
+
+	switch args := o.fs.Args(); {
+	case len(args) == 1:
+		conf.FilePath = args[0]
+	case len(args) > 1:
+		return fmt.Errorf("too many arguments: %s", strings.Join(args, " "))
+	case conf.FilePath == "":
+		return errors.New("no log file given")
+	}
+	o.conf = conf
+
+	f, err := newFilter(o, time.Now())
+	if err != nil {
+		return err
+	}
+	o.filter = f
+	return nil
+}
+
+// fieldFlags 繰り返し指定できる-field key=value
+type fieldFlags []string
+
+func (f *fieldFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fieldFlags) Set(s string) error {
+	if i := strings.IndexByte(s, '='); i <= 0 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// output Entryを出力した時と同じ形式かJSONで書き出す
+type output struct {
+	w    *bufio.Writer
+	conf *filelogger.Config
+	json bool
+}
+
+func (o *output) print(e *filelogger.Entry) error {
+	if o.json {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		o.w.Write(b)
+		return o.w.WriteByte('\n')
+	}
+	o.w.WriteString(o.conf.Prefix)
+	_, err := o.w.WriteString(filelogger.FormatEntry(e, o.conf.LoggerFlags))
+	return err
+}
+
+// readAll ローテーションしたファイルを含めてsince以降のEntryをfnに渡す。sinceがゼロ値ならすべて渡す
+func readAll(conf *filelogger.Config, since time.Time, fn func(*filelogger.Entry) error) error {
+	r, err := filelogger.NewReader(conf)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if !since.IsZero() {
+		r.SeekTime(since)
+	}
+	for r.Next() {
+		if err := fn(r.Entry()); err != nil {
+			return err
+		}
+	}
+	return r.Err()
+}
+
+func catCmd(opts *options, out *output, _ <-chan struct{}) error {
+	return readAll(opts.conf, time.Time{}, out.print)
+}
+
+func grepCmd(opts *options, out *output, _ <-chan struct{}) error {
+	return readAll(opts.conf, opts.filter.since, func(e *filelogger.Entry) error {
+		if !opts.filter.match(e) {
+			return nil
+		}
+		return out.print(e)
+	})
+}
+
+// tailCmd 最後のn件を表示する。-fの場合は読み落としも重複もしないように、先にFollowerを作ってから読み始める位置より前の最後のn件を表示する
+func tailCmd(opts *options, out *output, stop <-chan struct{}) error {
+	var last []*filelogger.Entry
+	var f *filelogger.Follower
+	if opts.follow {
+		var err error
+		if f, err = filelogger.NewFollower(opts.conf, false); err != nil {
+			return err
+		}
+		defer f.Close()
+		if last, err = f.Last(opts.lines); err != nil {
+			return err
+		}
+	} else if opts.lines > 0 {
+		last = make([]*filelogger.Entry, 0, opts.lines)
+		err := readAll(opts.conf, time.Time{}, func(e *filelogger.Entry) error {
+			if len(last) == opts.lines {
+				last = append(last[:0], last[1:]...)
+			}
+			last = append(last, e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range last {
+		if err := out.print(e); err != nil {
+			return err
+		}
+	}
+	if f == nil {
+		return nil
+	}
+	if err := out.w.Flush(); err != nil {
+		return err
+	}
+
+	go func() {
+		<-stop
+		f.Close()
+	}()
+	for f.Next() {
+		if err := out.print(f.Entry()); err != nil {
+			return err
+		}
+		if err := out.w.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.Err()
+}
+
+// levelOrder statsで表示するログレベルの順番。これ以外のレベルは後ろに名前の順で並べる
+var levelOrder = []string{filelogger.DEBUG, filelogger.INFO, filelogger.WARN, filelogger.ERROR, filelogger.FATAL, filelogger.PANIC}
+
+// hourStats 1時間のログレベル別の件数
+type hourStats struct {
+	Hour   string         `json:"hour"`
+	Counts map[string]int `json:"counts"`
+	Total  int            `json:"total"`
+}
+
+func statsCmd(opts *options, out *output, _ <-chan struct{}) error {
+	stats := map[string]*hourStats{}
+	seen := map[string]bool{}
+	err := readAll(opts.conf, opts.filter.since, func(e *filelogger.Entry) error {
+		if !opts.filter.match(e) {
+			return nil
+		}
+		t := e.Time
+		hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Format("2006-01-02 15:04")
+		s, ok := stats[hour]
+		if !ok {
+			s = &hourStats{Hour: hour, Counts: map[string]int{}}
+			stats[hour] = s
+		}
+		level := e.Level
+		if level == "" {
+			level = "-"
+		}
+		s.Counts[level]++
+		s.Total++
+		seen[level] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	hours := make([]string, 0, len(stats))
+	for h := range stats {
+		hours = append(hours, h)
+	}
+	sort.Strings(hours)
+
+	if out.json {
+		enc := json.NewEncoder(out.w)
+		for _, h := range hours {
+			if err := enc.Encode(stats[h]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	levels := sortLevels(seen)
+	tw := tabwriter.NewWriter(out.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "HOUR\t%s\tTOTAL\n", strings.Join(levels, "\t"))
+	for _, h := range hours {
+		s := stats[h]
+		fmt.Fprint(tw, h)
+		for _, l := range levels {
+			fmt.Fprintf(tw, "\t%d", s.Counts[l])
+		}
+		fmt.Fprintf(tw, "\t%d\n", s.Total)
+	}
+	return tw.Flush()
+}
+
+// sortLevels levelOrderの順に並べ、定義されていないレベルは名前の順で後ろに並べる
+func sortLevels(seen map[string]bool) []string {
+	var levels, others []string
+	for _, l := range levelOrder {
+		if seen[l] {
+			levels = append(levels, l)
+		}
+	}
+	for l := range seen {
+		known := false
+		for _, k := range levelOrder {
+			known = known || l == k
+		}
+		if !known {
+			others = append(others, l)
+		}
+	}
+	sort.Strings(others)
+	return append(levels, others...)
+}
+
+// verifyCmd 監査ログの連鎖を検証する。問題があれば一件ずつ表示して終了コードを1にする
+func verifyCmd(opts *options, out *output, _ <-chan struct{}) error {
+	report, err := filelogger.VerifyAudit(opts.conf)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		b, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		out.w.Write(b)
+		out.w.WriteByte('\n')
+	} else {
+		for _, e := range report.Errors {
+			fmt.Fprintln(out.w, e)
+		}
+		fmt.Fprintf(out.w, "%d files, %d entries, seq %d-%d\n", report.Files, report.Entries, report.FirstSeq, report.LastSeq)
+	}
+	if !report.OK() {
+		return fmt.Errorf("audit log verification failed: %d problems", len(report.Errors))
+	}
+	return nil
+}
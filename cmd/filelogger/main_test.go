@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	filelogger "github.com/ha-ya4/file-logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeLog ローテーションして圧縮したファイルと出力中のファイルを作る
+func writeLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "app.log")
+
+	rotated := "2019/12/03 08:10:00 [INFO] started\tport=8080\n" +
+		"2019/12/03 08:20:00 [DEBUG] config loaded\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Dec 3 08:20:01.000000000 2019_app.log"), gzipped(t, rotated), 0644))
+	active := "2019/12/03 09:05:00 [WARN] slow request\tpath=/a user=\"alice bob\"\n" +
+		"2019/12/03 09:30:00 [ERROR] request failed\n  at handler\tpath=/b\n" +
+		"2019/12/03 10:00:00 [INFO] done\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(active), 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write([]byte(s))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func runCmd(args ...string) (int, string, string) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(args, stdout, stderr, make(chan struct{}))
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCat(t *testing.T) {
+	path, cleanup := writeLog(t)
+	defer cleanup()
+
+	code, out, _ := runCmd("cat", path)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "2019/12/03 08:10:00 [INFO] started\tport=8080\n"+
+		"2019/12/03 08:20:00 [DEBUG] config loaded\n"+
+		"2019/12/03 09:05:00 [WARN] slow request\tpath=/a user=\"alice bob\"\n"+
+		"2019/12/03 09:30:00 [ERROR] request failed\n  at handler\tpath=/b\n"+
+		"2019/12/03 10:00:00 [INFO] done\n", out)
+
+	code, out, _ = runCmd("cat", "-json", path)
+	assert.Equal(t, 0, code)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, lines, 5)
+	assert.Contains(t, lines[2], `"fields":{"path":"/a","user":"alice bob"}`)
+
+	code, _, stderr := runCmd("cat")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "no log file given")
+}
+
+func TestGrep(t *testing.T) {
+	path, cleanup := writeLog(t)
+	defer cleanup()
+
+	grep := func(args ...string) []string {
+		code, out, stderr := runCmd(append(append([]string{"grep"}, args...), path)...)
+		assert.Equal(t, 0, code, stderr)
+		var messages []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			if i := strings.Index(line, "] "); i >= 0 {
+				messages = append(messages, line[i+2:])
+			}
+		}
+		return messages
+	}
+
+	assert.Equal(t, []string{"slow request\tpath=/a user=\"alice bob\"", "request failed"}, grep("-level", "warn,ERROR"))
+	assert.Equal(t, []string{"slow request\tpath=/a user=\"alice bob\"", "request failed"}, grep("-since", "2019-12-03 09:00", "-until", "2019-12-03T10:00:00"))
+	assert.Equal(t, []string{"slow request\tpath=/a user=\"alice bob\""}, grep("-field", "user=alice bob"))
+	assert.Equal(t, []string{"request failed"}, grep("-field", "path=/b", "-e", "^request"))
+	assert.Nil(t, grep("-since", "1h"))
+
+	code, _, _ := runCmd("grep", "-since", "yesterday", path)
+	assert.Equal(t, 2, code)
+	code, _, _ = runCmd("grep", "-field", "novalue", path)
+	assert.Equal(t, 2, code)
+}
+
+func TestStats(t *testing.T) {
+	path, cleanup := writeLog(t)
+	defer cleanup()
+
+	code, out, _ := runCmd("stats", path)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "HOUR              DEBUG  INFO  WARN  ERROR  TOTAL\n"+
+		"2019-12-03 08:00  1      1     0     0      2\n"+
+		"2019-12-03 09:00  0      0     1     1      2\n"+
+		"2019-12-03 10:00  0      1     0     0      1\n", out)
+
+	code, out, _ = runCmd("stats", "-json", "-level", "INFO", path)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, `{"hour":"2019-12-03 08:00","counts":{"INFO":1},"total":1}`+"\n"+
+		`{"hour":"2019-12-03 10:00","counts":{"INFO":1},"total":1}`+"\n", out)
+}
+
+func TestTail(t *testing.T) {
+	path, cleanup := writeLog(t)
+	defer cleanup()
+
+	code, out, _ := runCmd("tail", "-n", "2", path)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "2019/12/03 09:30:00 [ERROR] request failed\n  at handler\tpath=/b\n"+
+		"2019/12/03 10:00:00 [INFO] done\n", out)
+}
+
+// -fで追記とローテーション後のファイルを表示し続け、stopで終わるか
+func TestTailFollow(t *testing.T) {
+	path, cleanup := writeLog(t)
+	defer cleanup()
+
+	stdout := &syncBuffer{}
+	stop := make(chan struct{})
+	done := make(chan int)
+	go func() {
+		done <- run([]string{"tail", "-f", "-n", "1", path}, stdout, ioutil.Discard, stop)
+	}()
+
+	waitOutput(t, stdout, "[INFO] done\n")
+	appendFile(t, path, "2019/12/03 10:01:00 [INFO] appended\n")
+	waitOutput(t, stdout, "[INFO] appended\n")
+
+	assert.NoError(t, os.Rename(path, filepath.Join(filepath.Dir(path), "Dec 3 10:01:30.000000000 2019_app.log")))
+	appendFile(t, path, "2019/12/03 10:02:00 [WARN] after rotation\n")
+	waitOutput(t, stdout, "[WARN] after rotation\n")
+
+	close(stop)
+	select {
+	case code := <-done:
+		assert.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("tail -f did not stop")
+	}
+	assert.Equal(t, "2019/12/03 10:00:00 [INFO] done\n"+
+		"2019/12/03 10:01:00 [INFO] appended\n"+
+		"2019/12/03 10:02:00 [WARN] after rotation\n", stdout.String())
+}
+
+func appendFile(t *testing.T, path, s string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(s)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func waitOutput(t *testing.T, b *syncBuffer, suffix string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.HasSuffix(b.String(), suffix) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %q, got %q", suffix, b.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runCmd()
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "usage:")
+
+	code, _, stderr = runCmd("unknown")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "unknown"`)
+
+	code, out, _ := runCmd("help")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "stats")
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "audit.key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("secret\n"), 0600))
+	path := filepath.Join(dir, "audit.log")
+	filelogger.Initialize(&filelogger.Config{
+		FilePath:    path,
+		LoggerFlags: filelogger.LoggerFlags,
+		Rotate:      filelogger.RotateConfig{MaxLine: 4, MaxRotation: 10},
+		Compress:    true,
+		Audit:       filelogger.AuditConfig{Enabled: true, Key: []byte("secret")},
+	})
+	for i := 0; i < 10; i++ {
+		filelogger.Rprintf(filelogger.INFO, "event %d", i)
+	}
+	assert.NoError(t, filelogger.Close())
+
+	code, out, _ := runCmd("verify", "-key-file", keyFile, path)
+	assert.Equal(t, 0, code)
+	assert.Regexp(t, `^\d+ files, 10 entries, seq 1-10\n$`, out)
+
+	code, out, stderr := runCmd("verify", path)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, "hash mismatch")
+	assert.Contains(t, stderr, "audit log verification failed")
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(path, bytes.Replace(b, []byte("event 9"), []byte("event 0"), 1), 0644))
+	code, out, _ = runCmd("verify", "-json", "-key-file", keyFile, path)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, `"reason":"hash mismatch, the line or a previous line was modified"`)
+}
@@ -0,0 +1,596 @@
+package filelogger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configJSON 設定ファイルから読み込むための構造体。サイズや期間、パーミッションは文字列で書ける
+type configJSON struct {
+	Rotate        rotateJSON   `json:"rotate"`
+	Mode          string       `json:"mode"`
+	LoggerFlags   *int         `json:"logger_flags"`
+	FilePath      string       `json:"file_path"`
+	FilePerm      jsonValue    `json:"file_perm"`
+	Compress      bool         `json:"compress"`
+	Prefix        string       `json:"prefix"`
+	LogLevelConf  []levelJSON  `json:"log_level_conf"`
+	CreateDir     bool         `json:"create_dir"`
+	DirPerm       jsonValue    `json:"dir_perm"`
+	DirGroup      string       `json:"dir_group"`
+	CrossProcess  bool         `json:"cross_process"`
+	Index         bool         `json:"index"`
+	IndexInterval jsonValue    `json:"index_interval"`
+	Redact        *redactJSON  `json:"redact"`
+	Sanitize      sanitizeJSON `json:"sanitize"`
+	Audit         auditJSON    `json:"audit"`
+	Encrypt       encryptJSON  `json:"encrypt"`
+}
+
+type rotateJSON struct {
+	MaxLine     int       `json:"max_line"`
+	MaxRotation int       `json:"max_rotation"`
+	MaxSize     jsonValue `json:"max_size"`
+	MaxAge      jsonValue `json:"max_age"`
+}
+
+type redactJSON struct {
+	Rules    []redactRuleJSON `json:"rules"`
+	Fields   []string         `json:"fields"`
+	Strategy string           `json:"strategy"`
+	Salt     string           `json:"salt"`
+	Mask     string           `json:"mask"`
+}
+
+type sanitizeJSON struct {
+	ControlChars       string    `json:"control_chars"`
+	MaxLength          jsonValue `json:"max_length"`
+	ReplaceInvalidUTF8 bool      `json:"replace_invalid_utf8"`
+}
+
+// auditJSON 鍵は設定ファイルに書かずに、key_fileのファイルから読む
+type auditJSON struct {
+	Enabled bool   `json:"enabled"`
+	KeyFile string `json:"key_file"`
+}
+
+// encryptJSON 鍵はkey_fileのファイルに16進数で書く。key_idを省略した場合は"default"とする
+type encryptJSON struct {
+	KeyID     string    `json:"key_id"`
+	KeyFile   string    `json:"key_file"`
+	ChunkSize jsonValue `json:"chunk_size"`
+}
+
+// redactRuleJSON patternを省略した場合はnameで組み込みのルールを指定する
+type redactRuleJSON struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Strategy string `json:"strategy"`
+}
+
+type levelJSON struct {
+	Mode          string   `json:"mode"`
+	ExcludedLevel []string `json:"excluded_level"`
+}
+
+// jsonValue 数値でも文字列でも書ける値。"100MB"と104857600のどちらも受け付けるために使う
+type jsonValue string
+
+func (v *jsonValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = jsonValue(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("expected a string or a number, got %s", b)
+	}
+	*v = jsonValue(n.String())
+	return nil
+}
+
+// LoadConfigJSON JSON形式の設定ファイルを読み込みConfigを作成する。
+//
+//	{
+//	  "rotate": {"max_line": 1000, "max_rotation": 5, "max_size": "100MB", "max_age": "7d"},
+//	  "mode": "ProductionMode",
+//	  "file_path": "/var/log/app/app.log",
+//	  "file_perm": "0640",
+//	  "compress": true,
+//	  "log_level_conf": [{"mode": "ProductionMode", "excluded_level": ["DEBUG"]}],
+//	  "redact": {"rules": [{"name": "credit_card"}, {"name": "api_key", "pattern": "key-[0-9a-f]{32}", "strategy": "hash"}],
+//	             "fields": ["password", "authorization"], "salt": "..."},
+//	  "sanitize": {"control_chars": "escape", "max_length": "8KB", "replace_invalid_utf8": true},
+//	  "audit": {"enabled": true, "key_file": "/etc/app/audit.key"},
+//	  "encrypt": {"key_id": "2019-12", "key_file": "/etc/app/archive.key"}
+//	}
+func LoadConfigJSON(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigJSON(b)
+}
+
+func parseConfigJSON(b []byte) (*Config, error) {
+	var cj configJSON
+	if err := json.Unmarshal(b, &cj); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	conf := &Config{
+		Rotate: RotateConfig{
+			MaxLine:     cj.Rotate.MaxLine,
+			MaxRotation: cj.Rotate.MaxRotation,
+		},
+		Mode:         cj.Mode,
+		LoggerFlags:  LoggerFlags,
+		FilePath:     cj.FilePath,
+		Compress:     cj.Compress,
+		Prefix:       cj.Prefix,
+		CreateDir:    cj.CreateDir,
+		DirGroup:     cj.DirGroup,
+		CrossProcess: cj.CrossProcess,
+		Index:        cj.Index,
+	}
+	if cj.LoggerFlags != nil {
+		conf.LoggerFlags = *cj.LoggerFlags
+	}
+
+	var err error
+	if cj.Rotate.MaxSize != "" {
+		if conf.Rotate.MaxSize, err = ParseSize(string(cj.Rotate.MaxSize)); err != nil {
+			return nil, fmt.Errorf("rotate.max_size: %w", err)
+		}
+	}
+	if cj.Rotate.MaxAge != "" {
+		if conf.Rotate.MaxAge, err = ParseDuration(string(cj.Rotate.MaxAge)); err != nil {
+			return nil, fmt.Errorf("rotate.max_age: %w", err)
+		}
+	}
+	if cj.IndexInterval != "" {
+		if conf.IndexInterval, err = ParseSize(string(cj.IndexInterval)); err != nil {
+			return nil, fmt.Errorf("index_interval: %w", err)
+		}
+	}
+	if cj.FilePerm != "" {
+		if conf.FilePerm, err = ParsePerm(string(cj.FilePerm)); err != nil {
+			return nil, fmt.Errorf("file_perm: %w", err)
+		}
+	}
+	if cj.DirPerm != "" {
+		if conf.DirPerm, err = ParsePerm(string(cj.DirPerm)); err != nil {
+			return nil, fmt.Errorf("dir_perm: %w", err)
+		}
+	}
+	if cj.Redact != nil {
+		if conf.Redact, err = cj.Redact.config(); err != nil {
+			return nil, fmt.Errorf("redact.%w", err)
+		}
+	}
+	conf.Sanitize.ReplaceInvalidUTF8 = cj.Sanitize.ReplaceInvalidUTF8
+	if cj.Sanitize.ControlChars != "" {
+		if conf.Sanitize.ControlChars, err = ParseControlCharPolicy(cj.Sanitize.ControlChars); err != nil {
+			return nil, fmt.Errorf("sanitize.control_chars: %w", err)
+		}
+	}
+	if cj.Sanitize.MaxLength != "" {
+		n, err := ParseSize(string(cj.Sanitize.MaxLength))
+		if err != nil {
+			return nil, fmt.Errorf("sanitize.max_length: %w", err)
+		}
+		conf.Sanitize.MaxLength = int(n)
+	}
+	conf.Audit.Enabled = cj.Audit.Enabled
+	if cj.Audit.KeyFile != "" {
+		if conf.Audit.Key, err = ReadKeyFile(cj.Audit.KeyFile); err != nil {
+			return nil, fmt.Errorf("audit.key_file: %w", err)
+		}
+	}
+	if cj.Encrypt.KeyFile != "" {
+		if conf.Encrypt.Keys, err = readEncryptKeyFile(cj.Encrypt.KeyID, cj.Encrypt.KeyFile); err != nil {
+			return nil, fmt.Errorf("encrypt.key_file: %w", err)
+		}
+	}
+	if cj.Encrypt.ChunkSize != "" {
+		n, err := ParseSize(string(cj.Encrypt.ChunkSize))
+		if err != nil {
+			return nil, fmt.Errorf("encrypt.chunk_size: %w", err)
+		}
+		conf.Encrypt.ChunkSize = int(n)
+	}
+	for _, lc := range cj.LogLevelConf {
+		conf.LogLevelConf = append(conf.LogLevelConf, LevelConfig{
+			Mode:          lc.Mode,
+			ExcludedLevel: lc.ExcludedLevel,
+		})
+	}
+
+	return conf, nil
+}
+
+func (rj *redactJSON) config() (RedactConfig, error) {
+	rc := RedactConfig{Fields: rj.Fields, Salt: rj.Salt, Mask: rj.Mask}
+	var err error
+	if rj.Strategy != "" {
+		if rc.Strategy, err = ParseRedactStrategy(rj.Strategy); err != nil {
+			return rc, fmt.Errorf("strategy: %w", err)
+		}
+	}
+	for i, r := range rj.Rules {
+		rule := RedactRule{Name: r.Name, Pattern: r.Pattern}
+		if r.Pattern == "" {
+			var ok bool
+			if rule, ok = lookupRedactRule(r.Name); !ok {
+				return rc, fmt.Errorf("rules[%d]: unknown rule %q", i, r.Name)
+			}
+		}
+		if r.Strategy != "" {
+			if rule.Strategy, err = ParseRedactStrategy(r.Strategy); err != nil {
+				return rc, fmt.Errorf("rules[%d].strategy: %w", i, err)
+			}
+		}
+		rc.Rules = append(rc.Rules, rule)
+	}
+	return rc, nil
+}
+
+// LoadConfigEnv 環境変数からConfigを作成する。環境変数名はprefixに以下の名前をつなげたもの。
+//
+//	FILE_PATH, MODE, FILE_PERM, COMPRESS, PREFIX, LOGGER_FLAGS,
+//	MAX_LINE, MAX_ROTATION, MAX_SIZE, MAX_AGE, LOG_LEVEL_CONF,
+//	CREATE_DIR, DIR_PERM, DIR_GROUP, CROSS_PROCESS, INDEX, INDEX_INTERVAL,
+//	REDACT_RULES, REDACT_FIELDS, REDACT_STRATEGY, REDACT_SALT, REDACT_MASK,
+//	SANITIZE_CONTROL_CHARS, SANITIZE_MAX_LENGTH, SANITIZE_REPLACE_INVALID_UTF8, AUDIT, AUDIT_KEY_FILE,
+//	ENCRYPT_KEY_FILE, ENCRYPT_KEY_ID, ENCRYPT_CHUNK_SIZE
+//
+// LOG_LEVEL_CONFは"ProductionMode=DEBUG,INFO;DebugMode="のようにモードごとに;で区切る。
+// REDACT_RULESは"credit_card,email,bearer_token"のように組み込みのルールの名前を、REDACT_FIELDSは名前を,で区切る
+func LoadConfigEnv(prefix string) (*Config, error) {
+	conf := &Config{LoggerFlags: LoggerFlags}
+	if err := conf.ApplyEnv(prefix); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// ApplyEnv 設定されている環境変数の値でConfigを上書きする。設定ファイルの値を環境ごとに変えたいときに使う
+func (c *Config) ApplyEnv(prefix string) error {
+	var err error
+	lookup := func(key string) (string, bool) {
+		return os.LookupEnv(prefix + key)
+	}
+	wrap := func(key string, err error) error {
+		return fmt.Errorf("%s%s: %w", prefix, key, err)
+	}
+
+	if v, ok := lookup("FILE_PATH"); ok {
+		c.FilePath = v
+	}
+	if v, ok := lookup("MODE"); ok {
+		c.Mode = v
+	}
+	if v, ok := lookup("PREFIX"); ok {
+		c.Prefix = v
+	}
+	if v, ok := lookup("FILE_PERM"); ok {
+		if c.FilePerm, err = ParsePerm(v); err != nil {
+			return wrap("FILE_PERM", err)
+		}
+	}
+	if v, ok := lookup("COMPRESS"); ok {
+		if c.Compress, err = strconv.ParseBool(v); err != nil {
+			return wrap("COMPRESS", err)
+		}
+	}
+	if v, ok := lookup("LOGGER_FLAGS"); ok {
+		if c.LoggerFlags, err = strconv.Atoi(v); err != nil {
+			return wrap("LOGGER_FLAGS", err)
+		}
+	}
+	if v, ok := lookup("MAX_LINE"); ok {
+		if c.Rotate.MaxLine, err = strconv.Atoi(v); err != nil {
+			return wrap("MAX_LINE", err)
+		}
+	}
+	if v, ok := lookup("MAX_ROTATION"); ok {
+		if c.Rotate.MaxRotation, err = strconv.Atoi(v); err != nil {
+			return wrap("MAX_ROTATION", err)
+		}
+	}
+	if v, ok := lookup("MAX_SIZE"); ok {
+		if c.Rotate.MaxSize, err = ParseSize(v); err != nil {
+			return wrap("MAX_SIZE", err)
+		}
+	}
+	if v, ok := lookup("MAX_AGE"); ok {
+		if c.Rotate.MaxAge, err = ParseDuration(v); err != nil {
+			return wrap("MAX_AGE", err)
+		}
+	}
+	if v, ok := lookup("CREATE_DIR"); ok {
+		if c.CreateDir, err = strconv.ParseBool(v); err != nil {
+			return wrap("CREATE_DIR", err)
+		}
+	}
+	if v, ok := lookup("DIR_PERM"); ok {
+		if c.DirPerm, err = ParsePerm(v); err != nil {
+			return wrap("DIR_PERM", err)
+		}
+	}
+	if v, ok := lookup("DIR_GROUP"); ok {
+		c.DirGroup = v
+	}
+	if v, ok := lookup("CROSS_PROCESS"); ok {
+		if c.CrossProcess, err = strconv.ParseBool(v); err != nil {
+			return wrap("CROSS_PROCESS", err)
+		}
+	}
+	if v, ok := lookup("INDEX"); ok {
+		if c.Index, err = strconv.ParseBool(v); err != nil {
+			return wrap("INDEX", err)
+		}
+	}
+	if v, ok := lookup("INDEX_INTERVAL"); ok {
+		if c.IndexInterval, err = ParseSize(v); err != nil {
+			return wrap("INDEX_INTERVAL", err)
+		}
+	}
+	if v, ok := lookup("REDACT_RULES"); ok {
+		c.Redact.Rules = nil
+		for _, name := range splitList(v) {
+			rule, ok := lookupRedactRule(name)
+			if !ok {
+				return wrap("REDACT_RULES", fmt.Errorf("unknown rule %q", name))
+			}
+			c.Redact.Rules = append(c.Redact.Rules, rule)
+		}
+	}
+	if v, ok := lookup("REDACT_FIELDS"); ok {
+		c.Redact.Fields = splitList(v)
+	}
+	if v, ok := lookup("REDACT_STRATEGY"); ok {
+		if c.Redact.Strategy, err = ParseRedactStrategy(v); err != nil {
+			return wrap("REDACT_STRATEGY", err)
+		}
+	}
+	if v, ok := lookup("REDACT_SALT"); ok {
+		c.Redact.Salt = v
+	}
+	if v, ok := lookup("REDACT_MASK"); ok {
+		c.Redact.Mask = v
+	}
+	if v, ok := lookup("SANITIZE_CONTROL_CHARS"); ok {
+		if c.Sanitize.ControlChars, err = ParseControlCharPolicy(v); err != nil {
+			return wrap("SANITIZE_CONTROL_CHARS", err)
+		}
+	}
+	if v, ok := lookup("SANITIZE_MAX_LENGTH"); ok {
+		n, err := ParseSize(v)
+		if err != nil {
+			return wrap("SANITIZE_MAX_LENGTH", err)
+		}
+		c.Sanitize.MaxLength = int(n)
+	}
+	if v, ok := lookup("SANITIZE_REPLACE_INVALID_UTF8"); ok {
+		if c.Sanitize.ReplaceInvalidUTF8, err = strconv.ParseBool(v); err != nil {
+			return wrap("SANITIZE_REPLACE_INVALID_UTF8", err)
+		}
+	}
+	if v, ok := lookup("AUDIT"); ok {
+		if c.Audit.Enabled, err = strconv.ParseBool(v); err != nil {
+			return wrap("AUDIT", err)
+		}
+	}
+	if v, ok := lookup("AUDIT_KEY_FILE"); ok {
+		if c.Audit.Key, err = ReadKeyFile(v); err != nil {
+			return wrap("AUDIT_KEY_FILE", err)
+		}
+	}
+	if v, ok := lookup("ENCRYPT_KEY_FILE"); ok {
+		id, _ := lookup("ENCRYPT_KEY_ID")
+		if c.Encrypt.Keys, err = readEncryptKeyFile(id, v); err != nil {
+			return wrap("ENCRYPT_KEY_FILE", err)
+		}
+	}
+	if v, ok := lookup("ENCRYPT_CHUNK_SIZE"); ok {
+		n, err := ParseSize(v)
+		if err != nil {
+			return wrap("ENCRYPT_CHUNK_SIZE", err)
+		}
+		c.Encrypt.ChunkSize = int(n)
+	}
+	if v, ok := lookup("LOG_LEVEL_CONF"); ok {
+		if c.LogLevelConf, err = parseLogLevelConf(v); err != nil {
+			return wrap("LOG_LEVEL_CONF", err)
+		}
+	}
+
+	return nil
+}
+
+// ReadKeyFile 鍵のファイルを読む。末尾の改行は鍵に含めない
+func ReadKeyFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimRight(b, "\r\n")
+	if len(b) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", path)
+	}
+	return b, nil
+}
+
+// readEncryptKeyFile 16進数で書かれたAESの鍵をファイルから読み、idの鍵だけを持つStaticKeysにする。idが空の場合は"default"とする
+func readEncryptKeyFile(id, path string) (*StaticKeys, error) {
+	b, err := ReadKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s is not hex encoded: %w", path, err)
+	}
+	if id == "" {
+		id = "default"
+	}
+	return &StaticKeys{Current: id, Keys: map[string][]byte{id: key}}, nil
+}
+
+// splitList ,で区切った文字列の空でない要素を返す
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// parseLogLevelConf "ProductionMode=DEBUG,INFO;DebugMode="の形式の文字列をLogLevelConfigにする
+func parseLogLevelConf(s string) (LogLevelConfig, error) {
+	var llc LogLevelConfig
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		mode := strings.TrimSpace(kv[0])
+		if mode == "" {
+			return nil, fmt.Errorf("missing mode in %q", part)
+		}
+		lc := LevelConfig{Mode: mode, ExcludedLevel: []string{}}
+		if len(kv) == 2 {
+			for _, level := range strings.Split(kv[1], ",") {
+				if level = strings.TrimSpace(level); level != "" {
+					lc.ExcludedLevel = append(lc.ExcludedLevel, level)
+				}
+			}
+		}
+		llc = append(llc, lc)
+	}
+	return llc, nil
+}
+
+// sizeUnits 単位とバイト数の対応。KBとKiBはどちらも1024として扱う
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+	"T":   1 << 40,
+	"TB":  1 << 40,
+	"TIB": 1 << 40,
+}
+
+// ParseSize "100MB"や"1.5G"のような文字列をバイト数にする。単位がない場合はバイトとして扱う
+func ParseSize(s string) (int64, error) {
+	str := strings.TrimSpace(s)
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(str)
+	}
+
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(str[i:]))]
+	if !ok || i == 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(str[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	// int64に収まらない値は変換すると不定な値になるので、変換する前に確かめる
+	size := n * float64(unit)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(size), nil
+}
+
+// ParseDuration time.ParseDurationに日(d)と週(w)の単位を加えたもの。"7d"や"1d12h"を受け付ける
+func ParseDuration(s string) (time.Duration, error) {
+	str := strings.TrimSpace(s)
+	if str == "0" {
+		return 0, nil
+	}
+	if str == "" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	var total time.Duration
+	for str != "" {
+		i := strings.IndexFunc(str, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if i <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		j := strings.IndexFunc(str[i:], func(r rune) bool {
+			return (r >= '0' && r <= '9') || r == '.'
+		})
+		if j < 0 {
+			j = len(str) - i
+		}
+		num, unit := str[:i], str[i:i+j]
+		str = str[i+j:]
+
+		var d time.Duration
+		switch unit {
+		case "d", "w":
+			n, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			f := n * float64(24*time.Hour)
+			if unit == "w" {
+				f *= 7
+			}
+			if f >= math.MaxInt64 {
+				return 0, fmt.Errorf("duration %q is too large", s)
+			}
+			d = time.Duration(f)
+		default:
+			var err error
+			if d, err = time.ParseDuration(num + unit); err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+		}
+		if total > math.MaxInt64-d {
+			return 0, fmt.Errorf("duration %q is too large", s)
+		}
+		total += d
+	}
+
+	return total, nil
+}
+
+// ParsePerm "0640"や"640"のような8進数の文字列をos.FileModeにする
+func ParsePerm(s string) (os.FileMode, error) {
+	str := strings.TrimPrefix(strings.TrimSpace(s), "0o")
+	n, err := strconv.ParseUint(str, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid permission %q", s)
+	}
+	return os.FileMode(n), nil
+}
@@ -0,0 +1,222 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect int64
+	}{
+		{"100", 100},
+		{"100B", 100},
+		{"4K", 4 << 10},
+		{"100MB", 100 << 20},
+		{"1.5GiB", 3 << 29},
+		{" 2 gb ", 2 << 30},
+	}
+	for _, tt := range tests {
+		n, err := ParseSize(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.expect, n, tt.in)
+	}
+
+	for _, in := range []string{"", "MB", "10XB", "1.2.3MB", "8388608TB", "99999999999999999999"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect time.Duration
+	}{
+		{"0", 0},
+		{"90s", 90 * time.Second},
+		{"7d", 7 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1.5h", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		d, err := ParseDuration(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.expect, d, tt.in)
+	}
+
+	// 範囲を超える値は負の値や小さな値にならずにエラーになる
+	for _, in := range []string{"", "d", "7", "7x", "1d-2h", "300000w", "106752d", "106751d106751d", "2562047h2562047h"} {
+		_, err := ParseDuration(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestParsePerm(t *testing.T) {
+	perm, err := ParsePerm("0640")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), perm)
+
+	perm, err = ParsePerm("755")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), perm)
+
+	_, err = ParsePerm("0987")
+	assert.Error(t, err)
+	_, err = ParsePerm("17777")
+	assert.Error(t, err)
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	content := `{
+		"rotate": {"max_line": 1000, "max_rotation": 5, "max_size": "100MB", "max_age": "7d"},
+		"mode": "ProductionMode",
+		"file_path": "/var/log/app/app.log",
+		"file_perm": "0640",
+		"compress": true,
+		"prefix": "app ",
+		"create_dir": true,
+		"dir_perm": "0750",
+		"log_level_conf": [
+			{"mode": "ProductionMode", "excluded_level": ["DEBUG", "INFO"]},
+			{"mode": "DebugMode", "excluded_level": []}
+		],
+		"redact": {
+			"rules": [{"name": "email"}, {"name": "order", "pattern": "order-[0-9]+", "strategy": "hash"}],
+			"fields": ["password"],
+			"strategy": "drop",
+			"salt": "pepper"
+		},
+		"sanitize": {"control_chars": "escape", "max_length": "4KB", "replace_invalid_utf8": true}
+	}`
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	conf, err := LoadConfigJSON(path)
+	assert.NoError(t, err)
+	assert.Equal(t, RotateConfig{
+		MaxLine:     1000,
+		MaxRotation: 5,
+		MaxSize:     100 << 20,
+		MaxAge:      7 * 24 * time.Hour,
+	}, conf.Rotate)
+	assert.Equal(t, ModeProduction, conf.Mode)
+	assert.Equal(t, LoggerFlags, conf.LoggerFlags)
+	assert.Equal(t, "/var/log/app/app.log", conf.FilePath)
+	assert.Equal(t, os.FileMode(0640), conf.FilePerm)
+	assert.True(t, conf.Compress)
+	assert.Equal(t, "app ", conf.Prefix)
+	assert.True(t, conf.CreateDir)
+	assert.Equal(t, os.FileMode(0750), conf.DirPerm)
+	assert.Equal(t, LogLevelConfig{
+		{Mode: ModeProduction, ExcludedLevel: []string{DEBUG, INFO}},
+		{Mode: ModeDebug, ExcludedLevel: []string{}},
+	}, conf.LogLevelConf)
+	if assert.Len(t, conf.Redact.Rules, 2) {
+		assert.Equal(t, RedactEmail.Pattern, conf.Redact.Rules[0].Pattern)
+		assert.Equal(t, RedactRule{Name: "order", Pattern: "order-[0-9]+", Strategy: RedactHash}, conf.Redact.Rules[1])
+	}
+	assert.Equal(t, []string{"password"}, conf.Redact.Fields)
+	assert.Equal(t, RedactDrop, conf.Redact.Strategy)
+	assert.Equal(t, "pepper", conf.Redact.Salt)
+	assert.Equal(t, SanitizeConfig{ControlChars: ControlCharsEscape, MaxLength: 4 << 10, ReplaceInvalidUTF8: true}, conf.Sanitize)
+
+	// サイズは数値でも書ける
+	conf, err = parseConfigJSON([]byte(`{"rotate": {"max_size": 2048}, "logger_flags": 0}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2048), conf.Rotate.MaxSize)
+	assert.Equal(t, 0, conf.LoggerFlags)
+
+	_, err = parseConfigJSON([]byte(`{"rotate": {"max_age": "forever"}}`))
+	assert.Error(t, err)
+	// 監査ログの鍵はファイルから読む
+	keyFile := filepath.Join(dir, "audit.key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("secret\n"), 0600))
+	conf, err = parseConfigJSON([]byte(`{"audit": {"enabled": true, "key_file": "` + keyFile + `"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, AuditConfig{Enabled: true, Key: []byte("secret")}, conf.Audit)
+	_, err = parseConfigJSON([]byte(`{"audit": {"key_file": "` + filepath.Join(dir, "missing") + `"}}`))
+	assert.Error(t, err)
+	// 暗号化の鍵は16進数で書く
+	encKeyFile := filepath.Join(dir, "archive.key")
+	assert.NoError(t, ioutil.WriteFile(encKeyFile, []byte("000102030405060708090a0b0c0d0e0f\n"), 0600))
+	conf, err = parseConfigJSON([]byte(`{"encrypt": {"key_id": "k1", "key_file": "` + encKeyFile + `", "chunk_size": "4KB"}}`))
+	assert.NoError(t, err)
+	id, key, err := conf.Encrypt.Keys.CurrentKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", id)
+	assert.Len(t, key, 16)
+	assert.Equal(t, 4096, conf.Encrypt.ChunkSize)
+	_, err = parseConfigJSON([]byte(`{"encrypt": {"key_file": "` + keyFile + `"}}`))
+	assert.Error(t, err)
+
+	_, err = parseConfigJSON([]byte(`{"redact": {"rules": [{"name": "phone"}]}}`))
+	assert.EqualError(t, err, `redact.rules[0]: unknown rule "phone"`)
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	env := map[string]string{
+		"TESTAPP_LOG_FILE_PATH":              "/tmp/app.log",
+		"TESTAPP_LOG_MODE":                   ModeDebug,
+		"TESTAPP_LOG_FILE_PERM":              "0600",
+		"TESTAPP_LOG_COMPRESS":               "true",
+		"TESTAPP_LOG_MAX_LINE":               "500",
+		"TESTAPP_LOG_MAX_ROTATION":           "3",
+		"TESTAPP_LOG_MAX_SIZE":               "10MB",
+		"TESTAPP_LOG_MAX_AGE":                "1d",
+		"TESTAPP_LOG_LOG_LEVEL_CONF":         "ProductionMode=DEBUG,INFO;DebugMode=",
+		"TESTAPP_LOG_REDACT_RULES":           "credit_card, bearer_token",
+		"TESTAPP_LOG_REDACT_FIELDS":          "password,api_key",
+		"TESTAPP_LOG_SANITIZE_CONTROL_CHARS": "reject",
+		"TESTAPP_LOG_SANITIZE_MAX_LENGTH":    "1024",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	conf, err := LoadConfigEnv("TESTAPP_LOG_")
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/app.log", conf.FilePath)
+	assert.Equal(t, ModeDebug, conf.Mode)
+	assert.Equal(t, os.FileMode(0600), conf.FilePerm)
+	assert.True(t, conf.Compress)
+	assert.Equal(t, RotateConfig{
+		MaxLine:     500,
+		MaxRotation: 3,
+		MaxSize:     10 << 20,
+		MaxAge:      24 * time.Hour,
+	}, conf.Rotate)
+	assert.Equal(t, LogLevelConfig{
+		{Mode: ModeProduction, ExcludedLevel: []string{DEBUG, INFO}},
+		{Mode: ModeDebug, ExcludedLevel: []string{}},
+	}, conf.LogLevelConf)
+	if assert.Len(t, conf.Redact.Rules, 2) {
+		assert.Equal(t, "credit_card", conf.Redact.Rules[0].Name)
+		assert.Equal(t, "bearer_token", conf.Redact.Rules[1].Name)
+	}
+	assert.Equal(t, []string{"password", "api_key"}, conf.Redact.Fields)
+	assert.Equal(t, SanitizeConfig{ControlChars: ControlCharsReject, MaxLength: 1024}, conf.Sanitize)
+
+	// 設定ファイルの値を環境変数で上書きする
+	conf = &Config{FilePath: "/var/log/app.log", Mode: ModeProduction}
+	os.Unsetenv("TESTAPP_LOG_FILE_PATH")
+	assert.NoError(t, conf.ApplyEnv("TESTAPP_LOG_"))
+	assert.Equal(t, "/var/log/app.log", conf.FilePath)
+	assert.Equal(t, ModeDebug, conf.Mode)
+
+	os.Setenv("TESTAPP_LOG_MAX_LINE", "many")
+	_, err = LoadConfigEnv("TESTAPP_LOG_")
+	assert.EqualError(t, err, `TESTAPP_LOG_MAX_LINE: strconv.Atoi: parsing "many": invalid syntax`)
+}
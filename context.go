@@ -0,0 +1,224 @@
+package filelogger
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ContextExtractor contextからログに付与するFieldsを取り出す関数。付与するものがなければnilを返す
+type ContextExtractor func(ctx context.Context) Fields
+
+var (
+	extractorsMu      sync.RWMutex
+	contextExtractors = []ContextExtractor{RequestIDExtractor, TraceContextExtractor}
+)
+
+// SetContextExtractors Context付きの関数で使うContextExtractorを置き換える。
+// 初期値はRequestIDExtractorとTraceContextExtractor。後のものが同じキーを返した場合は後のものを使う
+func SetContextExtractors(extractors ...ContextExtractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	contextExtractors = append([]ContextExtractor(nil), extractors...)
+}
+
+// ContextExtractors 今使っているContextExtractorを返す。追加する場合はSetContextExtractorsにappendして渡す
+func ContextExtractors() []ContextExtractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	return append([]ContextExtractor(nil), contextExtractors...)
+}
+
+type contextKey int
+
+const (
+	fieldsKey contextKey = iota
+	loggerKey
+	requestIDKey
+	traceContextKey
+)
+
+// ContextWithFields ctxにfieldsを加えたcontextを返す。ctxにすでにFieldsがあればまとめ、同じキーはfieldsの値を使う
+func ContextWithFields(ctx context.Context, fields Fields) context.Context {
+	return context.WithValue(ctx, fieldsKey, copyFields(FieldsFromContext(ctx), fields))
+}
+
+// FieldsFromContext ContextWithFieldsで加えたFieldsを返す
+func FieldsFromContext(ctx context.Context) Fields {
+	fields, _ := ctx.Value(fieldsKey).(Fields)
+	return fields
+}
+
+// ContextWithLogger ctxにloggerを入れたcontextを返す。FromContextとContext付きの関数はこのloggerに出力する
+func ContextWithLogger(ctx context.Context, logger *FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext ContextWithLoggerで入れたloggerを返す。入っていなければパッケージのLoggerに出力するFieldLoggerを返す
+func FromContext(ctx context.Context) *FieldLogger {
+	if logger, ok := ctx.Value(loggerKey).(*FieldLogger); ok && logger != nil {
+		return logger
+	}
+	return &FieldLogger{}
+}
+
+// ContextWithRequestID ctxにリクエストIDを入れたcontextを返す
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext ContextWithRequestIDで入れたリクエストIDを返す
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok && id != ""
+}
+
+// RequestIDExtractor リクエストIDをrequest_idとして付与する
+func RequestIDExtractor(ctx context.Context) Fields {
+	if id, ok := RequestIDFromContext(ctx); ok {
+		return Fields{"request_id": id}
+	}
+	return nil
+}
+
+// TraceContext W3C Trace Contextのtraceparentの値
+type TraceContext struct {
+	TraceID string // 32文字の16進数
+	SpanID  string // 16文字の16進数(parent-id)
+	Flags   byte
+}
+
+// Sampled sampledフラグが立っているかを返す
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&0x01 != 0
+}
+
+// String traceparentの形式にする
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// ParseTraceparent W3C Trace Contextのtraceparentヘッダーの値を解析する。
+// バージョン00は4つの項目だけを受け付け、それより後のバージョンは後ろに続く項目を無視する
+func ParseTraceparent(s string) (TraceContext, error) {
+	var tc TraceContext
+	invalid := func(reason string) (TraceContext, error) {
+		return TraceContext{}, errors.New("filelogger: invalid traceparent " + reason)
+	}
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return invalid("format")
+	}
+	version := parts[0]
+	if !isLowerHex(version, 2) || version == "ff" {
+		return invalid("version")
+	}
+	if version == "00" && len(parts) != 4 {
+		return invalid("format")
+	}
+	if !isLowerHex(parts[1], 32) || parts[1] == strings.Repeat("0", 32) {
+		return invalid("trace-id")
+	}
+	if !isLowerHex(parts[2], 16) || parts[2] == strings.Repeat("0", 16) {
+		return invalid("parent-id")
+	}
+	if !isLowerHex(parts[3], 2) {
+		return invalid("trace-flags")
+	}
+
+	flags, _ := hex.DecodeString(parts[3])
+	tc.TraceID, tc.SpanID, tc.Flags = parts[1], parts[2], flags[0]
+	return tc, nil
+}
+
+// isLowerHex sがn文字の小文字の16進数かを返す
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ContextWithTraceContext ctxにTraceContextを入れたcontextを返す
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey, tc)
+}
+
+// ContextWithTraceparent traceparentヘッダーの値を解析してctxに入れる。解析できなければctxとエラーを返す
+func ContextWithTraceparent(ctx context.Context, traceparent string) (context.Context, error) {
+	tc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx, err
+	}
+	return ContextWithTraceContext(ctx, tc), nil
+}
+
+// TraceContextFromContext ContextWithTraceContextかContextWithTraceparentで入れたTraceContextを返す
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey).(TraceContext)
+	return tc, ok
+}
+
+// TraceContextExtractor TraceContextをtrace_idとspan_idとして付与する
+func TraceContextExtractor(ctx context.Context) Fields {
+	if tc, ok := TraceContextFromContext(ctx); ok {
+		return Fields{"trace_id": tc.TraceID, "span_id": tc.SpanID}
+	}
+	return nil
+}
+
+// contextFields ContextWithFieldsで加えたFieldsに、ContextExtractorが取り出したFieldsを加える
+func contextFields(ctx context.Context) Fields {
+	fields := FieldsFromContext(ctx)
+	for _, ex := range ContextExtractors() {
+		if f := ex(ctx); len(f) > 0 {
+			fields = copyFields(fields, f)
+		}
+	}
+	return fields
+}
+
+// RprintfContext ctxのloggerに、ctxから取り出したFieldsを付与してRprintfと同様に出力する
+func RprintfContext(ctx context.Context, logLevel string, format string, v ...interface{}) {
+	FromContext(ctx).writeContext(ctx, logLevel, fmt.Sprintf(format, v...))
+}
+
+// RprintlnContext ctxのloggerに、ctxから取り出したFieldsを付与してRprintlnと同様に出力する
+func RprintlnContext(ctx context.Context, logLevel string, v ...interface{}) {
+	FromContext(ctx).writeContext(ctx, logLevel, fmt.Sprintln(v...))
+}
+
+// RprintContext ctxのloggerに、ctxから取り出したFieldsを付与してRprintと同様に出力する
+func RprintContext(ctx context.Context, logLevel string, v ...interface{}) {
+	FromContext(ctx).writeContext(ctx, logLevel, fmt.Sprint(v...))
+}
+
+// RprintfContext ctxから取り出したFieldsを付与したRprintf
+func (f *FieldLogger) RprintfContext(ctx context.Context, logLevel string, format string, v ...interface{}) {
+	f.writeContext(ctx, logLevel, fmt.Sprintf(format, v...))
+}
+
+// RprintlnContext ctxから取り出したFieldsを付与したRprintln
+func (f *FieldLogger) RprintlnContext(ctx context.Context, logLevel string, v ...interface{}) {
+	f.writeContext(ctx, logLevel, fmt.Sprintln(v...))
+}
+
+// RprintContext ctxから取り出したFieldsを付与したRprint
+func (f *FieldLogger) RprintContext(ctx context.Context, logLevel string, v ...interface{}) {
+	f.writeContext(ctx, logLevel, fmt.Sprint(v...))
+}
+
+// writeContext loggerのFieldsにctxのFieldsを加えて出力する。Context付きの関数から呼ぶ
+func (f *FieldLogger) writeContext(ctx context.Context, logLevel, msg string) {
+	f.fileLogger().write(newEntry(3, logLevel, msg, copyFields(f.fields, contextFields(ctx))))
+}
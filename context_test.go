@@ -0,0 +1,110 @@
+package filelogger
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memorySink 受け取ったEntryを記録するSink
+type memorySink struct {
+	mu      sync.Mutex
+	entries []*Entry
+}
+
+func (m *memorySink) Write(e *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func (m *memorySink) Close() error {
+	return nil
+}
+
+func (m *memorySink) Entries() []*Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Entry(nil), m.entries...)
+}
+
+func TestContextLogging(t *testing.T) {
+	sink := &memorySink{}
+	logger := &FieldLogger{logger: newFileLogger(&Config{Sinks: []Sink{sink}}), fields: Fields{"service": "api"}}
+
+	ctx := ContextWithLogger(context.Background(), logger)
+	ctx = ContextWithRequestID(ctx, "req-1")
+	ctx, err := ContextWithTraceparent(ctx, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+	ctx = ContextWithFields(ctx, Fields{"user": "alice"})
+	ctx = ContextWithFields(ctx, Fields{"service": "checkout"})
+
+	RprintfContext(ctx, INFO, "hello %d", 1)
+	_, file, line, _ := runtime.Caller(0)
+	logger.RprintlnContext(context.Background(), WARN, "no context")
+
+	entries := sink.Entries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "hello 1", entries[0].Message)
+	assert.Equal(t, file+":"+strconv.Itoa(line-1), entries[0].Caller)
+	assert.Equal(t, Fields{
+		"service":    "checkout",
+		"user":       "alice",
+		"request_id": "req-1",
+		"trace_id":   "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id":    "00f067aa0ba902b7",
+	}, entries[0].Fields)
+	assert.Equal(t, Fields{"service": "api"}, entries[1].Fields)
+}
+
+func TestContextExtractors(t *testing.T) {
+	orig := ContextExtractors()
+	defer SetContextExtractors(orig...)
+
+	sink := &memorySink{}
+	logger := &FieldLogger{logger: newFileLogger(&Config{Sinks: []Sink{sink}})}
+	SetContextExtractors(append(ContextExtractors(), func(ctx context.Context) Fields {
+		return Fields{"tenant": ctx.Value(contextKey(100))}
+	})...)
+
+	ctx := context.WithValue(ContextWithRequestID(context.Background(), "r"), contextKey(100), "acme")
+	logger.RprintContext(ctx, INFO, "x")
+	SetContextExtractors()
+	logger.RprintContext(ctx, INFO, "y")
+
+	entries := sink.Entries()
+	assert.Equal(t, Fields{"request_id": "r", "tenant": "acme"}, entries[0].Fields)
+	assert.Nil(t, entries[1].Fields)
+	assert.NotNil(t, FromContext(context.Background()))
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.NoError(t, err)
+	assert.True(t, tc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", tc.String())
+
+	// 後のバージョンは後ろに続く項目を無視する
+	tc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.NoError(t, err)
+	assert.False(t, tc.Sampled())
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,82 @@
+package filelogger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// prepareDir FilePathのディレクトリがなければ作成し、書き込めることを確認する。
+// 新しく作成したディレクトリだけにDirPermとDirGroupを設定し、既存のディレクトリは変更しない
+func (c *Config) prepareDir() error {
+	perm := c.DirPerm
+	if perm == 0 {
+		perm = DirPerm
+	}
+	gid, err := lookupGroup(c.DirGroup)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.FilePath)
+	for _, d := range missingDirs(dir) {
+		if err = os.Mkdir(d, perm); err != nil && !os.IsExist(err) {
+			return err
+		}
+		// Mkdirのパーミッションはumaskでマスクされるので、作成後に設定し直す
+		if err = os.Chmod(d, perm); err != nil {
+			return err
+		}
+		if gid >= 0 {
+			if err = os.Chown(d, -1, gid); err != nil {
+				return err
+			}
+		}
+	}
+
+	return checkDirWritable(dir)
+}
+
+// missingDirs dirとその親のうち存在しないものを、親から順に返す
+func missingDirs(dir string) []string {
+	var dirs []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		dirs = append([]string{d}, dirs...)
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	return dirs
+}
+
+// lookupGroup グループ名かgidの文字列からgidを返す。空文字の場合は-1を返す
+func lookupGroup(group string) (int, error) {
+	if group == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// checkDirWritable ディレクトリにファイルを作成できるか実際に試す。
+// ローテーションではファイルの作成とリネームをするので、ディレクトリ自体に書き込める必要がある
+func checkDirWritable(dir string) error {
+	tmp, err := ioutil.TempFile(dir, ".filelogger-")
+	if err != nil {
+		return fmt.Errorf("directory %q is not writable: %w", dir, err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
@@ -0,0 +1,85 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// umaskに関係なくDirPermのパーミッションで親ディレクトリから作成されるか
+func TestPrepareDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:  filepath.Join(dir, "a", "b", "c", "test.log"),
+		CreateDir: true,
+		DirPerm:   0775,
+		DirGroup:  strconv.Itoa(os.Getgid()),
+	}
+	assert.NoError(t, conf.Validate())
+	assert.NoError(t, conf.prepareDir())
+
+	for _, d := range []string{"a", "a/b", "a/b/c"} {
+		fi, err := os.Stat(filepath.Join(dir, d))
+		assert.NoError(t, err)
+		assert.True(t, fi.IsDir())
+		assert.Equal(t, os.FileMode(0775), fi.Mode().Perm(), d)
+	}
+
+	// 既存のディレクトリのパーミッションは変更しない
+	fi, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+
+	conf.DirGroup = "no-such-group-filelogger"
+	assert.Error(t, conf.prepareDir())
+}
+
+// 書き込み時にディレクトリがなくても作成して出力できるか
+func TestSetOutputCreateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "logs", "test.log")
+	logger := newFileLogger(&Config{
+		LoggerFlags: LoggerFlags,
+		FilePath:    path,
+		CreateDir:   true,
+	})
+	logger.printLevel(INFO, "created")
+
+	// 実行中に消されても作り直す
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "logs")))
+	logger.printLevel(INFO, "recreated")
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "[INFO] recreated")
+}
+
+func TestValidateCreateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{FilePath: filepath.Join(dir, "x", "y", "test.log")}
+	assert.Error(t, conf.Validate())
+
+	conf.CreateDir = true
+	assert.NoError(t, conf.Validate())
+
+	conf.DirPerm = 0444
+	assert.Error(t, conf.Validate())
+
+	// 途中にファイルがあるとディレクトリは作れない
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0666))
+	conf = &Config{FilePath: filepath.Join(dir, "file", "y", "test.log"), CreateDir: true}
+	assert.Error(t, conf.Validate())
+}
@@ -0,0 +1,172 @@
+package filelogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ElasticsearchConfig ElasticsearchSinkの設定
+type ElasticsearchConfig struct {
+	URL         string // ElasticsearchのベースのURL。例: http://localhost:9200
+	IndexPrefix string // インデックス名の先頭。空の場合は"filelogger"
+	IndexDate   string // インデックス名の後ろに付けるEntryの日付(UTC)のレイアウト。空の場合は"2006.01.02"、"-"の場合は付けない
+	Action      string // "index"か"create"。データストリームに送る場合は"create"にする。空の場合は"index"
+	Username    string // Basic認証のユーザー名
+	Password    string
+	APIKey      string // Authorization: ApiKeyの値。Usernameより優先する
+	Headers     map[string]string
+	Client      *http.Client // nilの場合はタイムアウトが10秒のhttp.Client
+	Batch       BatchConfig
+}
+
+// ElasticsearchSink Elasticsearchの_bulk APIでドキュメントとして送るSink。
+// ドキュメントはentryRecordで作ったマップに@timestampを加えたもの。
+// 一部のドキュメントだけ失敗した場合は、429と5xxのものだけを送り直し、それ以外は捨てる
+type ElasticsearchSink struct {
+	*batcher
+	conf ElasticsearchConfig
+	url  string
+}
+
+const (
+	defaultIndexPrefix = "filelogger"
+	defaultIndexDate   = "2006.01.02"
+)
+
+// NewElasticsearchSink conf.URLの_bulk APIに送るElasticsearchSinkを作成する
+func NewElasticsearchSink(conf ElasticsearchConfig) (*ElasticsearchSink, error) {
+	if conf.URL == "" {
+		return nil, errors.New("filelogger: Elasticsearch sink URL is empty")
+	}
+	if conf.IndexPrefix == "" {
+		conf.IndexPrefix = defaultIndexPrefix
+	}
+	if conf.IndexDate == "" {
+		conf.IndexDate = defaultIndexDate
+	}
+	switch conf.Action {
+	case "":
+		conf.Action = "index"
+	case "index", "create":
+	default:
+		return nil, fmt.Errorf("filelogger: unknown Elasticsearch bulk action %q", conf.Action)
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	s := &ElasticsearchSink{conf: conf, url: strings.TrimSuffix(conf.URL, "/") + "/_bulk"}
+	b, err := newBatcher(conf.Batch, "elasticsearch.spool", s.send)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = b
+	return s, nil
+}
+
+// indexName Entryの日付からインデックス名を作る
+func (s *ElasticsearchSink) indexName(t time.Time) string {
+	if s.conf.IndexDate == "-" {
+		return s.conf.IndexPrefix
+	}
+	return s.conf.IndexPrefix + "-" + t.UTC().Format(s.conf.IndexDate)
+}
+
+// bulkResponse _bulk APIのレスポンスで使う項目
+type bulkResponse struct {
+	Errors bool                      `json:"errors"`
+	Items  []map[string]bulkItemResp `json:"items"`
+}
+
+type bulkItemResp struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func (s *ElasticsearchSink) send(batch []*Entry) error {
+	eb := encodeEach(batch, func(e *Entry) ([]byte, error) {
+		doc := entryRecord(e)
+		doc["@timestamp"] = e.Time.Format(time.RFC3339Nano)
+		action := map[string]map[string]string{s.conf.Action: {"_index": s.indexName(e.Time)}}
+		buf := &bytes.Buffer{}
+		enc := json.NewEncoder(buf)
+		if err := enc.Encode(action); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	if len(eb.entries) == 0 {
+		return eb.result(nil)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, b := range eb.data {
+		buf.Write(b)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, buf)
+	if err != nil {
+		return eb.result(&permanentError{err})
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case s.conf.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.conf.APIKey)
+	case s.conf.Username != "":
+		req.SetBasicAuth(s.conf.Username, s.conf.Password)
+	}
+	for k, v := range s.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	body, err := doHTTP(s.conf.Client, req)
+	if err != nil {
+		return eb.result(err)
+	}
+
+	var resp bulkResponse
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return eb.result(fmt.Errorf("filelogger: invalid Elasticsearch bulk response: %v", err))
+	}
+	if !resp.Errors {
+		return eb.result(nil)
+	}
+	return eb.result(bulkItemErrors(eb.entries, resp.Items))
+}
+
+// bulkItemErrors 失敗したドキュメントを、送り直すものと捨てるものに分ける
+func bulkItemErrors(batch []*Entry, items []map[string]bulkItemResp) error {
+	if len(items) != len(batch) {
+		return fmt.Errorf("filelogger: Elasticsearch bulk response has %d items for %d documents", len(items), len(batch))
+	}
+
+	pe := &partialError{}
+	var first string
+	for i, item := range items {
+		for _, r := range item {
+			if r.Status < 300 {
+				continue
+			}
+			if first == "" {
+				first = fmt.Sprintf("status %d: %s", r.Status, r.Error)
+			}
+			if r.Status == http.StatusTooManyRequests || r.Status >= 500 {
+				pe.retry = append(pe.retry, batch[i])
+			} else {
+				pe.dropped++
+			}
+		}
+	}
+	if pe.dropped == 0 && len(pe.retry) == 0 {
+		return nil
+	}
+	pe.err = fmt.Errorf("filelogger: Elasticsearch bulk failed for %d of %d documents, first error %s", pe.dropped+len(pe.retry), len(batch), first)
+	return pe
+}
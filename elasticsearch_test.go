@@ -0,0 +1,110 @@
+package filelogger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bulkStub _bulk APIのスタブ。statusに設定したメッセージのドキュメントはそのステータスで失敗させる
+type bulkStub struct {
+	mu      sync.Mutex
+	indexed map[string][]string // インデックス名ごとのメッセージ
+	status  map[string][]int    // メッセージごとに、失敗させるステータスを順に返す
+	user    string
+}
+
+func (bs *bulkStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if r.URL.Path != "/_bulk" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	bs.user, _, _ = r.BasicAuth()
+
+	var items []string
+	hasErrors := false
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		var action map[string]map[string]string
+		json.Unmarshal(sc.Bytes(), &action)
+		sc.Scan()
+		var doc map[string]interface{}
+		json.Unmarshal(sc.Bytes(), &doc)
+
+		msg := doc["message"].(string)
+		status := 201
+		if s := bs.status[msg]; len(s) > 0 {
+			status, bs.status[msg] = s[0], s[1:]
+			hasErrors = true
+		} else {
+			index := action["index"]["_index"]
+			bs.indexed[index] = append(bs.indexed[index], msg)
+		}
+		items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"test"}}}`, status))
+	}
+	fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, hasErrors, strings.Join(items, ","))
+}
+
+func TestElasticsearchSink(t *testing.T) {
+	stub := &bulkStub{
+		indexed: map[string][]string{},
+		status: map[string][]int{
+			"busy":    {429, 503},
+			"invalid": {400},
+		},
+	}
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	sink, err := NewElasticsearchSink(ElasticsearchConfig{
+		URL:         ts.URL + "/",
+		IndexPrefix: "app",
+		Username:    "elastic",
+		Password:    "pw",
+		Batch:       BatchConfig{FlushInterval: time.Hour, RetryWait: time.Millisecond},
+	})
+	assert.NoError(t, err)
+
+	day1 := time.Date(2019, 12, 2, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+	for _, e := range []*Entry{
+		{Time: day1, Level: INFO, Message: "one"},
+		{Time: day1, Level: INFO, Message: "busy"},
+		{Time: day2, Level: INFO, Message: "invalid"},
+		{Time: day2, Level: INFO, Message: "nan", Fields: Fields{"v": math.NaN()}},
+		{Time: day2, Level: INFO, Message: "two"},
+	} {
+		assert.NoError(t, sink.Write(e))
+	}
+	assert.NoError(t, sink.Flush())
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, "elastic", stub.user)
+	assert.Equal(t, map[string][]string{
+		"app-2019.12.02": {"one", "busy"},
+		"app-2019.12.03": {"two"},
+	}, stub.indexed)
+	// JSONにできないEntryは、そのEntryだけ捨てる
+	assert.Equal(t, uint64(2), sink.Dropped())
+}
+
+func TestElasticsearchIndexName(t *testing.T) {
+	_, err := NewElasticsearchSink(ElasticsearchConfig{URL: "http://localhost", Action: "update"})
+	assert.Error(t, err)
+
+	s := &ElasticsearchSink{conf: ElasticsearchConfig{IndexPrefix: "logs", IndexDate: "2006.01"}}
+	assert.Equal(t, "logs-2019.12", s.indexName(time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)))
+	s.conf.IndexDate = "-"
+	assert.Equal(t, "logs", s.indexName(time.Now()))
+}
@@ -0,0 +1,336 @@
+package filelogger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 暗号化したファイルの形式。
+//
+//	encryptMagic | 鍵IDの長さ(1バイト) | 鍵ID | ChunkSize(4バイト) | nonceの前半(7バイト) | チャンク...
+//
+// 各チャンクはChunkSizeバイトの平文をAES-GCMで暗号化したもので、最後のチャンクだけが短くなる(空の場合もある)。
+// nonceはnonceの前半、チャンクの番号(4バイト)、最後のチャンクかどうか(1バイト)をつなげたもので、
+// ヘッダー全体を追加データとして認証するので、チャンクの並べ替えや削除、途中での切り詰め、ヘッダーの書き換えは復号時に検出できる
+const (
+	encryptMagic            = "FLENC\x01"
+	encryptNoncePrefixSize  = 7
+	defaultEncryptChunkSize = 64 << 10
+	maxEncryptChunkSize     = 16 << 20
+)
+
+// KeyProvider 暗号化と復号に使うAESの鍵を渡す。鍵は16、24、32バイトのいずれか
+type KeyProvider interface {
+	// CurrentKey 新しく暗号化するときに使う鍵のIDと鍵を返す。IDはファイルの先頭に記録される
+	CurrentKey() (id string, key []byte, err error)
+	// Key ファイルに記録されたIDの鍵を返す
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys IDと鍵の対応を固定で持つKeyProvider。Currentの鍵で暗号化する。
+// 鍵を替えた後も古い鍵をKeysに残しておけば、古い鍵で暗号化したファイルを読める
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+// CurrentKey Currentの鍵を返す
+func (s *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := s.Key(s.Current)
+	return s.Current, key, err
+}
+
+// Key idの鍵を返す
+func (s *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", id)
+	}
+	return key, nil
+}
+
+// EncryptConfig ローテーションしたファイルを暗号化する設定。圧縮する場合は圧縮した後に暗号化する。
+// 暗号化したファイルは索引を使って途中から読むことはできず、Readerは先頭から復号する
+type EncryptConfig struct {
+	Keys      KeyProvider // nilでなければ暗号化する
+	ChunkSize int         // 一度に暗号化する平文の大きさ(バイト)。0の場合は64KB
+}
+
+func (c EncryptConfig) enabled() bool {
+	return c.Keys != nil
+}
+
+func (c EncryptConfig) chunkSize() int {
+	if c.ChunkSize > 0 {
+		return c.ChunkSize
+	}
+	return defaultEncryptChunkSize
+}
+
+// newAEAD 鍵からAES-GCMを作る
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptStream 暗号化と復号で共通のnonceの組み立て
+type encryptStream struct {
+	aead    cipher.AEAD
+	header  []byte // 追加データとして認証する
+	nonce   []byte
+	counter uint32
+	wrapped bool // チャンクの番号を使い切ったか
+}
+
+func newEncryptStream(aead cipher.AEAD, header, prefix []byte) *encryptStream {
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, prefix)
+	return &encryptStream{aead: aead, header: header, nonce: nonce}
+}
+
+// next 次のチャンクのnonceを返す
+func (s *encryptStream) next(last bool) ([]byte, error) {
+	if s.wrapped {
+		return nil, errors.New("filelogger: too many encrypted chunks")
+	}
+	binary.BigEndian.PutUint32(s.nonce[encryptNoncePrefixSize:], s.counter)
+	s.nonce[len(s.nonce)-1] = 0
+	if last {
+		s.nonce[len(s.nonce)-1] = 1
+	}
+	s.counter++
+	s.wrapped = s.counter == 0
+	return s.nonce, nil
+}
+
+// EncryptWriter 書き込んだものをチャンクごとに暗号化してwに書く。最後のチャンクを書くためにCloseを必ず呼ぶ。
+// Closeしてもwは閉じない
+type EncryptWriter struct {
+	w      io.Writer
+	stream *encryptStream
+	chunk  int
+	buf    []byte
+	out    []byte
+	err    error
+	closed bool
+}
+
+// NewEncryptWriter confのKeysの今の鍵で暗号化するEncryptWriterを作成し、ヘッダーを書く
+func NewEncryptWriter(w io.Writer, conf EncryptConfig) (*EncryptWriter, error) {
+	if !conf.enabled() {
+		return nil, errors.New("filelogger: no KeyProvider is set")
+	}
+	id, key, err := conf.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("filelogger: key id %q is longer than 255 bytes", id)
+	}
+	chunk := conf.chunkSize()
+	if chunk > maxEncryptChunkSize {
+		return nil, fmt.Errorf("filelogger: chunk size %d is larger than %d", chunk, maxEncryptChunkSize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptNoncePrefixSize)
+	if _, err = io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+
+	header := &bytes.Buffer{}
+	header.WriteString(encryptMagic)
+	header.WriteByte(byte(len(id)))
+	header.WriteString(id)
+	binary.Write(header, binary.BigEndian, uint32(chunk))
+	header.Write(prefix)
+	if _, err = w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+	return &EncryptWriter{
+		w:      w,
+		stream: newEncryptStream(aead, header.Bytes(), prefix),
+		chunk:  chunk,
+		buf:    make([]byte, 0, chunk),
+	}, nil
+}
+
+// Write 平文を書き込む。ChunkSizeを超えた分から暗号化してwに書く
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("filelogger: write to closed EncryptWriter")
+	}
+	n := 0
+	for e.err == nil && len(p) > 0 {
+		// 最後のチャンクかどうかはCloseまでわからないので、一杯になったチャンクは次の書き込みまで残しておく
+		if len(e.buf) == e.chunk {
+			e.err = e.seal(false)
+			continue
+		}
+		m := copy(e.buf[len(e.buf):e.chunk], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, e.err
+}
+
+// Close 残りを最後のチャンクとして暗号化して書く
+func (e *EncryptWriter) Close() error {
+	if e.closed {
+		return e.err
+	}
+	e.closed = true
+	if e.err == nil {
+		e.err = e.seal(true)
+	}
+	return e.err
+}
+
+func (e *EncryptWriter) seal(last bool) error {
+	nonce, err := e.stream.next(last)
+	if err != nil {
+		return err
+	}
+	e.out = e.stream.aead.Seal(e.out[:0], nonce, e.buf, e.stream.header)
+	e.buf = e.buf[:0]
+	_, err = e.w.Write(e.out)
+	return err
+}
+
+// decryptReader 暗号化したファイルをチャンクごとに復号しながら読む
+type decryptReader struct {
+	r      *bufio.Reader
+	stream *encryptStream
+	in     []byte
+	plain  []byte // 復号してまだ読まれていない部分
+	done   bool
+	err    error
+}
+
+// NewDecryptReader 暗号化したファイルを復号しながら読むReaderを作成する。ヘッダーに記録された鍵IDの鍵をkeysから取り出す。
+// チャンクを認証できなかった場合や、最後のチャンクの前で終わっている場合はReadがエラーを返す。
+// 圧縮してから暗号化したファイルは、Unfreezeやgzip.NewReaderに渡して解凍する
+func NewDecryptReader(r io.Reader, keys KeyProvider) (io.Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	header := make([]byte, len(encryptMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("filelogger: read encryption header: %w", err)
+	}
+	if string(header[:len(encryptMagic)]) != encryptMagic {
+		return nil, errors.New("filelogger: not an encrypted log file")
+	}
+	rest := make([]byte, int(header[len(encryptMagic)])+4+encryptNoncePrefixSize)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return nil, fmt.Errorf("filelogger: read encryption header: %w", err)
+	}
+	header = append(header, rest...)
+	id := string(rest[:len(rest)-4-encryptNoncePrefixSize])
+	chunk := binary.BigEndian.Uint32(rest[len(id):])
+	prefix := rest[len(id)+4:]
+	if chunk == 0 || chunk > maxEncryptChunkSize {
+		return nil, fmt.Errorf("filelogger: invalid chunk size %d in encryption header", chunk)
+	}
+
+	if keys == nil {
+		return nil, fmt.Errorf("filelogger: encrypted with key %q but no KeyProvider is set", id)
+	}
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:      br,
+		stream: newEncryptStream(aead, header, prefix),
+		in:     make([]byte, int(chunk)+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open 次のチャンクを読んで復号する。チャンクが一杯でない場合か、後に何も続かない場合を最後のチャンクとする
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.in)
+	last := false
+	switch {
+	case err == io.EOF:
+		return errors.New("filelogger: encrypted file is truncated")
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, perr := d.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+
+	nonce, err := d.stream.next(last)
+	if err != nil {
+		return err
+	}
+	d.plain, err = d.stream.aead.Open(d.in[:0], nonce, d.in[:n], d.stream.header)
+	if err != nil {
+		return fmt.Errorf("filelogger: encrypted chunk %d is truncated or was modified", d.stream.counter-1)
+	}
+	d.done = last
+	return nil
+}
+
+// isEncrypted 先頭が暗号化したファイルのヘッダーかを返す
+func isEncrypted(br *bufio.Reader) bool {
+	b, err := br.Peek(len(encryptMagic))
+	return err == nil && string(b) == encryptMagic
+}
+
+// decryptLogFile 暗号化されていれば、復号しながら読むbufio.Readerに替える
+func decryptLogFile(br *bufio.Reader, keys KeyProvider) (*bufio.Reader, bool, error) {
+	if !isEncrypted(br) {
+		return br, false, nil
+	}
+	dr, err := NewDecryptReader(br, keys)
+	if err != nil {
+		return nil, true, err
+	}
+	return bufio.NewReader(dr), true, nil
+}
+
+// EncryptFile 指定したファイルを暗号化する。CompressFileと同じように一時ファイルに書いてから置き換える。
+// 圧縮もする場合は先にCompressFileを呼ぶ
+func EncryptFile(path string, conf EncryptConfig) error {
+	return archiveFile(path, archiveOptions{encrypt: conf}, func(replace func() error) error {
+		return replace()
+	})
+}
@@ -0,0 +1,173 @@
+package filelogger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeys() *StaticKeys {
+	return &StaticKeys{Current: "k1", Keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	}}
+}
+
+func encryptBytes(t *testing.T, conf EncryptConfig, plain []byte) []byte {
+	buf := &bytes.Buffer{}
+	w, err := NewEncryptWriter(buf, conf)
+	assert.NoError(t, err)
+	_, err = w.Write(plain)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decryptBytes(keys KeyProvider, b []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(b), keys)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// チャンクの境界をまたいでも復号でき、書き換えや切り詰めを検出するか
+func TestEncrypt(t *testing.T) {
+	keys := newTestKeys()
+	conf := EncryptConfig{Keys: keys, ChunkSize: 16}
+	for _, n := range []int{0, 1, 15, 16, 32, 100} {
+		plain := bytes.Repeat([]byte("x"), n)
+		enc := encryptBytes(t, conf, plain)
+		assert.True(t, bytes.HasPrefix(enc, []byte(encryptMagic)))
+		dec, err := decryptBytes(keys, enc)
+		assert.NoError(t, err, "size %d", n)
+		assert.Equal(t, plain, dec, "size %d", n)
+	}
+
+	// 同じ平文でもファイルごとにnonceが違う
+	plain := []byte(strings.Repeat("2019/12/03 08:00:00 [INFO] secret\n", 5))
+	enc := encryptBytes(t, conf, plain)
+	assert.NotEqual(t, enc, encryptBytes(t, conf, plain))
+	assert.False(t, bytes.Contains(enc, []byte("secret")))
+
+	// 鍵を替えても、古い鍵で暗号化したものは読める
+	keys.Current = "k2"
+	dec, err := decryptBytes(keys, enc)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, dec)
+	_, err = decryptBytes(&StaticKeys{Keys: map[string][]byte{"k2": keys.Keys["k2"]}}, enc)
+	assert.EqualError(t, err, `unknown key id "k1"`)
+	_, err = decryptBytes(nil, enc)
+	assert.Error(t, err)
+	_, err = decryptBytes(keys, plain)
+	assert.Error(t, err)
+
+	header := len(encryptMagic) + 1 + len("k1") + 4 + encryptNoncePrefixSize
+	chunk := 16 + 16
+	tampered := map[string][]byte{
+		"modified":     append(append([]byte{}, enc[:header+3]...), append([]byte{enc[header+3] ^ 1}, enc[header+4:]...)...),
+		"truncated":    enc[:header+chunk*2],
+		"chunk lost":   append(append([]byte{}, enc[:header]...), enc[header+chunk:]...),
+		"chunk size":   append(append(append([]byte{}, enc[:header-encryptNoncePrefixSize-1]...), 32), enc[header-encryptNoncePrefixSize:]...),
+		"partial tail": enc[:len(enc)-1],
+	}
+	for name, b := range tampered {
+		_, err := decryptBytes(keys, b)
+		assert.Error(t, err, name)
+	}
+
+	// 圧縮してから暗号化したものは、復号してから解凍する
+	zbuf := &bytes.Buffer{}
+	assert.NoError(t, compress(zbuf, plain))
+	r, err := NewDecryptReader(bytes.NewReader(encryptBytes(t, conf, zbuf.Bytes())), keys)
+	assert.NoError(t, err)
+	unfrozen, err := Unfreeze(r)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, unfrozen.Bytes())
+
+	_, err = NewEncryptWriter(&bytes.Buffer{}, EncryptConfig{Keys: &StaticKeys{Current: "none"}})
+	assert.Error(t, err)
+	_, err = NewEncryptWriter(&bytes.Buffer{}, EncryptConfig{Keys: &StaticKeys{Current: "short", Keys: map[string][]byte{"short": []byte("key")}}})
+	assert.Error(t, err)
+}
+
+// ローテーションしたファイルが圧縮の後に暗号化され、ReaderやVerifyAuditで読めるか
+func TestEncryptLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:      filepath.Join(dir, "app.log"),
+		LoggerFlags:   LoggerFlags,
+		Rotate:        RotateConfig{MaxLine: 5, MaxRotation: 20},
+		Compress:      true,
+		Index:         true,
+		IndexInterval: 100,
+		Audit:         AuditConfig{Enabled: true},
+		Encrypt:       EncryptConfig{Keys: newTestKeys(), ChunkSize: 64},
+	}
+	assert.NoError(t, conf.Validate())
+	l := newFileLogger(conf)
+	for i := 0; i < 12; i++ {
+		l.write(newEntry(1, INFO, "secret "+strconv.Itoa(i), nil))
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, l.close())
+
+	files := LogFiles(conf.FilePath)
+	assert.True(t, len(files) > 2)
+	for _, path := range files[:len(files)-1] {
+		b, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(b, []byte(encryptMagic)), path)
+		assert.False(t, bytes.Contains(b, []byte("secret")), path)
+		assert.True(t, isArchivedFile(path))
+		// 索引から日時や件数が読めないように削除する
+		_, err = os.Stat(indexPath(path))
+		assert.True(t, os.IsNotExist(err), path)
+	}
+
+	r, err := NewReader(conf)
+	assert.NoError(t, err)
+	entries := readAllEntries(t, r)
+	if assert.Len(t, entries, 12) {
+		assert.Equal(t, "secret 0", entries[0].Message)
+		assert.Equal(t, "secret 11", entries[11].Message)
+	}
+
+	// 索引があっても暗号化したファイルは先頭から読む
+	r, err = NewReader(conf)
+	assert.NoError(t, err)
+	r.SeekTime(entries[11].Time)
+	seeked := readAllEntries(t, r)
+	if assert.NotEmpty(t, seeked) {
+		assert.Equal(t, "secret 11", seeked[len(seeked)-1].Message)
+	}
+
+	report, err := VerifyAudit(conf)
+	assert.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Errors)
+	assert.Equal(t, 12, report.Entries)
+
+	// 鍵がなければ読めない
+	r, err = NewPathReader(conf.FilePath)
+	assert.NoError(t, err)
+	for r.Next() {
+	}
+	assert.Error(t, r.Err())
+
+	// 暗号化済みのファイルを圧縮や暗号化し直さない
+	assert.Error(t, CompressFile(files[0]))
+	assert.Error(t, EncryptFile(files[0], conf.Encrypt))
+
+	conf.Encrypt.Keys = &StaticKeys{Current: "bad", Keys: map[string][]byte{"bad": []byte("short")}}
+	assert.Error(t, conf.Validate())
+}
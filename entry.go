@@ -0,0 +1,142 @@
+package filelogger
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields ログに付与する構造化された項目
+type Fields map[string]interface{}
+
+// Entry 一件のログ。ファイルへの出力もSinkへの出力もこれをもとに行う
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Caller  string    `json:"caller,omitempty"` // 呼び出し元の"path:line"
+	Message string    `json:"message"`
+	Fields  Fields    `json:"fields,omitempty"`
+	Mode    string    `json:"mode,omitempty"` // 出力したときのConfig.Mode。ファイルには出力しない
+}
+
+// Sink ファイル以外のログの出力先。Config.Sinksに設定すると、ファイルと同じ順番で同じログを受け取る。
+// Writeはロック中に呼ばれるので、時間のかかる処理はSink側でバッファリングすること
+type Sink interface {
+	Write(e *Entry) error
+	Close() error
+}
+
+// Flusher バッファを持つSinkが実装する。Fatal系の関数は終了前にFlushを呼ぶ
+type Flusher interface {
+	Flush() error
+}
+
+// callerFlags 呼び出し元を出力するフラグ。呼び出し元はEntryから出力するのでlog.Loggerには渡さない
+const callerFlags = log.Lshortfile | log.Llongfile
+
+// newEntry 呼び出し元を取得してEntryを作成する。calldepthはlog.Logger.Outputと同じ数え方
+func newEntry(calldepth int, logLevel, msg string, fields Fields) *Entry {
+	e := &Entry{
+		Time:    time.Now(),
+		Level:   logLevel,
+		Message: strings.TrimSuffix(msg, "\n"),
+		Fields:  fields,
+	}
+	if _, file, line, ok := runtime.Caller(calldepth); ok {
+		e.Caller = file + ":" + strconv.Itoa(line)
+	}
+	return e
+}
+
+// entryRecord message、level、callerとFieldsを一つのマップに並べる。Fieldsに同じ名前があればEntryの値を優先する。
+// JSONやMessagePackで一件を一つのオブジェクトとして送るSinkが使う
+func entryRecord(e *Entry) map[string]interface{} {
+	record := make(map[string]interface{}, len(e.Fields)+3)
+	for k, v := range e.Fields {
+		record[k] = v
+	}
+	record["message"] = e.Message
+	record["level"] = e.Level
+	if e.Caller != "" {
+		record["caller"] = e.Caller
+	}
+	return record
+}
+
+// FormatEntry flagsのLoggerFlagsでファイルに出力する場合と同じ一行を返す。Prefixは含まない。
+// ReaderやFollowerで読んだEntryを元の形式で表示するのに使う
+func FormatEntry(e *Entry, flags int) string {
+	return formatEntry(e, flags)
+}
+
+// formatEntry logパッケージと同じ形式のヘッダーのあとに"[LEVEL] message"を続けた一行を返す。
+// Fieldsがある場合はメッセージのあとにタブで区切ってkey=value形式で続ける
+func formatEntry(e *Entry, flags int) string {
+	b := &strings.Builder{}
+	formatHeader(b, e, flags)
+	fmt.Fprintf(b, "[%s] %s", e.Level, e.Message)
+	if len(e.Fields) > 0 {
+		b.WriteByte('\t')
+		b.WriteString(formatFields(e.Fields))
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// formatHeader log.Loggerのフラグに従って日時と呼び出し元を書き込む。prefixはlog.Loggerが付与する
+func formatHeader(b *strings.Builder, e *Entry, flags int) {
+	t := e.Time
+	if flags&log.LUTC != 0 {
+		t = t.UTC()
+	}
+	if flags&log.Ldate != 0 {
+		b.WriteString(t.Format("2006/01/02 "))
+	}
+	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		if flags&log.Lmicroseconds != 0 {
+			b.WriteString(t.Format("15:04:05.000000 "))
+		} else {
+			b.WriteString(t.Format("15:04:05 "))
+		}
+	}
+	if flags&callerFlags != 0 && e.Caller != "" {
+		caller := e.Caller
+		if flags&log.Lshortfile != 0 {
+			caller = filepath.Base(caller)
+		}
+		b.WriteString(caller)
+		b.WriteString(": ")
+	}
+}
+
+// formatFields キーの順に"key=value"をスペースで区切って並べる。空白などを含む値はクォートする
+func formatFields(fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = quoteField(k) + "=" + quoteField(fmt.Sprint(fields[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func quoteField(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f || !strconv.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,103 @@
+package filelogger
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatEntry(t *testing.T) {
+	e := &Entry{
+		Time:    time.Date(2019, 12, 2, 23, 25, 15, 123456000, time.Local),
+		Level:   ERROR,
+		Caller:  "/src/app/main.go:9",
+		Message: "test",
+	}
+
+	assert.Equal(t, "2019/12/02 23:25:15 [ERROR] test\n", formatEntry(e, LoggerFlags))
+	assert.Equal(t, "[ERROR] test\n", formatEntry(e, 0))
+	assert.Equal(t, "23:25:15.123456 main.go:9: [ERROR] test\n", formatEntry(e, log.Lmicroseconds|log.Lshortfile))
+	assert.Equal(t, "/src/app/main.go:9: [ERROR] test\n", formatEntry(e, log.Llongfile))
+
+	e.Fields = Fields{"user": "alice", "id": 42, "note": "has space", "empty": ""}
+	assert.Equal(t, "[ERROR] test\tempty=\"\" id=42 note=\"has space\" user=alice\n", formatEntry(e, 0))
+
+	// 名前に空白や=があっても別の項目に見えないようにクォートする
+	e.Fields = Fields{"user=root admin": "x"}
+	assert.Equal(t, "[ERROR] test\t\"user=root admin\"=x\n", formatEntry(e, 0))
+}
+
+// Rprintlnの呼び出し元が出力されるか
+func TestEntryCaller(t *testing.T) {
+	_, file, line, _ := runtime.Caller(0)
+	e := newEntry(1, INFO, "msg\n", nil)
+	assert.Equal(t, file+":"+strconv.Itoa(line+1), e.Caller)
+	assert.Equal(t, "msg", e.Message)
+}
+
+// logAndEntry log.LoggerとnewEntryで同じ呼び出し元を記録するために、どちらもこの関数の呼び出し元を使う
+func logAndEntry(logger *log.Logger) *Entry {
+	logger.Output(2, "[ERROR] test")
+	return newEntry(2, ERROR, "test", nil)
+}
+
+// formatEntryのヘッダーがlogパッケージのフラグごとの出力と同じ形式か。日時と行番号の数字は比べない
+func TestFormatEntryMatchesLog(t *testing.T) {
+	digits := regexp.MustCompile(`[0-9]`)
+	for _, flags := range []int{
+		0,
+		log.LstdFlags,
+		log.Ldate,
+		log.Ltime | log.Lmicroseconds,
+		log.LstdFlags | log.LUTC,
+		log.LstdFlags | log.Lshortfile,
+		log.Llongfile,
+		log.Lshortfile | log.Llongfile,
+	} {
+		buf := &bytes.Buffer{}
+		e := logAndEntry(log.New(buf, "", flags))
+		assert.Equal(t, digits.ReplaceAllString(buf.String(), "0"), digits.ReplaceAllString(formatEntry(e, flags), "0"), "flags %d", flags)
+	}
+}
+
+// recordingSink 受け取ったEntryを記録する
+type recordingSink struct {
+	entries []*Entry
+	closed  bool
+}
+
+func (s *recordingSink) Write(e *Entry) error {
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+// FilePathが空の場合はSinkにだけ出力し、除外するログレベルはSinkにも渡さないか
+func TestSinkOnly(t *testing.T) {
+	sink := &recordingSink{}
+	l := newFileLogger(&Config{
+		Mode:         ModeProduction,
+		LoggerFlags:  LoggerFlags,
+		LogLevelConf: LogLevelConfig{{Mode: ModeProduction, ExcludedLevel: []string{DEBUG}}},
+		Sinks:        []Sink{sink},
+	})
+	l.printLevel(INFO, "to sink")
+	l.printLevel(DEBUG, "excluded")
+	assert.NoError(t, l.close())
+
+	if assert.Len(t, sink.entries, 1) {
+		assert.Equal(t, INFO, sink.entries[0].Level)
+		assert.Equal(t, "to sink", sink.entries[0].Message)
+	}
+	assert.True(t, sink.closed)
+}
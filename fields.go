@@ -0,0 +1,63 @@
+package filelogger
+
+import (
+	"fmt"
+)
+
+// FieldLogger Fieldsを付与して出力するlogger。WithFieldsで作成する
+type FieldLogger struct {
+	logger *fileLogger // nilの場合はパッケージのLoggerに出力する
+	fields Fields
+}
+
+// WithFields fieldsを付与して出力するFieldLoggerを返す
+func WithFields(fields Fields) *FieldLogger {
+	return &FieldLogger{fields: copyFields(nil, fields)}
+}
+
+// WithFields 今のFieldsにfieldsを加えたFieldLoggerを返す。同じキーはfieldsの値を使う
+func (f *FieldLogger) WithFields(fields Fields) *FieldLogger {
+	return &FieldLogger{logger: f.logger, fields: copyFields(f.fields, fields)}
+}
+
+// Fields 付与するFieldsのコピーを返す
+func (f *FieldLogger) Fields() Fields {
+	return copyFields(nil, f.fields)
+}
+
+func (f *FieldLogger) fileLogger() *fileLogger {
+	if f.logger != nil {
+		return f.logger
+	}
+	return Logger
+}
+
+// Rprintf Fieldsを付与したRprintf
+func (f *FieldLogger) Rprintf(logLevel string, format string, v ...interface{}) {
+	f.fileLogger().write(newEntry(2, logLevel, fmt.Sprintf(format, v...), f.Fields()))
+}
+
+// Rprintln Fieldsを付与したRprintln
+func (f *FieldLogger) Rprintln(logLevel string, v ...interface{}) {
+	f.fileLogger().write(newEntry(2, logLevel, fmt.Sprintln(v...), f.Fields()))
+}
+
+// Rprint Fieldsを付与したRprint
+func (f *FieldLogger) Rprint(logLevel string, v ...interface{}) {
+	f.fileLogger().write(newEntry(2, logLevel, fmt.Sprint(v...), f.Fields()))
+}
+
+// copyFields 二つのFieldsをまとめた新しいFieldsを返す。同じキーはaddの値を使う
+func copyFields(base, add Fields) Fields {
+	if len(base) == 0 && len(add) == 0 {
+		return nil
+	}
+	fields := make(Fields, len(base)+len(add))
+	for k, v := range base {
+		fields[k] = v
+	}
+	for k, v := range add {
+		fields[k] = v
+	}
+	return fields
+}
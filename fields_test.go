@@ -0,0 +1,33 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithFields(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fields.log")
+	orig := Logger
+	defer func() { Logger = orig }()
+	Initialize(&Config{FilePath: path})
+
+	base := WithFields(Fields{"service": "api"})
+	child := base.WithFields(Fields{"request_id": "abc", "service": "worker"})
+	base.Rprintln(INFO, "base")
+	child.Rprintf(WARN, "child %d", 1)
+
+	// 元のFieldLoggerは変更されない
+	assert.Equal(t, Fields{"service": "api"}, base.Fields())
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "[INFO] base\tservice=api\n[WARN] child 1\trequest_id=abc service=worker\n", string(b))
+}
@@ -0,0 +1,173 @@
+package filelogger
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"time"
+)
+
+// FluentMode forwardプロトコルで送る形式
+type FluentMode int
+
+// forwardプロトコルで送る形式
+const (
+	FluentForward FluentMode = iota // [tag, [[time, record], ...], option]。BatchSize件ずつまとめて送る
+	FluentMessage                   // [tag, time, record, option]。一件ずつ送る
+)
+
+// FluentConfig FluentSinkの設定
+type FluentConfig struct {
+	Network    string        // "tcp"か"unix"。空の場合は"tcp"
+	Address    string        // 空の場合は"127.0.0.1:24224"
+	Tag        string        // 空の場合は"filelogger"
+	Mode       FluentMode    // FluentForwardかFluentMessage
+	RequireAck bool          // chunkを付けて送り、受け取ったことの応答を待つ。応答がなければ送れなかったものとして再試行する
+	AckTimeout time.Duration // 応答を待つ最大時間。0の場合は10秒
+	Batch      BatchConfig
+}
+
+const (
+	defaultFluentAddress = "127.0.0.1:24224"
+	defaultFluentTag     = "filelogger"
+	defaultAckTimeout    = 10 * time.Second
+	fluentDialTimeout    = 5 * time.Second
+	fluentWriteTimeout   = 5 * time.Second
+)
+
+// FluentSink Fluentd、Fluent Bitのforward入力に送るSink。
+// recordはentryRecordで作ったマップ。
+// 送れずに再試行した場合、FluentMessageで途中まで送れていたものや、RequireAckで応答だけが届かなかったものは重複して届くことがある
+type FluentSink struct {
+	*batcher
+	conf FluentConfig
+
+	// batcherのgoroutineだけが使う
+	conn net.Conn
+	dec  *msgpackDecoder
+}
+
+// NewFluentSink conf.Addressに送るFluentSinkを作成する。接続は最初に送るときに行う
+func NewFluentSink(conf FluentConfig) (*FluentSink, error) {
+	if conf.Network == "" {
+		conf.Network = "tcp"
+	}
+	if conf.Address == "" {
+		conf.Address = defaultFluentAddress
+	}
+	if conf.Tag == "" {
+		conf.Tag = defaultFluentTag
+	}
+	if conf.AckTimeout <= 0 {
+		conf.AckTimeout = defaultAckTimeout
+	}
+
+	f := &FluentSink{conf: conf}
+	b, err := newBatcher(conf.Batch, "fluent.spool", f.send)
+	if err != nil {
+		return nil, err
+	}
+	f.batcher = b
+	return f, nil
+}
+
+// Close バッファのEntryを送ってから接続を閉じる
+func (f *FluentSink) Close() error {
+	err := f.batcher.Close()
+	f.disconnect()
+	return err
+}
+
+func (f *FluentSink) send(batch []*Entry) error {
+	if f.conf.Mode == FluentMessage {
+		for _, e := range batch {
+			if err := f.sendMessage(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	entries := make([]interface{}, len(batch))
+	for i, e := range batch {
+		entries[i] = []interface{}{e.Time, entryRecord(e)}
+	}
+	return f.write(func(option map[string]interface{}) []interface{} {
+		return []interface{}{f.conf.Tag, entries, option}
+	})
+}
+
+func (f *FluentSink) sendMessage(e *Entry) error {
+	return f.write(func(option map[string]interface{}) []interface{} {
+		return []interface{}{f.conf.Tag, e.Time, entryRecord(e), option}
+	})
+}
+
+// write messageで作ったメッセージを送る。RequireAckの場合はchunkを付けて、同じ値のackが返るまで待つ
+func (f *FluentSink) write(message func(option map[string]interface{}) []interface{}) error {
+	option := map[string]interface{}{}
+	var chunk string
+	if f.conf.RequireAck {
+		var err error
+		if chunk, err = newChunkID(); err != nil {
+			return err
+		}
+		option["chunk"] = chunk
+	}
+
+	enc := &msgpackEncoder{}
+	enc.encode(message(option))
+
+	if f.conn == nil {
+		if err := f.connect(); err != nil {
+			return err
+		}
+	}
+	f.conn.SetWriteDeadline(time.Now().Add(fluentWriteTimeout))
+	if _, err := f.conn.Write(enc.bytes()); err != nil {
+		f.disconnect()
+		return err
+	}
+	if !f.conf.RequireAck {
+		return nil
+	}
+
+	f.conn.SetReadDeadline(time.Now().Add(f.conf.AckTimeout))
+	resp, err := f.dec.decode()
+	if err != nil {
+		f.disconnect()
+		return err
+	}
+	if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunk {
+		f.disconnect()
+		return fmt.Errorf("filelogger: unexpected fluent ack %v", resp)
+	}
+	return nil
+}
+
+func (f *FluentSink) connect() error {
+	conn, err := net.DialTimeout(f.conf.Network, f.conf.Address, fluentDialTimeout)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.dec = newMsgpackDecoder(conn)
+	return nil
+}
+
+func (f *FluentSink) disconnect() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+// newChunkID ackに使うchunkの値。ランダムな16バイトをbase64にする
+func newChunkID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,147 @@
+package filelogger
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fluentServer forwardプロトコルのメッセージを記録するサーバー。dropAckの回数だけackを返さずに接続を切る
+type fluentServer struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages [][]interface{}
+	dropAck  int
+}
+
+func startFluentServer(t *testing.T) *fluentServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	fs := &fluentServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fs.serve(conn)
+		}
+	}()
+	return fs
+}
+
+func (fs *fluentServer) serve(conn net.Conn) {
+	defer conn.Close()
+	dec := newMsgpackDecoder(conn)
+	for {
+		v, err := dec.decode()
+		if err != nil {
+			return
+		}
+		msg := v.([]interface{})
+
+		fs.mu.Lock()
+		if fs.dropAck > 0 {
+			fs.dropAck--
+			fs.mu.Unlock()
+			return
+		}
+		fs.messages = append(fs.messages, msg)
+		fs.mu.Unlock()
+
+		option, _ := msg[len(msg)-1].(map[string]interface{})
+		if chunk, ok := option["chunk"]; ok {
+			enc := &msgpackEncoder{}
+			enc.encode(map[string]interface{}{"ack": chunk})
+			conn.Write(enc.bytes())
+		}
+	}
+}
+
+func (fs *fluentServer) received() [][]interface{} {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([][]interface{}(nil), fs.messages...)
+}
+
+func TestFluentSinkForward(t *testing.T) {
+	fs := startFluentServer(t)
+	defer fs.ln.Close()
+
+	f, err := NewFluentSink(FluentConfig{
+		Address:    fs.ln.Addr().String(),
+		Tag:        "app.test",
+		RequireAck: true,
+		Batch:      BatchConfig{FlushInterval: time.Hour},
+	})
+	assert.NoError(t, err)
+
+	now := time.Unix(1575329115, 500)
+	assert.NoError(t, f.Write(&Entry{Time: now, Level: INFO, Message: "one", Caller: "main.go:1", Fields: Fields{"user": "alice", "message": "ignored"}}))
+	assert.NoError(t, f.Write(&Entry{Time: now, Level: ERROR, Message: "two"}))
+	assert.NoError(t, f.Close())
+
+	msgs := fs.received()
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "app.test", msgs[0][0])
+	entries := msgs[0][1].([]interface{})
+	assert.Len(t, entries, 2)
+	first := entries[0].([]interface{})
+	assert.Equal(t, now, first[0])
+	assert.Equal(t, map[string]interface{}{
+		"message": "one",
+		"level":   INFO,
+		"caller":  "main.go:1",
+		"user":    "alice",
+	}, first[1])
+	assert.NotEmpty(t, msgs[0][2].(map[string]interface{})["chunk"])
+}
+
+func TestFluentSinkMessage(t *testing.T) {
+	fs := startFluentServer(t)
+	defer fs.ln.Close()
+
+	f, err := NewFluentSink(FluentConfig{
+		Address: fs.ln.Addr().String(),
+		Mode:    FluentMessage,
+		Batch:   BatchConfig{FlushInterval: time.Hour},
+	})
+	assert.NoError(t, err)
+	writeMessages(t, f, 0, 3)
+	assert.NoError(t, f.Close())
+
+	// ackがなければ送ったことを確認できないので、サーバーが読み終わるまで待つ
+	assert.Eventually(t, func() bool { return len(fs.received()) == 3 }, time.Second, 5*time.Millisecond)
+	for i, msg := range fs.received() {
+		assert.Equal(t, defaultFluentTag, msg[0])
+		assert.IsType(t, time.Time{}, msg[1])
+		assert.Equal(t, testMessages(0, 3)[i], msg[2].(map[string]interface{})["message"])
+	}
+}
+
+// ackが返らなかった場合に接続し直して再送するか
+func TestFluentSinkAckRetry(t *testing.T) {
+	fs := startFluentServer(t)
+	defer fs.ln.Close()
+	fs.dropAck = 2
+
+	f, err := NewFluentSink(FluentConfig{
+		Address:    fs.ln.Addr().String(),
+		RequireAck: true,
+		AckTimeout: time.Second,
+		Batch:      BatchConfig{FlushInterval: time.Hour, RetryWait: time.Millisecond},
+	})
+	assert.NoError(t, err)
+	writeMessages(t, f, 0, 5)
+	assert.NoError(t, f.Flush())
+	assert.NoError(t, f.Close())
+
+	msgs := fs.received()
+	assert.Len(t, msgs, 1)
+	assert.Len(t, msgs[0][1], 5)
+	assert.Equal(t, uint64(0), f.Dropped())
+}
@@ -0,0 +1,367 @@
+package filelogger
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultFollowInterval Followerが追記を確認する間隔
+const defaultFollowInterval = 200 * time.Millisecond
+
+// Follower 出力中のファイルに追記されたEntryを読み続ける。tail -fと同様に使う。
+// ローテーションされた場合は元のファイルを最後まで読んでから新しいファイルに移る。
+// 確認する間に何度もローテーションされた場合は、その間にローテーションしたファイルを圧縮されていても古い順に読んでから移るので読み落とさない。
+// 追記がなければNextは待つので、止める場合は別のgoroutineからCloseを呼ぶ
+//
+//	f, err := filelogger.NewFollower(conf, false)
+//	go func() { <-ctx.Done(); f.Close() }()
+//	for f.Next() {
+//		e := f.Entry()
+//	}
+//	err = f.Err()
+type Follower struct {
+	Interval time.Duration // 追記とローテーションを確認する間隔。0の場合は200ms
+
+	path    string
+	asm     entryAssembler
+	keys    KeyProvider     // 暗号化されたローテーションしたファイルを読む鍵
+	known   map[string]bool // 読んだか、読み始める前からあったローテーションしたファイル
+	before  []string        // 読み始める前からあったローテーションしたファイル。Lastで読む
+	start   int64           // 出力中のファイルを読み始めた位置
+	catchup *Reader         // 読み落としそうになったローテーションしたファイル
+
+	mu      sync.Mutex // Closeと読み込み中のファイルを排他する
+	file    *os.File
+	buf     []byte
+	partial []byte // 改行までまだ書かれていない行
+	queue   []*Entry
+	idle    bool // 前回の確認から追記がなく、続きの行を待っているEntryがある
+	entry   *Entry
+	err     error
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewFollower confのFilePathのファイルを読むFollowerを作成する。
+// fromStartがtrueなら出力中のファイルの先頭から、falseなら今の末尾から読む。ファイルがまだなければ作成されるのを待つ
+func NewFollower(conf *Config, fromStart bool) (*Follower, error) {
+	if conf.FilePath == "" {
+		return nil, errors.New("filelogger: FilePath is empty")
+	}
+	f := &Follower{
+		path:  conf.FilePath,
+		asm:   entryAssembler{parser: entryParser{flags: conf.LoggerFlags, prefix: conf.Prefix}},
+		keys:  conf.Encrypt.Keys,
+		known: map[string]bool{},
+		done:  make(chan struct{}),
+	}
+	// 開く前に一覧を取るので、開いてからローテーションしたファイルは一覧に含まれない
+	f.before = f.newRotated()
+	if err := f.open(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if f.file != nil && !fromStart {
+		start, err := f.file.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.file.Close()
+			return nil, err
+		}
+		f.start = start
+	}
+	return f, nil
+}
+
+// Last 読み始める位置より前に出力された最後のn件を古い順に返す。Nextで読むものとは重ならないので、tail -fのように過去のEntryに続けて読める。
+// 読み始める前からあったローテーションしたファイルと、出力中のファイルの読み始める位置までを読む。Nextの前に呼ぶ
+func (f *Follower) Last(n int) ([]*Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	last := make([]*Entry, 0, n)
+	add := func(e *Entry) {
+		if len(last) == n {
+			last = append(last[:0], last[1:]...)
+		}
+		last = append(last, e)
+	}
+	r := &Reader{parser: f.asm.parser, files: f.before, keys: f.keys}
+	defer r.Close()
+	for r.Next() {
+		add(r.Entry())
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	if f.file == nil {
+		return last, nil
+	}
+	// ReadAtで読むので、Nextで読む位置は変わらない
+	s := newEntryScanner(io.NewSectionReader(f.file, 0, f.start), f.asm.parser)
+	for {
+		e, ok := s.next()
+		if !ok {
+			break
+		}
+		add(e)
+	}
+	return last, s.err()
+}
+
+// Next 次のEntryを読む。追記されるまで待ち、Closeされたかエラーの場合はfalseを返す
+func (f *Follower) Next() bool {
+	interval := f.Interval
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+
+	for {
+		f.mu.Lock()
+		ok, wait := f.advance()
+		f.mu.Unlock()
+		if !wait {
+			return ok
+		}
+
+		select {
+		case <-f.done:
+			return false
+		case <-time.After(interval):
+		}
+	}
+}
+
+// advance 読めるだけ読んでEntryを一つ取り出す。追記を待つ必要があればwaitにtrueを返す
+func (f *Follower) advance() (ok, wait bool) {
+	if f.buf == nil {
+		f.buf = make([]byte, 32*1024)
+	}
+	for {
+		if f.closed() {
+			return false, false
+		}
+		if len(f.queue) > 0 {
+			f.entry, f.queue = f.queue[0], f.queue[1:]
+			return true, false
+		}
+		if f.err != nil {
+			return false, false
+		}
+
+		if f.catchup != nil {
+			if f.catchup.Next() {
+				f.queue = append(f.queue, f.catchup.Entry())
+				continue
+			}
+			f.err = f.catchup.Err()
+			f.catchup.Close()
+			f.catchup = nil
+			continue
+		}
+
+		if f.file == nil {
+			if err := f.switchFile(nil); err != nil {
+				f.err = err
+				continue
+			}
+			if f.catchup == nil && f.file == nil {
+				return false, true
+			}
+			continue
+		}
+
+		n, err := f.file.Read(f.buf)
+		if n > 0 {
+			f.idle = false
+			f.addLines(f.buf[:n])
+			continue
+		}
+		if err != nil && err != io.EOF {
+			f.err = err
+			continue
+		}
+
+		// 末尾まで読んだ。メッセージの続きの行がまだ書かれていないことがあるので、次のEntryの行が来るまで今のEntryは保留し、
+		// Intervalの間待っても追記がなければ完成させる
+		if f.idle {
+			f.idle = false
+			if e := f.asm.flush(); e != nil {
+				f.queue = append(f.queue, e)
+				continue
+			}
+		}
+		switched, err := f.checkRotated()
+		if err != nil {
+			f.err = err
+			continue
+		}
+		if !switched {
+			f.idle = f.asm.pending != nil
+			return false, true
+		}
+	}
+}
+
+// addLines 読んだバイトを行に分けてentryAssemblerに加える
+func (f *Follower) addLines(b []byte) {
+	f.partial = append(f.partial, b...)
+	for {
+		i := bytes.IndexByte(f.partial, '\n')
+		if i < 0 {
+			return
+		}
+		if e := f.asm.add(string(f.partial[:i])); e != nil {
+			f.queue = append(f.queue, e)
+		}
+		f.partial = f.partial[i+1:]
+	}
+}
+
+// checkRotated pathが読んでいるファイルと別のファイルになっていれば、読み終えてから閉じて新しいファイルに移りtrueを返す。
+// ファイルが切り詰められていれば先頭から読み直す
+func (f *Follower) checkRotated() (bool, error) {
+	cur, err := f.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if fi != nil && os.SameFile(cur, fi) {
+		pos, err := f.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return false, err
+		}
+		if fi.Size() < pos {
+			_, err = f.file.Seek(0, io.SeekStart)
+			f.partial = nil
+			return err == nil, err
+		}
+		return false, nil
+	}
+
+	// ローテーションされた。確認する前に書かれた分を読み、書きかけの行が残っていれば一行として扱う
+	for {
+		n, err := f.file.Read(f.buf)
+		if n > 0 {
+			f.addLines(f.buf[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if len(f.partial) > 0 {
+		if e := f.asm.add(string(f.partial)); e != nil {
+			f.queue = append(f.queue, e)
+		}
+		f.partial = nil
+	}
+	if e := f.asm.flush(); e != nil {
+		f.queue = append(f.queue, e)
+	}
+	if err = f.file.Close(); err != nil {
+		return false, err
+	}
+	f.file = nil
+	return true, f.switchFile(cur)
+}
+
+// switchFile 新しくローテーションしたファイルがあれば古い順にcatchupで読むようにしてから、出力中のファイルを開く。
+// curは読み終えたファイルで、ローテーションしたファイルのうちcurとそれより前のものは読まない。
+// curが圧縮されて別のファイルに置き換わっていれば、一番古いものをcurとみなす
+func (f *Follower) switchFile(cur os.FileInfo) error {
+	rotated := f.newRotated()
+	if cur != nil && len(rotated) > 0 {
+		skip := 1
+		for i, path := range rotated {
+			if fi, err := os.Stat(path); err == nil && os.SameFile(cur, fi) {
+				skip = i + 1
+				break
+			}
+		}
+		rotated = rotated[skip:]
+	}
+	if len(rotated) > 0 {
+		f.catchup = &Reader{parser: f.asm.parser, files: rotated, keys: f.keys}
+	}
+
+	if err := f.open(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// newRotated まだ見ていないローテーションしたファイルを古い順に返し、見たものとして記録する
+func (f *Follower) newRotated() []string {
+	files := LogFiles(f.path)
+	var rotated []string
+	for _, path := range files {
+		if path == f.path || f.known[path] {
+			continue
+		}
+		f.known[path] = true
+		rotated = append(rotated, path)
+	}
+	return rotated
+}
+
+func (f *Follower) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+func (f *Follower) closed() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Entry Nextで読んだEntryを返す
+func (f *Follower) Entry() *Entry {
+	return f.entry
+}
+
+// Err 読んでいる間に起きたエラーを返す
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close 読むのをやめてファイルを閉じる。待っているNextはfalseを返す
+func (f *Follower) Close() error {
+	f.once.Do(func() {
+		close(f.done)
+	})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.catchup != nil {
+		f.catchup.Close()
+		f.catchup = nil
+	}
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
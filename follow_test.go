@@ -0,0 +1,191 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ローテーションと圧縮をまたいでも、書いた順にすべて読めるか
+func TestFollower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:    filepath.Join(dir, "app.log"),
+		LoggerFlags: LoggerFlags,
+		Rotate:      RotateConfig{MaxLine: 4, MaxRotation: 10},
+		Compress:    true,
+	}
+	l := newFileLogger(conf)
+	l.write(newEntry(1, INFO, "before follow", nil))
+
+	f, err := NewFollower(conf, false)
+	assert.NoError(t, err)
+	f.Interval = 10 * time.Millisecond
+
+	got := make(chan *Entry, 100)
+	go func() {
+		for f.Next() {
+			got <- f.Entry()
+		}
+		close(got)
+	}()
+
+	for i := 0; i < 10; i++ {
+		l.write(newEntry(1, INFO, "message "+strconv.Itoa(i), Fields{"i": i}))
+		time.Sleep(5 * time.Millisecond)
+	}
+	l.write(newEntry(1, ERROR, "multi\nline", nil))
+
+	for i := 0; i < 11; i++ {
+		select {
+		case e := <-got:
+			if i < 10 {
+				assert.Equal(t, "message "+strconv.Itoa(i), e.Message)
+				assert.Equal(t, Fields{"i": strconv.Itoa(i)}, e.Fields)
+			} else {
+				assert.Equal(t, ERROR, e.Level)
+				assert.Equal(t, "multi\nline", e.Message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for entry %d", i)
+		}
+	}
+	assert.True(t, len(LogFiles(conf.FilePath)) > 1)
+
+	assert.NoError(t, f.Close())
+	_, ok := <-got
+	assert.False(t, ok)
+	assert.NoError(t, f.Err())
+	assert.NoError(t, l.close())
+}
+
+// ファイルがまだなくても、作成されれば先頭から読むか
+func TestFollowerWaitsForFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "later.log")
+	f, err := NewFollower(&Config{FilePath: path, LoggerFlags: LoggerFlags}, false)
+	assert.NoError(t, err)
+	f.Interval = 10 * time.Millisecond
+	defer f.Close()
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ioutil.WriteFile(path, []byte("2019/12/03 08:25:15 [WARN] created\n"), 0644)
+	}()
+	assert.True(t, f.Next())
+	assert.Equal(t, WARN, f.Entry().Level)
+	assert.Equal(t, "created", f.Entry().Message)
+
+	_, err = NewFollower(&Config{}, true)
+	assert.Error(t, err)
+}
+
+// 確認する間に何度もローテーションして圧縮されても、読み落とさずに順に読むか
+func TestFollowerCatchUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:    filepath.Join(dir, "app.log"),
+		LoggerFlags: LoggerFlags,
+		Rotate:      RotateConfig{MaxLine: 2, MaxRotation: 20},
+		Compress:    true,
+	}
+	l := newFileLogger(conf)
+	l.write(newEntry(1, INFO, "before follow", nil))
+	time.Sleep(2 * time.Millisecond)
+
+	f, err := NewFollower(conf, false)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	for i := 0; i < 12; i++ {
+		l.write(newEntry(1, INFO, "message "+strconv.Itoa(i), nil))
+		time.Sleep(2 * time.Millisecond)
+	}
+	assert.NoError(t, l.close())
+
+	for i := 0; i < 12; i++ {
+		assert.True(t, f.Next())
+		assert.Equal(t, "message "+strconv.Itoa(i), f.Entry().Message)
+	}
+}
+
+// Lastで読み始める前のEntryを返し、Nextで読むものと重ならないか
+func TestFollowerLast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:    filepath.Join(dir, "app.log"),
+		LoggerFlags: LoggerFlags,
+		Rotate:      RotateConfig{MaxLine: 3, MaxRotation: 10},
+		Compress:    true,
+	}
+	l := newFileLogger(conf)
+	writeEntries(l, 0, 5, func(i int) *Entry {
+		return newEntry(1, INFO, "before "+strconv.Itoa(i), nil)
+	})
+
+	f, err := NewFollower(conf, false)
+	assert.NoError(t, err)
+	f.Interval = 10 * time.Millisecond
+	defer f.Close()
+	l.write(newEntry(1, INFO, "after", nil))
+	assert.NoError(t, l.close())
+
+	last, err := f.Last(4)
+	assert.NoError(t, err)
+	var msgs []string
+	for _, e := range last {
+		msgs = append(msgs, e.Message)
+	}
+	assert.Equal(t, []string{"before 1", "before 2", "before 3", "before 4"}, msgs)
+
+	assert.True(t, f.Next())
+	assert.Equal(t, "after", f.Entry().Message)
+}
+
+// 末尾で複数行のEntryの続きが書かれる前に読んでも、途中で区切らないか
+func TestFollowerHoldsPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	assert.NoError(t, ioutil.WriteFile(path, nil, 0644))
+	f, err := NewFollower(&Config{FilePath: path, LoggerFlags: LoggerFlags}, false)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	file.WriteString("2019/12/03 08:25:15 [ERROR] multi\n")
+	ok, wait := f.advance()
+	assert.False(t, ok)
+	assert.True(t, wait)
+
+	// 続きが書かれれば一件にまとめ、追記がないまま次の確認になれば完成させる
+	file.WriteString("line\n")
+	ok, wait = f.advance()
+	assert.False(t, ok)
+	assert.True(t, wait)
+	ok, _ = f.advance()
+	assert.True(t, ok)
+	assert.Equal(t, "multi\nline", f.Entry().Message)
+}
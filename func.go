@@ -2,13 +2,21 @@ package filelogger
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 )
 
 // Initialize Loggerを初期化する。
+// CreateDirが設定されていればディレクトリを作成する。失敗した場合はエラーを出力し、最初の書き込み時にもう一度作成を試みる
 func Initialize(conf *Config) {
 	Logger = newFileLogger(conf)
+	if conf.CreateDir {
+		if err := conf.prepareDir(); err != nil {
+			logPrintln(err.Error())
+		}
+	}
 }
 
 func newFileLogger(conf *Config) *fileLogger {
@@ -18,10 +26,16 @@ func newFileLogger(conf *Config) *fileLogger {
 		flag: conf.FileFlags,
 		fm:   newFileNameManager(conf.FilePath),
 	}
+	// 日時と呼び出し元はformatEntryでEntryから出力するので、log.Loggerにはprefixだけを設定する
+	var w io.Writer = os.Stdout
+	if conf.FilePath == "" {
+		w = ioutil.Discard
+	}
 	return &fileLogger{
-		file:   &file,
-		Logger: log.New(os.Stdout, conf.Prefix, conf.LoggerFlags),
-		Conf:   conf,
+		file:     &file,
+		Logger:   log.New(w, conf.Prefix, 0),
+		Conf:     conf,
+		redactor: newLoggerRedactor(conf),
 	}
 }
 
@@ -33,13 +47,16 @@ func addMissingConfParts(conf *Config) *Config {
 	if conf.FilePerm == 0 {
 		conf.FilePerm = FilePerm
 	}
+	if conf.DirPerm == 0 {
+		conf.DirPerm = DirPerm
+	}
 
 	return conf
 }
 
 // LogPrintf ログレベルによる出力の有無を加えたlogパッケージのPrintf
 func LogPrintf(logLevel string, format string, v ...interface{}) {
-	if Logger.shouldNotOutput(logLevel) {
+	if Logger.excluded(logLevel) {
 		return
 	}
 	log.Printf(format, v...)
@@ -47,7 +64,7 @@ func LogPrintf(logLevel string, format string, v ...interface{}) {
 
 // LogPrintln ログレベルによる出力の有無を加えたlogパッケージのPrintln
 func LogPrintln(logLevel string, v ...interface{}) {
-	if Logger.shouldNotOutput(logLevel) {
+	if Logger.excluded(logLevel) {
 		return
 	}
 	log.Println(v...)
@@ -55,7 +72,7 @@ func LogPrintln(logLevel string, v ...interface{}) {
 
 // LogPrint ログレベルによる出力の有無を加えたlogパッケージのPrint
 func LogPrint(logLevel string, v ...interface{}) {
-	if Logger.shouldNotOutput(logLevel) {
+	if Logger.excluded(logLevel) {
 		return
 	}
 	log.Print(v...)
@@ -63,26 +80,80 @@ func LogPrint(logLevel string, v ...interface{}) {
 
 // Rprintf ローテーションとログレベルによる出力の有無を加えたlog.LoggerのPrintf
 func Rprintf(logLevel string, format string, v ...interface{}) {
-	Logger.logOutput(logLevel, func() {
-		s := fmt.Sprintf("[%s] %v", logLevel, format)
-		Logger.Logger.Printf(s, v...)
-	})
+	Logger.write(newEntry(2, logLevel, fmt.Sprintf(format, v...), nil))
 }
 
 // Rprintln ローテーションとログレベルによる出力の有無を加えたlog.LoggerのPrintln
 func Rprintln(logLevel string, v ...interface{}) {
-	Logger.logOutput(logLevel, func() {
-		v[0] = fmt.Sprintf("[%s] %v", logLevel, v[0])
-		Logger.Logger.Println(v...)
-	})
+	Logger.write(newEntry(2, logLevel, fmt.Sprintln(v...), nil))
 }
 
 // Rprint ローテーションとログレベルによる出力の有無を加えたlog.LoggerのPrint
 func Rprint(logLevel string, v ...interface{}) {
-	Logger.logOutput(logLevel, func() {
-		v[0] = fmt.Sprintf("[%s] %v", logLevel, v[0])
-		Logger.Logger.Print(v...)
-	})
+	Logger.write(newEntry(2, logLevel, fmt.Sprint(v...), nil))
+}
+
+// Close 実行中の圧縮を待ち、Sinkのバッファを送り出してから閉じる。プログラムの終了前に呼ぶ
+func Close() error {
+	return Logger.close()
+}
+
+// exit テストで置き換えられるようにos.Exitを変数にしておく
+var exit = os.Exit
+
+// Rfatal Rprintと同様に出力し、圧縮の完了を待ってからos.Exit(1)する
+func Rfatal(v ...interface{}) {
+	Logger.fatal(fmt.Sprint(v...))
+}
+
+// Rfatalf Rprintfと同様に出力し、圧縮の完了を待ってからos.Exit(1)する
+func Rfatalf(format string, v ...interface{}) {
+	Logger.fatal(fmt.Sprintf(format, v...))
+}
+
+// Rfatalln Rprintlnと同様に出力し、圧縮の完了を待ってからos.Exit(1)する
+func Rfatalln(v ...interface{}) {
+	Logger.fatal(fmt.Sprintln(v...))
+}
+
+// Rpanic Rprintと同様に出力し、圧縮の完了を待ってからpanicする
+func Rpanic(v ...interface{}) {
+	Logger.panic(fmt.Sprint(v...))
+}
+
+// Rpanicf Rprintfと同様に出力し、圧縮の完了を待ってからpanicする
+func Rpanicf(format string, v ...interface{}) {
+	Logger.panic(fmt.Sprintf(format, v...))
+}
+
+// Rpanicln Rprintlnと同様に出力し、圧縮の完了を待ってからpanicする
+func Rpanicln(v ...interface{}) {
+	Logger.panic(fmt.Sprintln(v...))
+}
+
+// fatal ログを出力して圧縮の完了とSinkへの送信を待ってから、ロックを取ったままos.Exit(1)する。
+// ロックを解放しないので、終了までに他のgoroutineが書き込むことはない
+func (l *fileLogger) fatal(s string) {
+	l.write(newEntry(3, FATAL, s, nil))
+	l.finish()
+	l.Mutex.Lock()
+	exit(1)
+}
+
+// panic ログを出力し、圧縮の完了とSinkへの送信を待ってからpanicする。recoverされる可能性があるのでロックは取らない。
+// panicの値はrecoverした側やクラッシュ時の出力に残るので、ログと同じように機密情報を置き換えたものにする
+func (l *fileLogger) panic(s string) {
+	l.write(newEntry(3, PANIC, s, nil))
+	l.finish()
+	l.Mutex.Lock()
+	redactor := l.redactor
+	l.Mutex.Unlock()
+	panic(redactor.String(s))
+}
+
+// printLevel ログレベルを付与してsを出力する
+func (l *fileLogger) printLevel(logLevel string, s string) {
+	l.write(newEntry(2, logLevel, s, nil))
 }
 
 //******************************************************
@@ -96,7 +167,9 @@ func SetPrefix(prefix string) {
 
 // SetFlags loggerのフラグをセットする
 func SetFlags(flags int) {
-	Logger.Logger.SetFlags(flags)
+	Logger.Mutex.Lock()
+	Logger.Conf.LoggerFlags = flags
+	Logger.Mutex.Unlock()
 }
 
 /* 現時点で必要ないと思うのけど今後の変更でまた追加したくなる可能性があるのでコメントアウトしておく
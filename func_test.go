@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -91,3 +93,115 @@ func TestRprint(t *testing.T) {
 	os.Remove(printTestFilePath)
 	assert.NoError(t, err)
 }
+
+// Loggerを差し替えてRfatallnを呼び、ファイルに書き込まれてから終了コード1でexitが呼ばれるか
+func TestRfatalln(t *testing.T) {
+	path := "./fatal_test.txt"
+	orig, origExit := Logger, exit
+	defer func() {
+		Logger, exit = orig, origExit
+		os.Remove(path)
+	}()
+
+	Logger = newFileLogger(&Config{
+		LoggerFlags: LoggerFlags,
+		FilePath:    path,
+		FilePerm:    0666,
+		FileFlags:   FileFlags,
+	})
+	code := 0
+	exit = func(c int) { code = c }
+
+	Rfatalln("crash", "reason")
+	assert.Equal(t, 1, code)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(b), "[FATAL] crash reason"))
+}
+
+// Rpanicfが出力してからpanicするか
+func TestRpanicf(t *testing.T) {
+	path := "./panic_test.txt"
+	orig := Logger
+	defer func() {
+		Logger = orig
+		os.Remove(path)
+	}()
+
+	Logger = newFileLogger(&Config{
+		LoggerFlags: LoggerFlags,
+		FilePath:    path,
+		FilePerm:    0666,
+		FileFlags:   FileFlags,
+	})
+
+	assert.PanicsWithValue(t, "broken 42", func() {
+		Rpanicf("broken %d", 42)
+	})
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(b), "[PANIC] broken 42"))
+}
+
+// ローテーション直後にRfatalが呼ばれても、exitの前に圧縮が終わっているか
+func TestRfatalWaitsCompression(t *testing.T) {
+	dir := "./fataltest"
+	assert.NoError(t, os.Mkdir(dir, 0777))
+	orig, origExit := Logger, exit
+	defer func() {
+		Logger, exit = orig, origExit
+		os.RemoveAll(dir)
+	}()
+
+	Logger = newFileLogger(&Config{
+		Rotate:      RotateConfig{MaxLine: 3},
+		LoggerFlags: LoggerFlags,
+		FilePath:    filepath.Join(dir, "fatal.log"),
+		FilePerm:    0666,
+		FileFlags:   FileFlags,
+		Compress:    true,
+	})
+	l := Logger
+	// 同期して書き込み、ローテーションしたことを確かめる
+	for i := 0; len(LogFiles(l.Conf.FilePath)) < 2; i++ {
+		if i == 10 {
+			t.Fatal("no rotation")
+		}
+		l.printLevel(ERROR, "before crash")
+	}
+	// 新しいファイルにはローテーションした書き込みの一行がある。MaxLine行になると次の書き込みでローテーションする
+	for i := 1; i < l.Conf.Rotate.MaxLine; i++ {
+		l.printLevel(ERROR, "before crash")
+	}
+	assert.Len(t, LogFiles(l.Conf.FilePath), 2)
+
+	// ローテーションした書き込みは圧縮が終わるまで戻らないので、別のgoroutineで書き込み、
+	// ローテーションしたファイルができたらRfatalを呼ぶ。圧縮はまだ終わっていないことがある
+	done := make(chan struct{})
+	go func() {
+		l.printLevel(ERROR, "rotate")
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(LogFiles(l.Conf.FilePath)) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("no rotation")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	exited := false
+	exit = func(int) {
+		exited = true
+		files := LogFiles(l.Conf.FilePath)
+		assert.Len(t, files, 3)
+		for _, path := range files[:len(files)-1] {
+			assert.True(t, isGzipFile(path), path)
+		}
+	}
+	Rfatal("crash")
+	assert.True(t, exited)
+	<-done
+}
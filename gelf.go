@@ -0,0 +1,275 @@
+package filelogger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GELFの圧縮方式
+const (
+	GELFGzip = "gzip"
+	GELFZlib = "zlib"
+	GELFNone = "none"
+)
+
+// GELFConfig GELFSinkの設定
+type GELFConfig struct {
+	Network     string // "udp"か"tcp"。空の場合は"udp"
+	Address     string
+	Host        string // hostに出力する値。空の場合はos.Hostname
+	Compression string // UDPでの圧縮方式。GELFGzip、GELFZlib、GELFNone。空の場合はGELFGzip。TCPでは圧縮しない
+	ChunkSize   int    // UDPで一つのデータグラムに入れる最大バイト数。0の場合は1420
+	Batch       BatchConfig
+}
+
+const (
+	gelfDefaultChunkSize = 1420
+	gelfMaxChunks        = 128
+	gelfChunkHeaderSize  = 12
+	gelfDialTimeout      = 5 * time.Second
+	gelfWriteTimeout     = 5 * time.Second
+)
+
+// gelfChunkMagic チャンク分割したデータグラムの先頭に付ける値
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// GELFSink GraylogにGELF形式で出力するSink。
+// UDPでは圧縮し、ChunkSizeを超える場合はチャンクに分割して送る。TCPではnull文字で区切って送る。
+// 送信はbatcherのgoroutineで行い、送れない間はBatch.MaxBufferまで保持して、あふれた分は捨てるかBatch.SpoolDirに保存する
+type GELFSink struct {
+	*batcher
+	conf GELFConfig
+
+	// batcherのgoroutineだけが使う
+	conn net.Conn
+}
+
+// NewGELFSink confの接続先に接続してGELFSinkを作成する
+func NewGELFSink(conf GELFConfig) (*GELFSink, error) {
+	if conf.Network == "" {
+		conf.Network = "udp"
+	}
+	if conf.Host == "" {
+		conf.Host, _ = os.Hostname()
+	}
+	if conf.Compression == "" {
+		conf.Compression = GELFGzip
+	}
+	if conf.ChunkSize <= gelfChunkHeaderSize {
+		conf.ChunkSize = gelfDefaultChunkSize
+	}
+	switch conf.Compression {
+	case GELFGzip, GELFZlib, GELFNone:
+	default:
+		return nil, fmt.Errorf("filelogger: unknown GELF compression %q", conf.Compression)
+	}
+
+	g := &GELFSink{conf: conf}
+	if err := g.connect(); err != nil {
+		return nil, err
+	}
+	b, err := newBatcher(conf.Batch, "gelf.spool", g.send)
+	if err != nil {
+		g.disconnect()
+		return nil, err
+	}
+	g.batcher = b
+	return g, nil
+}
+
+func (g *GELFSink) connect() error {
+	conn, err := net.DialTimeout(g.conf.Network, g.conf.Address, gelfDialTimeout)
+	if err != nil {
+		return err
+	}
+	g.conn = conn
+	return nil
+}
+
+func (g *GELFSink) disconnect() {
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+func (g *GELFSink) isUDP() bool {
+	return strings.HasPrefix(g.conf.Network, "udp")
+}
+
+// Close バッファのEntryを送ってから接続を閉じる
+func (g *GELFSink) Close() error {
+	err := g.batcher.Close()
+	g.disconnect()
+	return err
+}
+
+// send batchを一件ずつGELFにして送る。GELFにできないEntryは再試行しても変わらないのでそのEntryだけ捨て、
+// 送れなかった場合は、そのEntryから後ろを送り直す
+func (g *GELFSink) send(batch []*Entry) error {
+	var dropped int
+	var dropErr error
+	for i, e := range batch {
+		packets, err := g.packets(e)
+		if err != nil {
+			dropped++
+			dropErr = err
+			continue
+		}
+		if err = g.write(packets); err != nil {
+			return &partialError{err: err, retry: batch[i:], dropped: dropped, dropErr: dropErr}
+		}
+	}
+	if dropped > 0 {
+		return &partialError{err: dropErr, dropped: dropped}
+	}
+	return nil
+}
+
+// packets Entryを送るデータにする。UDPでは圧縮して、ChunkSizeを超える場合はチャンクに分割する。TCPではnull文字を付ける
+func (g *GELFSink) packets(e *Entry) ([][]byte, error) {
+	b, err := json.Marshal(gelfMessage(g.conf.Host, e))
+	if err != nil {
+		return nil, err
+	}
+	if !g.isUDP() {
+		return [][]byte{append(b, 0)}, nil
+	}
+
+	data, err := gelfCompress(g.conf.Compression, b)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= g.conf.ChunkSize {
+		return [][]byte{data}, nil
+	}
+	return gelfChunks(data, g.conf.ChunkSize)
+}
+
+// write packetsを順に送る。接続が切れていた場合は一度だけ接続し直す
+func (g *GELFSink) write(packets [][]byte) error {
+	var err error
+	for retry := 0; retry < 2; retry++ {
+		if g.conn == nil {
+			if err = g.connect(); err != nil {
+				return err
+			}
+		}
+		if err = g.writePackets(packets); err == nil {
+			return nil
+		}
+		g.disconnect()
+	}
+	return err
+}
+
+func (g *GELFSink) writePackets(packets [][]byte) error {
+	for _, p := range packets {
+		g.conn.SetWriteDeadline(time.Now().Add(gelfWriteTimeout))
+		if _, err := g.conn.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gelfChunks dataをチャンクに分割する。各チャンクはマジックナンバー、メッセージID、シーケンス番号、チャンク数のヘッダーを持つ
+func gelfChunks(data []byte, chunkSize int) ([][]byte, error) {
+	size := chunkSize - gelfChunkHeaderSize
+	count := (len(data) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("filelogger: GELF message too large (%d bytes, %d chunks)", len(data), count)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		c := make([]byte, 0, gelfChunkHeaderSize+end-i*size)
+		c = append(c, gelfChunkMagic...)
+		c = append(c, id...)
+		c = append(c, byte(i), byte(count))
+		c = append(c, data[i*size:end]...)
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
+
+func gelfCompress(compression string, b []byte) ([]byte, error) {
+	if compression == GELFNone {
+		return b, nil
+	}
+
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	if compression == GELFZlib {
+		w = zlib.NewWriter(buf)
+	} else {
+		w = gzip.NewWriter(buf)
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gelfFieldPattern 追加フィールドの名前に使える文字
+var gelfFieldPattern = regexp.MustCompile(`[^\w.\-]`)
+
+// gelfMessage EntryをGELF 1.1のメッセージにする。Fieldsは"_"を付けた追加フィールドにする。
+// 追加フィールドの値は文字列か数値だけなので、それ以外はfmt.Sprintで文字列にする
+func gelfMessage(host string, e *Entry) map[string]interface{} {
+	short := e.Message
+	if i := strings.IndexByte(short, '\n'); i >= 0 {
+		short = short[:i]
+	}
+	m := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": short,
+		"timestamp":     epochSeconds(e.Time),
+		"level":         SyslogSeverity(e.Level),
+		"_level_name":   e.Level,
+	}
+	if short != e.Message {
+		m["full_message"] = e.Message
+	}
+	if e.Caller != "" {
+		m["_caller"] = e.Caller
+	}
+
+	for k, v := range e.Fields {
+		name := "_" + gelfFieldPattern.ReplaceAllString(k, "_")
+		// _idはGraylogが使うので名前を変える
+		if name == "_id" {
+			name = "_id_"
+		}
+		switch v.(type) {
+		case string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			m[name] = v
+		default:
+			m[name] = fmt.Sprint(v)
+		}
+	}
+	return m
+}
@@ -0,0 +1,191 @@
+package filelogger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readGELFUDP チャンクに分割されていれば組み立て、解凍してGELFのメッセージを返す
+func readGELFUDP(t *testing.T, pc net.PacketConn) map[string]interface{} {
+	chunks := map[byte][]byte{}
+	var data []byte
+	buf := make([]byte, 65536)
+	for {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		b := append([]byte(nil), buf[:n]...)
+		if !bytes.HasPrefix(b, gelfChunkMagic) {
+			data = b
+			break
+		}
+		chunks[b[10]] = b[12:]
+		if count := int(b[11]); len(chunks) == count {
+			for i := 0; i < count; i++ {
+				data = append(data, chunks[byte(i)]...)
+			}
+			break
+		}
+	}
+
+	var r = bytes.NewReader(data)
+	var b []byte
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gr, gerr := gzip.NewReader(r)
+		assert.NoError(t, gerr)
+		b, err = ioutil.ReadAll(gr)
+	case data[0] == 0x78:
+		zr, zerr := zlib.NewReader(r)
+		assert.NoError(t, zerr)
+		b, err = ioutil.ReadAll(zr)
+	default:
+		b = data
+	}
+	assert.NoError(t, err)
+
+	m := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	return m
+}
+
+func TestGELFSinkUDP(t *testing.T) {
+	for _, compression := range []string{GELFGzip, GELFZlib, GELFNone} {
+		t.Run(compression, func(t *testing.T) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			assert.NoError(t, err)
+			defer pc.Close()
+
+			g, err := NewGELFSink(GELFConfig{
+				Address:     pc.LocalAddr().String(),
+				Host:        "testhost",
+				Compression: compression,
+			})
+			assert.NoError(t, err)
+			defer g.Close()
+
+			e := &Entry{
+				Time:    time.Unix(1575329115, 123000000),
+				Level:   WARN,
+				Caller:  "main.go:9",
+				Message: "first line\nsecond line",
+				Fields:  Fields{"user": "alice", "count": 3, "id": "x", "bad key": true},
+			}
+			assert.NoError(t, g.Write(e))
+			assert.NoError(t, g.Flush())
+
+			m := readGELFUDP(t, pc)
+			assert.Equal(t, "1.1", m["version"])
+			assert.Equal(t, "testhost", m["host"])
+			assert.Equal(t, "first line", m["short_message"])
+			assert.Equal(t, "first line\nsecond line", m["full_message"])
+			assert.Equal(t, 1575329115.123, m["timestamp"])
+			assert.Equal(t, float64(SeverityWarning), m["level"])
+			assert.Equal(t, "alice", m["_user"])
+			assert.Equal(t, float64(3), m["_count"])
+			assert.Equal(t, "x", m["_id_"])
+			assert.Equal(t, "true", m["_bad_key"])
+			assert.Equal(t, "main.go:9", m["_caller"])
+		})
+	}
+}
+
+// ChunkSizeを超えるメッセージがチャンクに分割されて届くか
+func TestGELFSinkChunking(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	g, err := NewGELFSink(GELFConfig{
+		Address:     pc.LocalAddr().String(),
+		Compression: GELFNone,
+		ChunkSize:   512,
+	})
+	assert.NoError(t, err)
+	defer g.Close()
+
+	msg := strings.Repeat("0123456789", 300)
+	assert.NoError(t, g.Write(&Entry{Time: time.Now(), Level: INFO, Message: msg}))
+	assert.NoError(t, g.Flush())
+	m := readGELFUDP(t, pc)
+	assert.Equal(t, msg, m["short_message"])
+
+	_, err = gelfChunks(make([]byte, 200*gelfMaxChunks), 200)
+	assert.Error(t, err)
+}
+
+// JSONにできないEntryはそのEntryだけ捨てて、ほかは送るか
+func TestGELFSinkDropsUnencodable(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	g, err := NewGELFSink(GELFConfig{Address: pc.LocalAddr().String()})
+	assert.NoError(t, err)
+	defer g.Close()
+
+	assert.NoError(t, g.Write(&Entry{Time: time.Now(), Level: INFO, Message: "bad", Fields: Fields{"v": math.NaN()}}))
+	assert.NoError(t, g.Write(&Entry{Time: time.Now(), Level: INFO, Message: "good"}))
+	assert.NoError(t, g.Flush())
+
+	m := readGELFUDP(t, pc)
+	assert.Equal(t, "good", m["short_message"])
+	assert.Equal(t, uint64(1), g.Dropped())
+}
+
+func TestGELFSinkTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var msgs []string
+		for len(msgs) < 2 {
+			s, err := r.ReadString(0)
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, strings.TrimSuffix(s, "\x00"))
+		}
+		received <- msgs
+	}()
+
+	g, err := NewGELFSink(GELFConfig{Network: "tcp", Address: ln.Addr().String()})
+	assert.NoError(t, err)
+	logger := newFileLogger(&Config{Sinks: []Sink{g}})
+	logger.printLevel(INFO, "one")
+	logger.printLevel(ERROR, "two")
+	assert.NoError(t, logger.close())
+
+	select {
+	case msgs := <-received:
+		assert.Len(t, msgs, 2)
+		m := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal([]byte(msgs[1]), &m))
+		assert.Equal(t, "two", m["short_message"])
+		assert.Equal(t, float64(SeverityError), m["level"])
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
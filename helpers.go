@@ -1,8 +1,10 @@
 package filelogger
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -10,7 +12,6 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
-	//"fmt"
 )
 
 func logPrintln(msg string) {
@@ -42,6 +43,16 @@ func (f *fileNameManager) getNameAddTimeNow() string {
 	return now + "_" + f.name
 }
 
+// rotatedTime ローテーション時に付与された日時をファイル名から取得する
+func rotatedTime(name string) (time.Time, bool) {
+	timeSTR := strings.Split(name, "_")[0]
+	t, err := time.ParseInLocation(timeFormat, timeSTR, time.Local)
+	if err != nil {
+		return t, false
+	}
+	return t, true
+}
+
 const bufSize = 8 * 1024
 
 // ファイルの行数を取得する
@@ -82,6 +93,22 @@ func containsSTRFileList(path, str string) []os.FileInfo {
 	return fileList
 }
 
+// logFileList 指定したディレクトリにある、出力中のファイルとローテーションしたファイルのos.FileInfo配列を返す。
+// ロックファイルなど名前を含むだけのファイルは含まない
+func logFileList(dir, name string) []os.FileInfo {
+	var fileList []os.FileInfo
+	for _, fi := range containsSTRFileList(dir, name) {
+		if fi.Name() == name {
+			fileList = append(fileList, fi)
+			continue
+		}
+		if _, ok := rotatedTime(fi.Name()); ok && strings.HasSuffix(fi.Name(), "_"+name) {
+			fileList = append(fileList, fi)
+		}
+	}
+	return fileList
+}
+
 // oldFileName 受け取った配列の中の日時が付与されたファイル名で一番古いファイルの名前を返す
 func oldFileName(fileList []os.FileInfo) string {
 	var (
@@ -118,29 +145,115 @@ func oldFileName(fileList []os.FileInfo) string {
 }
 
 func compress(w io.Writer, content []byte) error {
+	return compressReader(w, bytes.NewReader(content))
+}
+
+// compressReader rを最後まで読みながらgzip形式で圧縮してwに書く
+func compressReader(w io.Writer, r io.Reader) error {
 	writer := gzip.NewWriter(w)
-	_, err := writer.Write(content)
-	writer.Close()
+	_, err := io.Copy(writer, r)
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
 
 	return err
 }
 
-// CompressFile 指定したファイルをgzip形式で圧縮する
+// CompressFile 指定したファイルをgzip形式で圧縮する。
+// 同じディレクトリの一時ファイルに圧縮してから置き換えるので、途中で失敗しても元のファイルは壊れない。
+// 索引があればチェックポイントごとに独立したgzipのメンバーにして圧縮し、索引に圧縮後の位置を加える
 func CompressFile(path string) error {
+	return archiveFile(path, archiveOptions{compress: true}, func(replace func() error) error {
+		return replace()
+	})
+}
+
+// archiveOptions ローテーションしたファイルを圧縮するか、暗号化するか
+type archiveOptions struct {
+	compress bool
+	encrypt  EncryptConfig
+}
+
+func (o archiveOptions) enabled() bool {
+	return o.compress || o.encrypt.enabled()
+}
+
+// archiveFile CompressFileと同じように圧縮し、optsによっては続けて暗号化する。元のファイルはメモリに読み込まずに一時ファイルへ書き出す。
+// 一時ファイルで元のファイルを置き換える処理はcommitに渡され、commitがreplaceを呼ばずに戻った場合は一時ファイルを削除して元のファイルはそのままにする。
+// 索引は日時やログレベルごとの件数が読めてしまうので、暗号化した場合は置き換えた後に削除する
+func archiveFile(path string, opts archiveOptions, commit func(replace func() error) error) error {
 	var err error
 
 	file, err := os.Open(path)
-	b, err := ioutil.ReadAll(file)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(file)
+	if isEncrypted(br) {
+		return fmt.Errorf("filelogger: %s is already encrypted", path)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".compress-")
+	if err != nil {
+		return err
+	}
+	replaced := false
+	defer func() {
+		if !replaced {
+			os.Remove(tmp.Name())
+		}
+	}()
 
-	newFile, err := os.Create(path)
+	// 索引があれば、チェックポイントから解凍できるように区間ごとに圧縮する。暗号化する場合は途中から読めないので区間に分けない
+	idx, ierr := readIndex(path)
+	blocks := opts.compress && !opts.encrypt.enabled() && ierr == nil && idx.validFor(fi.Size())
+	var w io.Writer = tmp
+	var ew *EncryptWriter
+	if err = tmp.Chmod(fi.Mode()); err == nil && opts.encrypt.enabled() {
+		if ew, err = NewEncryptWriter(tmp, opts.encrypt); err == nil {
+			w = ew
+		}
+	}
+	if err == nil {
+		switch {
+		case blocks:
+			err = compressBlocks(w, file, fi.Size(), idx)
+		case opts.compress:
+			err = compressReader(w, br)
+		default:
+			_, err = io.Copy(w, br)
+		}
+	}
+	if ew != nil && err == nil {
+		err = ew.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return err
 	}
 
-	return compress(newFile, b)
+	return commit(func() error {
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return err
+		}
+		replaced = true
+		switch {
+		case blocks:
+			return writeIndex(path, idx, fi.Mode().Perm())
+		case opts.encrypt.enabled():
+			if err := os.Remove(indexPath(path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // Unfreeze gzipで圧縮されたものを解凍する。このパッケージには直接かかわらないが、補助用の関数として書いておく
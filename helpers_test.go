@@ -2,13 +2,36 @@ package filelogger
 
 import (
 	"bytes"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// newTempConf confのFilePathを一時ディレクトリの中のパスにしたConfigを返す。返した関数でディレクトリを削除する
+func newTempConf(t *testing.T, conf Config) (*Config, func()) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	conf.FilePath = filepath.Join(dir, conf.FilePath)
+	return &conf, func() { os.RemoveAll(dir) }
+}
+
+// writeEntries fromからn件をentryで作って出力し、それぞれのEntryを返す。
+// ローテーションしたファイル名や索引の日時が重ならないように一件ごとに少し待つ
+func writeEntries(l *fileLogger, from, n int, entry func(i int) *Entry) []*Entry {
+	var entries []*Entry
+	for i := from; i < from+n; i++ {
+		e := entry(i)
+		l.write(e)
+		entries = append(entries, e)
+		time.Sleep(time.Millisecond)
+	}
+	return entries
+}
+
 func TestLineCounter(t *testing.T) {
 	file, _ := os.Open("./linecounter_test.txt")
 	defer file.Close()
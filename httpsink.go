@@ -0,0 +1,125 @@
+package filelogger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// HTTPConfig HTTPSinkの設定
+type HTTPConfig struct {
+	URL     string
+	Headers map[string]string // リクエストに付けるヘッダー。認証のトークンなど
+	Gzip    bool              // 本文をgzipで圧縮してContent-Encoding: gzipを付ける
+	Client  *http.Client      // nilの場合はタイムアウトが10秒のhttp.Client
+	Batch   BatchConfig
+}
+
+const defaultHTTPTimeout = 10 * time.Second
+
+// HTTPSink Entryをまとめて、一行に一つのJSON(NDJSON)でPOSTするSink。
+// 送れなかった場合は再試行し、Batch.SpoolDirが設定されていればディスクに保存して、送れるようになってから古い順に送り直す
+type HTTPSink struct {
+	*batcher
+	conf HTTPConfig
+}
+
+// NewHTTPSink conf.URLにPOSTするHTTPSinkを作成する。スプールに前回送れなかったEntryがあれば、最初に送り直す
+func NewHTTPSink(conf HTTPConfig) (*HTTPSink, error) {
+	if conf.URL == "" {
+		return nil, errors.New("filelogger: HTTP sink URL is empty")
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	h := &HTTPSink{conf: conf}
+	b, err := newBatcher(conf.Batch, "http.spool", h.send)
+	if err != nil {
+		return nil, err
+	}
+	h.batcher = b
+	return h, nil
+}
+
+func (h *HTTPSink) send(batch []*Entry) error {
+	eb := encodeEach(batch, func(e *Entry) ([]byte, error) {
+		return json.Marshal(e)
+	})
+	if len(eb.entries) == 0 {
+		return eb.result(nil)
+	}
+
+	buf := &bytes.Buffer{}
+	var w io.Writer = buf
+	var zw *gzip.Writer
+	if h.conf.Gzip {
+		zw = gzip.NewWriter(buf)
+		w = zw
+	}
+	for _, b := range eb.data {
+		w.Write(append(b, '\n'))
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return eb.result(err)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.conf.URL, buf)
+	if err != nil {
+		return eb.result(&permanentError{err})
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if h.conf.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range h.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	_, err = doHTTP(h.conf.Client, req)
+	return eb.result(err)
+}
+
+// httpStatusError 2xx以外のレスポンス
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("filelogger: HTTP endpoint returned %d: %s", e.StatusCode, e.Body)
+}
+
+// httpErrorBodyLimit エラーメッセージに含めるレスポンスの最大バイト数
+const httpErrorBodyLimit = 512
+
+// doHTTP リクエストを送ってレスポンスの本文を返す。2xx以外のステータスはエラーにする。
+// 4xxは408と429を除いて再試行しても成功しないのでpermanentErrorにする
+func doHTTP(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return body, err
+	}
+
+	if len(body) > httpErrorBodyLimit {
+		body = body[:httpErrorBodyLimit]
+	}
+	se := &httpStatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return nil, &permanentError{se}
+	}
+	return nil, se
+}
@@ -0,0 +1,309 @@
+package filelogger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEndpoint 受け取ったNDJSONのEntryを記録するサーバー。statusが0以外の間はそのステータスを返す
+type testEndpoint struct {
+	mu       sync.Mutex
+	messages []string
+	requests int
+	status   int
+	header   http.Header
+}
+
+func (te *testEndpoint) setStatus(status int) {
+	te.mu.Lock()
+	te.status = status
+	te.mu.Unlock()
+}
+
+func (te *testEndpoint) received() []string {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	return append([]string(nil), te.messages...)
+}
+
+func (te *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	te.requests++
+	te.header = r.Header
+	if te.status != 0 {
+		w.WriteHeader(te.status)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	sc := bufio.NewScanner(body)
+	for sc.Scan() {
+		e := &Entry{}
+		if err := json.Unmarshal(sc.Bytes(), e); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		te.messages = append(te.messages, e.Message)
+	}
+}
+
+func testMessages(from, to int) []string {
+	var msgs []string
+	for i := from; i < to; i++ {
+		msgs = append(msgs, strconv.Itoa(i))
+	}
+	return msgs
+}
+
+func writeMessages(t *testing.T, s Sink, from, to int) {
+	for i := from; i < to; i++ {
+		assert.NoError(t, s.Write(&Entry{Time: time.Now(), Level: INFO, Message: strconv.Itoa(i)}))
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	te := &testEndpoint{}
+	ts := httptest.NewServer(te)
+	defer ts.Close()
+
+	h, err := NewHTTPSink(HTTPConfig{
+		URL:     ts.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Gzip:    true,
+		Batch:   BatchConfig{BatchSize: 10, FlushInterval: time.Hour},
+	})
+	assert.NoError(t, err)
+
+	// BatchSizeに達したら送る
+	writeMessages(t, h, 0, 10)
+	assert.Eventually(t, func() bool { return len(te.received()) == 10 }, time.Second, 5*time.Millisecond)
+
+	writeMessages(t, h, 10, 15)
+	assert.NoError(t, h.Flush())
+	assert.NoError(t, h.Close())
+
+	assert.Equal(t, testMessages(0, 15), te.received())
+	assert.Equal(t, 2, te.requests)
+	assert.Equal(t, "Bearer token", te.header.Get("Authorization"))
+	assert.Equal(t, "application/x-ndjson", te.header.Get("Content-Type"))
+	assert.Equal(t, errBatcherClosed, h.Write(&Entry{}))
+}
+
+// JSONにできないEntryはそのEntryだけ捨てて、ほかは送るか
+func TestHTTPSinkUnencodable(t *testing.T) {
+	te := &testEndpoint{}
+	ts := httptest.NewServer(te)
+	defer ts.Close()
+
+	h, err := NewHTTPSink(HTTPConfig{URL: ts.URL, Batch: BatchConfig{FlushInterval: time.Hour}})
+	assert.NoError(t, err)
+	assert.NoError(t, h.Write(&Entry{Time: time.Now(), Level: INFO, Message: "0"}))
+	assert.NoError(t, h.Write(&Entry{Time: time.Now(), Level: INFO, Message: "nan", Fields: Fields{"v": math.NaN()}}))
+	assert.NoError(t, h.Write(&Entry{Time: time.Now(), Level: INFO, Message: "chan", Fields: Fields{"v": make(chan int)}}))
+	assert.NoError(t, h.Write(&Entry{Time: time.Now(), Level: INFO, Message: "1"}))
+	assert.NoError(t, h.Flush())
+	assert.NoError(t, h.Close())
+
+	assert.Equal(t, testMessages(0, 2), te.received())
+	assert.Equal(t, uint64(2), h.Dropped())
+}
+
+// 送れない間はスプールに保存し、送れるようになったら古い順に送り直すか。Closeで残ったものは次に作成したときに送るか
+func TestHTTPSinkSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	te := &testEndpoint{status: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(te)
+	defer ts.Close()
+
+	conf := HTTPConfig{
+		URL: ts.URL,
+		Batch: BatchConfig{
+			BatchSize:     5,
+			FlushInterval: 10 * time.Millisecond,
+			MaxRetries:    1,
+			RetryWait:     time.Millisecond,
+			MaxRetryWait:  5 * time.Millisecond,
+			SpoolDir:      dir,
+		},
+	}
+	h, err := NewHTTPSink(conf)
+	assert.NoError(t, err)
+	writeMessages(t, h, 0, 12)
+	assert.Error(t, h.Flush())
+	assert.Empty(t, te.received())
+	assert.Error(t, h.Close())
+	assert.Equal(t, uint64(0), h.Dropped())
+
+	h, err = NewHTTPSink(conf)
+	assert.NoError(t, err)
+	writeMessages(t, h, 12, 20)
+	assert.Error(t, h.Flush())
+
+	te.setStatus(0)
+	assert.Eventually(t, func() bool { return len(te.received()) == 20 }, 2*time.Second, 5*time.Millisecond)
+	writeMessages(t, h, 20, 25)
+	assert.NoError(t, h.Close())
+
+	assert.Equal(t, testMessages(0, 25), te.received())
+	assert.Empty(t, logFileList(dir, "http.spool"))
+}
+
+// スプールがRotateの設定で制限され、古いものから捨てられるか
+func TestHTTPSinkSpoolRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	te := &testEndpoint{status: http.StatusBadGateway}
+	ts := httptest.NewServer(te)
+	defer ts.Close()
+
+	h, err := NewHTTPSink(HTTPConfig{
+		URL: ts.URL,
+		Batch: BatchConfig{
+			BatchSize:     10,
+			FlushInterval: time.Hour,
+			MaxRetries:    -1,
+			SpoolDir:      dir,
+			SpoolRotate:   RotateConfig{MaxLine: 10, MaxRotation: 3},
+		},
+	})
+	assert.NoError(t, err)
+	writeMessages(t, h, 0, 45)
+	assert.Error(t, h.Flush())
+	assert.Len(t, logFileList(dir, "http.spool"), 3)
+
+	te.setStatus(0)
+	assert.NoError(t, h.Close())
+	assert.Equal(t, testMessages(20, 45), te.received())
+}
+
+// 4xxは再試行もスプールもせずに捨てるか
+func TestHTTPSinkPermanentError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	te := &testEndpoint{status: http.StatusBadRequest}
+	ts := httptest.NewServer(te)
+	defer ts.Close()
+
+	h, err := NewHTTPSink(HTTPConfig{
+		URL:   ts.URL,
+		Batch: BatchConfig{FlushInterval: time.Hour, RetryWait: time.Millisecond, SpoolDir: dir},
+	})
+	assert.NoError(t, err)
+	writeMessages(t, h, 0, 3)
+	assert.Error(t, h.Flush())
+	assert.NoError(t, h.Close())
+
+	assert.Equal(t, 1, te.requests)
+	assert.Equal(t, uint64(3), h.Dropped())
+	assert.Empty(t, logFileList(dir, "http.spool"))
+}
+
+// スプールを送り直している間にspoolBufferedで保存したEntryが、送り終えたファイルと一緒に削除されないか
+func TestSpoolBufferedDuringReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.spool")
+	assert.NoError(t, writeSpoolFile(path, []*Entry{{Time: time.Now(), Level: INFO, Message: "0"}}))
+
+	conf := BatchConfig{FlushInterval: time.Hour, SpoolDir: dir}
+	var sent []string
+	var b *batcher
+	b, err = newBatcher(conf, "test.spool", func(batch []*Entry) error {
+		// 送信中にfinishがタイムアウトして、バッファをスプールに保存した場合
+		if len(sent) == 0 {
+			assert.NoError(t, b.Write(&Entry{Time: time.Now(), Level: INFO, Message: "1"}))
+			assert.NoError(t, b.spoolBuffered())
+		}
+		for _, e := range batch {
+			sent = append(sent, e.Message)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.Flush())
+	assert.NoError(t, b.Close())
+	assert.Equal(t, []string{"0"}, sent)
+
+	b, err = newBatcher(conf, "test.spool", func(batch []*Entry) error {
+		for _, e := range batch {
+			sent = append(sent, e.Message)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, b.Flush())
+	assert.NoError(t, b.Close())
+	assert.Equal(t, []string{"0", "1"}, sent)
+	assert.Empty(t, logFileList(dir, "test.spool"))
+}
+
+// 送信先が応答しない場合、finishはfinishTimeoutで戻り、送り終わっていないEntryをスプールに保存するか
+func TestFinishSpoolsUnsent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+
+	timeout := finishTimeout
+	finishTimeout = 100 * time.Millisecond
+	defer func() { finishTimeout = timeout }()
+
+	h, err := NewHTTPSink(HTTPConfig{
+		URL:   ts.URL,
+		Batch: BatchConfig{BatchSize: 5, FlushInterval: time.Hour, SpoolDir: dir},
+	})
+	assert.NoError(t, err)
+	logger := newFileLogger(&Config{Sinks: []Sink{h}})
+	for i := 0; i < 15; i++ {
+		logger.printLevel(INFO, strconv.Itoa(i))
+	}
+
+	start := time.Now()
+	logger.finish()
+	assert.True(t, time.Since(start) < time.Second, "finish took %v", time.Since(start))
+
+	// 最初の5件は送信中なので、残りがスプールに保存される
+	entries, err := readSpoolFile(filepath.Join(dir, "http.spool"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 10)
+
+	close(release)
+	assert.NoError(t, h.Close())
+}
@@ -0,0 +1,470 @@
+package filelogger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultIndexInterval 索引のチェックポイントを記録する間隔の初期値(バイト)
+const defaultIndexInterval = 1 << 20
+
+// indexPath ログファイルの索引のpathを返す。ログファイルと同じディレクトリに隠しファイルとして作成するので、logFileListには含まれない
+func indexPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, "."+name+".idx")
+}
+
+// indexRecord 索引ファイルの一行。チェックポイントのOffsetをキーに、書かれている項目だけを更新する。
+// 出力中はチェックポイントを作った時にTimeとOffset、次のチェックポイントかローテーションで区間を閉じた時にOffset、End、Countsを書く。
+// 圧縮した後はすべての項目を一行にまとめて書き直す
+type indexRecord struct {
+	Time       *time.Time     `json:"time,omitempty"`
+	Offset     int64          `json:"offset"`
+	End        int64          `json:"end,omitempty"`
+	Counts     map[string]int `json:"counts,omitempty"`
+	Compressed *int64         `json:"compressed_offset,omitempty"`
+}
+
+// Index ログファイルの索引。Config.Indexを設定すると、出力しながらIndexIntervalバイトごとにチェックポイントを記録する
+type Index struct {
+	Checkpoints []IndexCheckpoint
+	Counts      map[string]int // ファイル全体のログレベル別の件数
+	Size        int64          // 解凍した大きさ
+	Blocks      bool           // チェックポイントごとに独立したgzipのメンバーで圧縮されているか
+}
+
+// IndexCheckpoint 索引のチェックポイント。チェックポイントから次のチェックポイントまでを区間とする
+type IndexCheckpoint struct {
+	Time             time.Time      // 区間の最初のEntryの日時
+	Offset           int64          // 区間の最初のEntryの位置。解凍したファイルでの位置
+	End              int64          // 区間の終わりの位置
+	CompressedOffset int64          // Blocksの場合、区間を圧縮したgzipのメンバーの位置
+	Counts           map[string]int // 区間のログレベル別の件数
+}
+
+// LoadIndex pathのログファイルの索引を読む。出力中のファイルでまだ閉じていない最後の区間は、ファイルを読んで件数を数える。
+// 行はconfのLoggerFlagsとPrefixで解析する
+func LoadIndex(conf *Config, path string) (*Index, error) {
+	idx, err := readIndex(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := len(idx.Checkpoints); n > 0 && idx.Checkpoints[n-1].End == 0 && !idx.Blocks && !isArchivedFile(path) {
+		last := &idx.Checkpoints[n-1]
+		last.Counts = map[string]int{}
+		err := scanEntries(path, last.Offset, entryParser{flags: conf.LoggerFlags, prefix: conf.Prefix}, func(offset int64, e *Entry) {
+			last.Counts[e.Level]++
+		}, &last.End)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	idx.Counts = map[string]int{}
+	for _, cp := range idx.Checkpoints {
+		for level, n := range cp.Counts {
+			idx.Counts[level] += n
+		}
+		if cp.End > idx.Size {
+			idx.Size = cp.End
+		}
+	}
+	return idx, nil
+}
+
+// readIndex 索引ファイルを読んでチェックポイントの順に並べる。壊れた行は読み飛ばす
+func readIndex(path string) (*Index, error) {
+	f, err := os.Open(indexPath(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	byOffset := map[int64]*IndexCheckpoint{}
+	idx := &Index{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec indexRecord
+		if json.Unmarshal(sc.Bytes(), &rec) != nil {
+			continue
+		}
+		cp, ok := byOffset[rec.Offset]
+		if !ok {
+			// Timeのない行だけのチェックポイントは、作った時の行が失われたもの
+			if rec.Time == nil {
+				continue
+			}
+			cp = &IndexCheckpoint{Offset: rec.Offset}
+			byOffset[rec.Offset] = cp
+		}
+		if rec.Time != nil {
+			cp.Time = *rec.Time
+		}
+		if rec.End > 0 {
+			cp.End = rec.End
+		}
+		if rec.Counts != nil {
+			cp.Counts = rec.Counts
+		}
+		if rec.Compressed != nil {
+			cp.CompressedOffset = *rec.Compressed
+			idx.Blocks = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, cp := range byOffset {
+		idx.Checkpoints = append(idx.Checkpoints, *cp)
+	}
+	sort.Slice(idx.Checkpoints, func(i, j int) bool {
+		return idx.Checkpoints[i].Offset < idx.Checkpoints[j].Offset
+	})
+	return idx, nil
+}
+
+// writeIndex 索引ファイルをidxの内容で書き直す。一時ファイルに書いてから置き換える
+func writeIndex(path string, idx *Index, perm os.FileMode) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, cp := range idx.Checkpoints {
+		t := cp.Time
+		rec := indexRecord{Time: &t, Offset: cp.Offset, End: cp.End, Counts: cp.Counts}
+		if idx.Blocks {
+			c := cp.CompressedOffset
+			rec.Compressed = &c
+		}
+		if err := enc.Encode(&rec); err != nil {
+			return err
+		}
+	}
+
+	target := indexPath(path)
+	tmp, err := ioutil.TempFile(filepath.Dir(target), ".index-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// seek sinceより前のEntryをなるべく読まずに済む位置を索引から探す。
+// 日時は書き込んだ順にほぼ並んでいるが前後することがあるので、sinceを超えない最後のチェックポイントの一つ前から読む
+func (idx *Index) seek(since time.Time) (IndexCheckpoint, bool) {
+	i := sort.Search(len(idx.Checkpoints), func(i int) bool {
+		return idx.Checkpoints[i].Time.After(since)
+	})
+	i -= 2
+	if i < 0 {
+		return IndexCheckpoint{}, false
+	}
+	return idx.Checkpoints[i], true
+}
+
+// scanEntries pathのファイルのoffsetから最後までのEntryをfnに渡す。offsetはEntryの先頭の行の位置。endにはファイルの大きさを入れる
+func scanEntries(path string, offset int64, parser entryParser, fn func(offset int64, e *Entry), end *int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(f)
+	pos := offset
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if e, ok := parser.parseHeader(trimNewline(line)); ok {
+				fn(pos, e)
+			}
+			pos += int64(len(line))
+		}
+		if err == io.EOF {
+			*end = pos
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isGzipFile pathのファイルがgzipで圧縮されているかを返す
+func isGzipFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return isGzip(bufio.NewReader(f))
+}
+
+// isArchivedFile pathのファイルが圧縮か暗号化されていて、位置を指定して読めないかを返す
+func isArchivedFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	return isGzip(br) || isEncrypted(br)
+}
+
+func trimNewline(s string) string {
+	if n := len(s); n > 0 && s[n-1] == '\n' {
+		return s[:n-1]
+	}
+	return s
+}
+
+// indexWriter 出力しながら索引を記録する。fileLoggerのロック中に使う。
+// 他のプロセスが同じファイルに書き込んだ場合や、起動し直した場合は、索引ファイルと最後の区間を読み直してから続ける
+type indexWriter struct {
+	path     string // 記録しているログファイル
+	loaded   bool
+	segment  bool // 閉じていない区間があるか
+	segStart int64
+	end      int64 // 最後に記録した時のファイルの大きさ
+	counts   map[string]int
+}
+
+// sync pathのログファイルの大きさがsizeの時点まで索引を記録した状態にする
+func (w *indexWriter) sync(conf *Config, path string, size int64) error {
+	if w.loaded && w.path == path && w.end == size {
+		return nil
+	}
+
+	*w = indexWriter{path: path, counts: map[string]int{}}
+	idx, err := readIndex(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	from := int64(0)
+	if idx != nil && len(idx.Checkpoints) > 0 {
+		last := idx.Checkpoints[len(idx.Checkpoints)-1]
+		switch {
+		case last.Offset >= size || last.End > size:
+			// ファイルが作り直されていれば古い索引は使えない
+			if err := os.Remove(indexPath(path)); err != nil {
+				return err
+			}
+		case last.End == 0:
+			w.segment, w.segStart, from = true, last.Offset, last.Offset
+		default:
+			from = last.End
+		}
+	}
+
+	var end int64
+	var werr error
+	err = scanEntries(path, from, entryParser{flags: conf.LoggerFlags, prefix: conf.Prefix}, func(offset int64, e *Entry) {
+		if offset >= size {
+			return
+		}
+		if err := w.add(conf, offset, e.Time, e.Level); err != nil && werr == nil {
+			werr = err
+		}
+	}, &end)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		err = werr
+	}
+	w.loaded, w.end = err == nil, size
+	return err
+}
+
+// record offsetから書き込んだEntryを記録する。sizeは書き込んだ後のファイルの大きさ
+func (w *indexWriter) record(conf *Config, offset int64, t time.Time, level string, size int64) error {
+	if !w.loaded {
+		return nil
+	}
+	err := w.add(conf, offset, t, level)
+	w.end = size
+	if err != nil {
+		w.loaded = false
+	}
+	return err
+}
+
+// add 最初のEntryか、区間の始まりからIndexIntervalバイト以上進んでいれば区間を閉じて新しいチェックポイントを作る
+func (w *indexWriter) add(conf *Config, offset int64, t time.Time, level string) error {
+	interval := conf.IndexInterval
+	if interval <= 0 {
+		interval = defaultIndexInterval
+	}
+	if !w.segment || offset-w.segStart >= interval {
+		if w.segment {
+			if err := w.closeSegment(conf, offset); err != nil {
+				return err
+			}
+		}
+		if err := w.append(conf, &indexRecord{Time: &t, Offset: offset}); err != nil {
+			return err
+		}
+		w.segment, w.segStart = true, offset
+	}
+	w.counts[level]++
+	return nil
+}
+
+// finish ローテーションしたファイルの最後の区間を閉じて、索引ファイルをローテーションしたファイルと同じ名前に変える
+func (w *indexWriter) finish(conf *Config, rotated string, size int64) error {
+	var err error
+	if w.loaded && w.segment {
+		err = w.closeSegment(conf, size)
+	}
+	w.loaded = false
+	if rerr := os.Rename(indexPath(w.path), indexPath(rotated)); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (w *indexWriter) closeSegment(conf *Config, end int64) error {
+	err := w.append(conf, &indexRecord{Offset: w.segStart, End: end, Counts: w.counts})
+	w.segment, w.counts = false, map[string]int{}
+	return err
+}
+
+func (w *indexWriter) append(conf *Config, rec *indexRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(indexPath(w.path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, conf.FilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncIndex Indexが設定されていれば、出力中のファイルの今の大きさまで索引を記録する。ロック中に呼ぶ
+func (l *fileLogger) syncIndex() (int64, error) {
+	if !l.Conf.Index {
+		return 0, nil
+	}
+	fi, err := os.Stat(l.file.fm.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), l.index.sync(l.Conf, l.file.fm.path, fi.Size())
+}
+
+// recordIndex offsetに書き込んだEntryを索引に記録する。ロック中に呼ぶ
+func (l *fileLogger) recordIndex(level string, offset int64) error {
+	fi, err := l.file.file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() <= offset {
+		return nil
+	}
+	return l.index.record(l.Conf, offset, time.Now(), level, fi.Size())
+}
+
+// rotateIndex ローテーションしたファイルの索引を閉じる。ファイルの名前を変える前にsyncIndexで記録しておく
+func (l *fileLogger) rotateIndex(rotated string, size int64) error {
+	if !l.Conf.Index {
+		return nil
+	}
+	return l.index.finish(l.Conf, rotated, size)
+}
+
+// removeIndex ログファイルを削除した時に索引も削除する
+func removeIndex(path string) {
+	if err := os.Remove(indexPath(path)); err != nil && !os.IsNotExist(err) {
+		logPrintln(err.Error())
+	}
+}
+
+// compressBlocks idxのチェックポイントごとに独立したgzipのメンバーにして圧縮し、idxにそれぞれの位置を記録する。
+// gzipのメンバーをつなげたものは一つのgzipとして読めるので、索引を使わなければ今までと同じように先頭から解凍できる
+func compressBlocks(w io.Writer, r io.ReaderAt, size int64, idx *Index) error {
+	if !idx.validFor(size) {
+		return errors.New("filelogger: index does not match the file")
+	}
+	cw := &countingWriter{w: w}
+	block := func(offset, end int64) error {
+		return compressReader(cw, io.NewSectionReader(r, offset, end-offset))
+	}
+
+	// 最初のチェックポイントより前にヘッダーのない行があれば、それだけで一つのメンバーにする
+	if first := idx.Checkpoints[0].Offset; first > 0 {
+		if err := block(0, first); err != nil {
+			return err
+		}
+	}
+	for i := range idx.Checkpoints {
+		cp := &idx.Checkpoints[i]
+		end := size
+		if i+1 < len(idx.Checkpoints) {
+			end = idx.Checkpoints[i+1].Offset
+		}
+		cp.CompressedOffset = cw.n
+		if err := block(cp.Offset, end); err != nil {
+			return err
+		}
+	}
+	idx.Blocks = true
+	return nil
+}
+
+// validFor チェックポイントが大きさsizeのファイルの中で昇順に並んでいるかを返す
+func (idx *Index) validFor(size int64) bool {
+	if len(idx.Checkpoints) == 0 {
+		return false
+	}
+	prev := int64(-1)
+	for _, cp := range idx.Checkpoints {
+		if cp.Offset <= prev || cp.Offset >= size || cp.End > size {
+			return false
+		}
+		prev = cp.Offset
+	}
+	return true
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
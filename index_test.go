@@ -0,0 +1,142 @@
+package filelogger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var indexTestConf = Config{
+	FilePath:      "app.log",
+	LoggerFlags:   LoggerFlags | log.Lmicroseconds,
+	Rotate:        RotateConfig{MaxLine: 40, MaxRotation: 10},
+	Compress:      true,
+	Index:         true,
+	IndexInterval: 300,
+}
+
+// writeIndexed n件を出力し、それぞれのEntryを返す。3件に1件をERRORにする
+func writeIndexed(l *fileLogger, from, n int) []*Entry {
+	return writeEntries(l, from, n, func(i int) *Entry {
+		level := INFO
+		if i%3 == 0 {
+			level = ERROR
+		}
+		return newEntry(1, level, "message "+strconv.Itoa(i), Fields{"i": i})
+	})
+}
+
+// 出力しながら索引を作り、ローテーションで閉じて、圧縮したファイルはチェックポイントから解凍できるか
+func TestIndex(t *testing.T) {
+	conf, cleanup := newTempConf(t, indexTestConf)
+	defer cleanup()
+
+	l := newFileLogger(conf)
+	entries := writeIndexed(l, 0, 100)
+	assert.NoError(t, l.close())
+
+	files := LogFiles(conf.FilePath)
+	assert.Len(t, files, 3)
+	total := map[string]int{}
+	for i, path := range files {
+		_, err := os.Stat(indexPath(path))
+		assert.NoError(t, err, path)
+
+		idx, err := LoadIndex(conf, path)
+		assert.NoError(t, err)
+		assert.True(t, len(idx.Checkpoints) > 1, path)
+		active := i == len(files)-1
+		assert.Equal(t, !active, idx.Blocks, path)
+		for level, n := range idx.Counts {
+			total[level] += n
+		}
+
+		// 区間ごとに、索引の位置から読み始めた最初の行がチェックポイントの日時のEntryになっているか
+		for _, cp := range idx.Checkpoints {
+			f, err := os.Open(path)
+			assert.NoError(t, err)
+			var br *bufio.Reader
+			if active {
+				_, err = f.Seek(cp.Offset, 0)
+				assert.NoError(t, err)
+				br = bufio.NewReader(f)
+			} else {
+				_, err = f.Seek(cp.CompressedOffset, 0)
+				assert.NoError(t, err)
+				zr, err := gzip.NewReader(f)
+				assert.NoError(t, err)
+				br = bufio.NewReader(zr)
+			}
+			line, err := br.ReadString('\n')
+			assert.NoError(t, err)
+			e, ok := entryParser{flags: conf.LoggerFlags}.parseHeader(trimNewline(line))
+			assert.True(t, ok, line)
+			assert.WithinDuration(t, cp.Time, e.Time, 10*time.Millisecond)
+			f.Close()
+		}
+	}
+	assert.Equal(t, map[string]int{ERROR: 34, INFO: 66}, total)
+
+	// 区間ごとに圧縮したファイルも先頭から読める
+	r, err := NewReader(conf)
+	assert.NoError(t, err)
+	got := readAllEntries(t, r)
+	assert.Len(t, got, 100)
+
+	// SeekTimeで指定した日時以降のEntryだけを読む
+	for _, from := range []int{0, 37, 58, 99} {
+		r, err = NewReader(conf)
+		assert.NoError(t, err)
+		r.SeekTime(entries[from].Time.Truncate(time.Microsecond))
+		got = readAllEntries(t, r)
+		if assert.Len(t, got, 100-from, "from %d", from) {
+			assert.Equal(t, "message "+strconv.Itoa(from), got[0].Message)
+		}
+	}
+}
+
+// 別のloggerが続きを出力しても、索引を読み直して続けるか
+func TestIndexResume(t *testing.T) {
+	conf, cleanup := newTempConf(t, indexTestConf)
+	defer cleanup()
+	conf.Rotate = RotateConfig{}
+
+	l := newFileLogger(conf)
+	writeIndexed(l, 0, 10)
+	assert.NoError(t, l.close())
+	l = newFileLogger(conf)
+	writeIndexed(l, 10, 20)
+	assert.NoError(t, l.close())
+
+	idx, err := LoadIndex(conf, conf.FilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{ERROR: 10, INFO: 20}, idx.Counts)
+	fi, err := os.Stat(conf.FilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, fi.Size(), idx.Size)
+	for i, cp := range idx.Checkpoints {
+		if i > 0 {
+			assert.Equal(t, idx.Checkpoints[i-1].End, cp.Offset)
+		}
+	}
+
+	// ファイルが作り直されていれば、古い索引を捨てて作り直す
+	assert.NoError(t, os.Remove(conf.FilePath))
+	l = newFileLogger(conf)
+	writeIndexed(l, 0, 3)
+	assert.NoError(t, l.close())
+	idx, err = LoadIndex(conf, conf.FilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{ERROR: 1, INFO: 2}, idx.Counts)
+	assert.Equal(t, int64(0), idx.Checkpoints[0].Offset)
+
+	_, err = LoadIndex(conf, filepath.Join(filepath.Dir(conf.FilePath), "missing.log"))
+	assert.True(t, os.IsNotExist(err))
+}
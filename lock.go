@@ -0,0 +1,45 @@
+package filelogger
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockPath ロックファイルのpathを返す。ログファイルと同じディレクトリに隠しファイルとして作成する
+func (f *fileNameManager) lockPath() string {
+	return filepath.Join(f.dir, "."+f.name+".lock")
+}
+
+// lockFile CrossProcessが設定されていればロックファイルに排他ロックをかけ、解除する関数を返す。
+// ロック中に呼ぶこと
+func (l *fileLogger) lockFile() (func(), error) {
+	return l.fileLocker()()
+}
+
+// fileLocker ロックファイルをロックする関数を返す。設定を読むのでロック中に呼び、返された関数はロック外でも使える。
+// CrossProcessが設定されていない場合やロックに失敗した場合、返された関数は何もしない解除関数を返す
+func (l *fileLogger) fileLocker() func() (func(), error) {
+	noop := func() {}
+	if !l.Conf.CrossProcess {
+		return func() (func(), error) {
+			return noop, nil
+		}
+	}
+
+	path, perm := l.file.fm.lockPath(), l.file.perm
+	return func() (func(), error) {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, perm)
+		if err != nil {
+			return noop, err
+		}
+		if err = lockExclusive(f); err != nil {
+			f.Close()
+			return noop, err
+		}
+
+		// ロックはファイルを閉じれば解除される
+		return func() {
+			f.Close()
+		}, nil
+	}
+}
@@ -0,0 +1,122 @@
+package filelogger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	helperProcessEnv = "FILELOGGER_HELPER_PROCESS"
+	crossMaxLine     = 20
+	crossLines       = 150
+	crossProcesses   = 4
+)
+
+// TestCrossProcessHelper TestCrossProcessRotationから子プロセスとして起動され、同じファイルにログを出力する
+func TestCrossProcessHelper(t *testing.T) {
+	if os.Getenv(helperProcessEnv) != "cross-process" {
+		t.Skip("helper process")
+	}
+	maxRotation, _ := strconv.Atoi(os.Getenv("FILELOGGER_MAX_ROTATION"))
+	logger := newFileLogger(&Config{
+		Rotate:       RotateConfig{MaxLine: crossMaxLine, MaxRotation: maxRotation},
+		LoggerFlags:  LoggerFlags,
+		FilePath:     os.Getenv("FILELOGGER_PATH"),
+		Compress:     true,
+		CrossProcess: true,
+	})
+	for i := 0; i < crossLines; i++ {
+		logger.printLevel(INFO, fmt.Sprintf("pid %d line %d", os.Getpid(), i))
+	}
+	logger.finish()
+}
+
+// runCrossProcess 子プロセスを同時に起動し、全て終了するのを待つ
+func runCrossProcess(t *testing.T, path string, maxRotation int) {
+	cmds := make([]*exec.Cmd, crossProcesses)
+	for i := range cmds {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCrossProcessHelper$")
+		cmd.Env = append(os.Environ(),
+			helperProcessEnv+"=cross-process",
+			"FILELOGGER_PATH="+path,
+			"FILELOGGER_MAX_ROTATION="+strconv.Itoa(maxRotation),
+		)
+		cmd.Stderr = os.Stderr
+		assert.NoError(t, cmd.Start())
+		cmds[i] = cmd
+	}
+	for _, cmd := range cmds {
+		assert.NoError(t, cmd.Wait())
+	}
+}
+
+// countLines 圧縮されていれば解凍して行数を数える
+func countLines(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var r io.Reader = f
+	if gr, err := gzip.NewReader(f); err == nil {
+		r = gr
+	} else {
+		f.Seek(0, io.SeekStart)
+	}
+	n := 0
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		n++
+	}
+	return n
+}
+
+// 複数のプロセスが同時に書き込んでも、ローテーションが重複せずに全ての行が残っているか
+func TestCrossProcessRotation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("flock is not available on windows")
+	}
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cross.log")
+	runCrossProcess(t, path, 0)
+
+	total := 0
+	for _, fi := range logFileList(dir, "cross.log") {
+		n := countLines(t, filepath.Join(dir, fi.Name()))
+		total += n
+		if fi.Name() != "cross.log" {
+			assert.Equal(t, crossMaxLine, n, fi.Name())
+		}
+	}
+	assert.Equal(t, crossLines*crossProcesses, total)
+
+	_, err = os.Stat(filepath.Join(dir, ".cross.log.lock"))
+	assert.NoError(t, err)
+}
+
+// 複数のプロセスが同時に書き込んでも、MaxRotationを超えてファイルが残らないか
+func TestCrossProcessRetention(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("flock is not available on windows")
+	}
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	maxRotation := 3
+	runCrossProcess(t, filepath.Join(dir, "cross.log"), maxRotation)
+	assert.Len(t, logFileList(dir, "cross.log"), maxRotation)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package filelogger
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockExclusive flockでファイルに排他ロックをかける。ロックが取れるまでブロックする
+func lockExclusive(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package filelogger
+
+import (
+	"errors"
+	"os"
+)
+
+// lockExclusive windowsではflockが使えないのでCrossProcessには対応していない
+func lockExclusive(f *os.File) error {
+	return errors.New("CrossProcess is not supported on windows")
+}
@@ -1,10 +1,12 @@
 package filelogger
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 // loggerとファイルのフラグ
@@ -12,6 +14,7 @@ const (
 	LoggerFlags = log.Ldate | log.Ltime | log.LstdFlags
 	FileFlags   = os.O_APPEND | os.O_CREATE | os.O_RDWR
 	FilePerm    = 0777
+	DirPerm     = 0755
 )
 
 // 基本的なログレベルをpackage側で定義
@@ -20,6 +23,8 @@ const (
 	INFO  = "INFO"
 	WARN  = "WARN"
 	ERROR = "ERROR"
+	FATAL = "FATAL"
+	PANIC = "PANIC"
 )
 
 // 基本的なログモードをpackage側で定義
@@ -28,27 +33,47 @@ const (
 	ModeProduction = "ProductionMode"
 )
 
+// finishTimeout Fatal系の関数が終了前に圧縮とSinkの送信の完了を待つ最大時間。テストで短くするため変数にしている
+var finishTimeout = 5 * time.Second
+
 // Logger ファイルへログ出力、ログローテーションなどをする
 var Logger *fileLogger
 
 type fileLogger struct {
 	sync.Mutex
-	file   *LogFile
-	Logger *log.Logger
-	Conf   *Config
+	file        *LogFile
+	Logger      *log.Logger
+	Conf        *Config        // 再読み込みで差し替わるので、ロック中に読む
+	compressing sync.WaitGroup // ロック解除後に実行中の圧縮処理
+	dirMu       sync.Mutex     // 古いファイルの削除と、圧縮したファイルの置き換えを排他する
+	subs        []*Subscription
+	index       indexWriter
+	redactor    *Redactor // Conf.Redactをコンパイルしたもの。Confと一緒に差し替える
+	audit       auditChain
 }
 
 // Config loggerの設定を持つ構造体
 type Config struct {
-	Rotate       RotateConfig
-	Mode         string // ログレベルによる出力の有無を切り替えるためのモード
-	LoggerFlags  int
-	FilePath     string
-	FilePerm     os.FileMode
-	FileFlags    int
-	Compress     bool
-	Prefix       string
-	LogLevelConf LogLevelConfig
+	Rotate        RotateConfig
+	Mode          string // ログレベルによる出力の有無を切り替えるためのモード
+	LoggerFlags   int
+	FilePath      string
+	FilePerm      os.FileMode
+	FileFlags     int
+	Compress      bool
+	Prefix        string
+	LogLevelConf  LogLevelConfig
+	CreateDir     bool           // FilePathのディレクトリがなければ作成する
+	DirPerm       os.FileMode    // 作成するディレクトリのパーミッション。umaskの影響は受けない
+	DirGroup      string         // 作成するディレクトリのグループ。グループ名かgid
+	CrossProcess  bool           // 複数のプロセスが同じFilePathに出力する場合に、ロックファイルで書き込みとローテーションを調整する
+	Index         bool           // ファイルごとに日時と位置の索引を出力する。圧縮する場合は索引の区間ごとに圧縮する
+	IndexInterval int64          // 索引のチェックポイントを記録する間隔(バイト)。0の場合は1MB
+	Redact        RedactConfig   // ファイルとSinkに出力する前に機密情報を置き換える
+	Sanitize      SanitizeConfig // ファイルとSinkに出力する前に制御文字や長さを整える
+	Audit         AuditConfig    // 各行に連番と前の行とつないだハッシュを付ける。AccessLogでは使えず、設定されていれば無効にする
+	Encrypt       EncryptConfig  // ローテーションしたファイルを圧縮の後にAES-GCMで暗号化する
+	Sinks         []Sink         // ファイルと同じログを出力する先。FilePathが空の場合はSinksにだけ出力する
 }
 
 // LogFile ログファイルの設定、pathファイル自体を保持する構造体
@@ -61,8 +86,10 @@ type LogFile struct {
 
 // RotateConfig ローテーションの設定をする構造体
 type RotateConfig struct {
-	MaxLine     int // 何行で次のファイルに移るか
-	MaxRotation int // ファイル何枚ででローテーションするか
+	MaxLine     int           // 何行で次のファイルに移るか
+	MaxRotation int           // ファイル何枚ででローテーションするか
+	MaxSize     int64         // 何バイトで次のファイルに移るか
+	MaxAge      time.Duration // ローテーションしたファイルを残しておく期間
 }
 
 // LogLevelConfig LogLevelConfのスライス
@@ -95,23 +122,92 @@ func (lc LevelConfig) isExcluded(level string) bool {
 func (l *fileLogger) setOutput() error {
 	var err error
 	l.file.file, err = os.OpenFile(l.file.fm.path, l.file.flag, l.file.perm)
+	// 実行中にディレクトリが消された場合も作り直す
+	if os.IsNotExist(err) && l.Conf.CreateDir {
+		if err = l.Conf.prepareDir(); err != nil {
+			return err
+		}
+		l.file.file, err = os.OpenFile(l.file.fm.path, l.file.flag, l.file.perm)
+	}
 	if err == nil {
 		l.Logger.SetOutput(l.file.file)
 	}
 	return err
 }
 
+// write Entryをファイルと、設定されているSinkに出力する。Modeが空の場合は出力時のConfig.Modeを設定する。
+// RedactやSanitizeが設定されていれば、prepareで整えたコピーを出力する
+func (l *fileLogger) write(e *Entry) {
+	l.logOutput(e.Level, func() {
+		e, ok := l.prepare(e)
+		if !ok {
+			return
+		}
+		if e.Mode == "" {
+			e.Mode = l.Conf.Mode
+		}
+		if l.Conf.Audit.Enabled && l.file.fm.path != "" {
+			var err error
+			if e, err = l.auditPrint(e); err != nil {
+				logPrintln(err.Error())
+				return
+			}
+		} else {
+			l.Logger.Print(formatEntry(e, l.Conf.LoggerFlags))
+		}
+		l.writeSinks(e)
+	})
+}
+
+// prepare Redactで機密情報を置き換え、Sanitizeでメッセージを整えたEntryを返す。出力しない場合はfalseを返す。ロック中に呼ぶこと
+func (l *fileLogger) prepare(e *Entry) (*Entry, bool) {
+	e = l.redactor.Redact(e)
+	sanitize := l.Conf.Sanitize
+	// 監査ログは一行ごとに検証するので、改行を含めない
+	if l.Conf.Audit.Enabled && sanitize.ControlChars == ControlCharsAllow {
+		sanitize.ControlChars = ControlCharsEscape
+	}
+	sanitized, ok := sanitize.entry(e)
+	if !ok {
+		logPrintln(fmt.Sprintf("rejected %q entry containing control characters", e.Level))
+	}
+	return sanitized, ok
+}
+
+// writeSinks SinkとSubscriptionに出力する。ロック中に呼ぶこと
+func (l *fileLogger) writeSinks(e *Entry) {
+	for _, s := range l.Conf.Sinks {
+		if err := s.Write(e); err != nil {
+			logPrintln(err.Error())
+		}
+	}
+	l.publish(e)
+}
+
 // 最初にロックをかけ、ローテーションが必要なら現在のファイルの名前にローテーション時の日時を付与し、次のファイルに移る。
 // この関数が呼び出されたファイル名と行数を取得し、ログのタイプ、ログと一緒に出力する。
 // ローテーションした場合はロック解除後にファイルの圧縮を行う
 func (l *fileLogger) logOutput(logLevel string, printFunc func()) {
+	var err error
+	l.Mutex.Lock()
 	// loglevelの設定を見て出力の必要がなければリターン
 	if l.shouldNotOutput(logLevel) {
+		l.Mutex.Unlock()
+		return
+	}
+	// ファイルに出力しない設定の場合はSinkへの出力だけをする
+	if l.file.fm.path == "" {
+		printFunc()
+		l.Mutex.Unlock()
 		return
 	}
 
-	var err error
-	l.Mutex.Lock()
+	// 他のプロセスも同じファイルに出力している場合は、ファイルを開いてから閉じるまでロックファイルでも排他する
+	unlock, err := l.lockFile()
+	if err != nil {
+		logPrintln(err.Error())
+	}
+
 	if err = l.setOutput(); err != nil {
 		logPrintln(err.Error())
 	}
@@ -121,18 +217,133 @@ func (l *fileLogger) logOutput(logLevel string, printFunc func()) {
 		logPrintln(err.Error())
 	}
 
+	// 索引には書き込む前の大きさを、このEntryの位置として記録する
+	offset, err := l.syncIndex()
+	if err != nil {
+		logPrintln(err.Error())
+	}
+
 	printFunc()
 
+	if l.Conf.Index {
+		if err = l.recordIndex(logLevel, offset); err != nil {
+			logPrintln(err.Error())
+		}
+	}
+
 	if err = l.file.file.Close(); err != nil {
 		logPrintln(err.Error())
 	}
+	unlock()
+
+	// Fatal系の関数が圧縮の完了を待てるように、ロック中にWaitGroupへ登録しておく
+	archive := l.archiveOptions()
+	compress := rotation && archive.enabled()
+	locker := l.fileLocker()
+	if compress {
+		l.compressing.Add(1)
+	}
 	l.Mutex.Unlock()
 
-	if rotation && l.Conf.Compress {
-		if err = CompressFile(prevFileName); err != nil {
+	if compress {
+		if err = l.archiveFile(prevFileName, archive, locker); err != nil {
 			logPrintln(err.Error())
 		}
+		l.compressing.Done()
+	}
+}
+
+// archiveOptions ローテーションしたファイルの圧縮と暗号化の設定を返す。ロック中に呼ぶこと
+func (l *fileLogger) archiveOptions() archiveOptions {
+	return archiveOptions{compress: l.Conf.Compress, encrypt: l.Conf.Encrypt}
+}
+
+// archiveFile ローテーションしたファイルを圧縮、暗号化する。optsとlockerはロック中に取得しておく。
+// 圧縮している間に古いファイルとして削除された場合は、圧縮したファイルで作り直さないように置き換えをやめる
+func (l *fileLogger) archiveFile(path string, opts archiveOptions, locker func() (func(), error)) error {
+	return archiveFile(path, opts, func(replace func() error) error {
+		unlock, err := locker()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		l.dirMu.Lock()
+		defer l.dirMu.Unlock()
+
+		if _, err = os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+		return replace()
+	})
+}
+
+// finish 実行中の圧縮が終わるのを待ち、Sinkのバッファを送り出す。圧縮と送信を合わせてfinishTimeoutを過ぎた場合は待たずに戻り、
+// 送り終わっていないSinkはバッファに残っているEntryをスプールに保存する。
+// CompressFileは一時ファイルに書き込んでから置き換えるので、途中で終了しても元のファイルは残る
+func (l *fileLogger) finish() {
+	deadline := time.Now().Add(finishTimeout)
+	done := make(chan struct{})
+	go func() {
+		l.compressing.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline)):
+		logPrintln("compression did not finish before exit")
+	}
+
+	l.Mutex.Lock()
+	sinks := l.Conf.Sinks
+	l.Mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range sinks {
+		if f, ok := s.(Flusher); ok {
+			wg.Add(1)
+			go func(f Flusher) {
+				defer wg.Done()
+				if err := f.Flush(); err != nil {
+					logPrintln(err.Error())
+				}
+			}(f)
+		}
+	}
+	flushed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Until(deadline)):
+		logPrintln("sinks did not flush before exit")
+		for _, s := range sinks {
+			if sp, ok := s.(bufferSpooler); ok {
+				if err := sp.spoolBuffered(); err != nil {
+					logPrintln(err.Error())
+				}
+			}
+		}
+	}
+}
+
+// close 実行中の圧縮とSinkへの出力が終わるのを待ってから、SinkとSubscriptionを閉じる
+func (l *fileLogger) close() error {
+	l.finish()
+
+	var err error
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	l.closeSubscriptions()
+	for _, s := range l.Conf.Sinks {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
 	}
+	return err
 }
 
 // rotation セットされているファイルに書き込む最大行数に達しているかチェックし、必要なら次のファイルを作成しアウトプット先としてセットする。
@@ -143,10 +354,14 @@ func (l *fileLogger) rotation() (string, bool, error) {
 	var rotation bool
 	var fileName string
 
-	if !l.isOverLine() {
+	if !l.isOverLine() && !l.isOverSize() {
 		return fileName, rotation, err
 	}
 
+	size, err := l.syncIndex()
+	if err != nil {
+		logPrintln(err.Error())
+	}
 	rotation = true
 	fileName = filepath.Join(l.file.fm.dir, l.file.fm.getNameAddTimeNow())
 	err = os.Rename(l.file.fm.path, fileName)
@@ -154,6 +369,9 @@ func (l *fileLogger) rotation() (string, bool, error) {
 		rotation = false
 		return fileName, rotation, err
 	}
+	if err = l.rotateIndex(fileName, size); err != nil {
+		logPrintln(err.Error())
+	}
 
 	if err = l.file.file.Close(); err != nil {
 		return fileName, rotation, err
@@ -162,12 +380,24 @@ func (l *fileLogger) rotation() (string, bool, error) {
 		return fileName, rotation, err
 	}
 
-	fileList := containsSTRFileList(l.file.fm.dir, l.file.fm.name)
+	return fileName, rotation, l.applyRetention()
+}
+
+// applyRetention 今のファイル名でローテーションしたファイルのうち、MaxRotationを超えた古いものとMaxAgeを過ぎたものを削除する
+func (l *fileLogger) applyRetention() error {
+	var err error
+	l.dirMu.Lock()
+	defer l.dirMu.Unlock()
+	fileList := logFileList(l.file.fm.dir, l.file.fm.name)
 	if l.isOverFile(fileList) {
 		err = l.deleteOldFile(fileList)
 	}
-
-	return fileName, rotation, err
+	if l.Conf.Rotate.MaxAge > 0 {
+		if e := l.deleteExpiredFile(fileList); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 // isOverLine ローテーションが必要かチェックする。
@@ -179,6 +409,18 @@ func (l *fileLogger) isOverLine() bool {
 	return lineCount > l.Conf.Rotate.MaxLine
 }
 
+// isOverSize ファイルサイズが設定した最大サイズに達しているかチェックする。
+func (l *fileLogger) isOverSize() bool {
+	if l.Conf.Rotate.MaxSize <= 0 {
+		return false
+	}
+	fi, err := l.file.file.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Size() >= l.Conf.Rotate.MaxSize
+}
+
 // isOverFile セットされているローテーションするファイル数に達しているかチェックする。
 func (l *fileLogger) isOverFile(fileList []os.FileInfo) bool {
 	if l.Conf.Rotate.MaxRotation <= 1 {
@@ -190,11 +432,42 @@ func (l *fileLogger) isOverFile(fileList []os.FileInfo) bool {
 
 // deleteOldFile 一番古いログファイルを削除する必要があるかチェックし、必要なら削除する
 func (l *fileLogger) deleteOldFile(fileList []os.FileInfo) error {
-	oldFileName := oldFileName(fileList)
-	return os.Remove(filepath.Join(l.file.fm.dir, oldFileName))
+	path := filepath.Join(l.file.fm.dir, oldFileName(fileList))
+	removeIndex(path)
+	return os.Remove(path)
 }
 
+// deleteExpiredFile ローテーション時の日時からMaxAge以上たったファイルを削除する
+func (l *fileLogger) deleteExpiredFile(fileList []os.FileInfo) error {
+	var err error
+	limit := time.Now().Add(-l.Conf.Rotate.MaxAge)
+	for _, fi := range fileList {
+		t, ok := rotatedTime(fi.Name())
+		if !ok || !t.Before(limit) {
+			continue
+		}
+		path := filepath.Join(l.file.fm.dir, fi.Name())
+		removeIndex(path)
+		if e := os.Remove(path); e != nil && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+	return err
+}
+
+// excluded ロックを取ってshouldNotOutputを呼ぶ
+func (l *fileLogger) excluded(level string) bool {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	return l.shouldNotOutput(level)
+}
+
+// shouldNotOutput 今のModeでlevelが除外されていればtrueを返す。
+// FATALとPANICは終了の理由が残らなくなるので、LogLevelConfで除外されていても出力する
 func (l *fileLogger) shouldNotOutput(level string) bool {
+	if level == FATAL || level == PANIC {
+		return false
+	}
 	idx, exist := l.Conf.LogLevelConf.findMode(l.Conf.Mode)
 	if !exist {
 		return false
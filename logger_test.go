@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,6 +31,7 @@ var (
 		FilePerm:    0666,
 		FileFlags:   FileFlags,
 		Compress:    true,
+		CreateDir:   true,
 		LogLevelConf: LogLevelConfig{
 			LevelConfig{
 				Mode:          ModeProduction,
@@ -61,6 +63,11 @@ func forPrintln(c int) {
 // (おそらくgoroutineの立ち上げすぎが原因だと思う)
 // 特定の値以下だとテストに失敗するのでifでチェックし必要ならpanicする
 func TestMain(m *testing.M) {
+	// 子プロセスとして起動された場合はテスト用のログを出力しない
+	if os.Getenv(helperProcessEnv) != "" {
+		os.Exit(m.Run())
+	}
+
 	if testCount > 100000 {
 		panic("logger_test.go: testCountは100000までの数値にしてください")
 	}
@@ -72,7 +79,6 @@ func TestMain(m *testing.M) {
 	}
 
 	Initialize(testConf)
-	os.Mkdir(dirPath, 0777)
 	forPrintln(testCount)
 
 	code := m.Run()
@@ -244,4 +250,40 @@ func TestShouldNotOutput(t *testing.T) {
 	logger.Conf.Mode = "ts"
 	assert.True(t, logger.shouldNotOutput("DEBUG"))
 	assert.False(t, logger.shouldNotOutput("INFO"))
+
+	// FATALとPANICは除外できない
+	logger.Conf.LogLevelConf[2].ExcludedLevel = []string{FATAL, PANIC}
+	assert.False(t, logger.shouldNotOutput(FATAL))
+	assert.False(t, logger.shouldNotOutput(PANIC))
+}
+
+// 設定したサイズを超えたらローテーションし、MaxAgeより古いファイルを削除するか
+func TestMaxSizeAndMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// MaxAgeで削除されるはずの古いファイルを置いておく
+	old := time.Now().Add(-48*time.Hour).Format(timeFormat) + "_size.log"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, old), []byte("old\n"), 0666))
+
+	logger := newFileLogger(&Config{
+		Rotate:      RotateConfig{MaxSize: 100, MaxAge: 24 * time.Hour},
+		LoggerFlags: LoggerFlags,
+		FilePath:    filepath.Join(dir, "size.log"),
+		FilePerm:    0666,
+		FileFlags:   FileFlags,
+	})
+	for i := 0; i < 10; i++ {
+		logger.printLevel(ERROR, "0123456789")
+	}
+
+	fi, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.True(t, len(fi) > 1)
+	for _, f := range fi {
+		assert.NotEqual(t, old, f.Name())
+		// 一行が100バイトを超えることはないので、どのファイルも100バイト+一行分に収まる
+		assert.True(t, f.Size() < 200, f.Name())
+	}
 }
@@ -0,0 +1,200 @@
+package filelogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LokiConfig LokiSinkの設定
+type LokiConfig struct {
+	URL         string            // push APIのURL。例: http://localhost:3100/loki/api/v1/push
+	Labels      map[string]string // すべてのストリームに付けるラベル
+	LevelLabel  string            // ログレベルを入れるラベルの名前。空の場合は付けない
+	ModeLabel   string            // 出力したときのConfig.Modeを入れるラベルの名前。空の場合は付けない
+	FieldLabels []string          // 値をラベルにするFieldsのキー。ラベルにした項目は行には出力しない
+	TenantID    string            // X-Scope-OrgIDヘッダーの値
+	Headers     map[string]string
+	Client      *http.Client // nilの場合はタイムアウトが10秒のhttp.Client
+	Batch       BatchConfig
+}
+
+// LokiSink Grafana Lokiのpush APIに送るSink。ラベルの組み合わせごとにストリームに分けて送る。
+// 行はメッセージのあとにタブで区切って、ラベルにしなかったFieldsと呼び出し元をkey=value形式で続けたもの。
+// Lokiは同じストリームで時刻が戻るものを受け付けないので、前に送ったものより古い時刻は前に送った時刻にそろえる
+type LokiSink struct {
+	*batcher
+	conf LokiConfig
+
+	// batcherのgoroutineだけが使う。ストリームごとに最後に送った時刻(Unixナノ秒)
+	last map[string]int64
+}
+
+// NewLokiSink conf.URLに送るLokiSinkを作成する
+func NewLokiSink(conf LokiConfig) (*LokiSink, error) {
+	if conf.URL == "" {
+		return nil, errors.New("filelogger: Loki sink URL is empty")
+	}
+	names := []string{conf.LevelLabel, conf.ModeLabel}
+	for name := range conf.Labels {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if name != "" && !lokiLabelPattern.MatchString(name) {
+			return nil, fmt.Errorf("filelogger: invalid Loki label name %q", name)
+		}
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	l := &LokiSink{conf: conf, last: map[string]int64{}}
+	b, err := newBatcher(conf.Batch, "loki.spool", l.send)
+	if err != nil {
+		return nil, err
+	}
+	l.batcher = b
+	return l, nil
+}
+
+// lokiLabelPattern Lokiのラベル名に使える形式
+var lokiLabelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// lokiLabelName Fieldsのキーをラベル名に使えるようにする
+func lokiLabelName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || '0' <= b[0] && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPush struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+func (l *LokiSink) send(batch []*Entry) error {
+	streams := map[string]*lokiStream{}
+	var order []string
+	last := map[string]int64{}
+
+	for _, e := range batch {
+		labels, line := l.labelsAndLine(e)
+		key := lokiStreamKey(labels)
+		s, ok := streams[key]
+		if !ok {
+			s = &lokiStream{Stream: labels}
+			streams[key] = s
+			order = append(order, key)
+		}
+
+		ts := e.Time.UnixNano()
+		prev, ok := last[key]
+		if !ok {
+			prev = l.last[key]
+		}
+		if ts < prev {
+			ts = prev
+		}
+		last[key] = ts
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(ts, 10), line})
+	}
+
+	push := &lokiPush{}
+	for _, key := range order {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, l.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.conf.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.conf.TenantID)
+	}
+	for k, v := range l.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	if _, err = doHTTP(l.conf.Client, req); err != nil {
+		return err
+	}
+
+	// 送れた場合だけ記録する。送れずに再試行するときは元の時刻からそろえ直す
+	for key, ts := range last {
+		l.last[key] = ts
+	}
+	return nil
+}
+
+// labelsAndLine Entryのラベルと行を作る
+func (l *LokiSink) labelsAndLine(e *Entry) (map[string]string, string) {
+	labels := make(map[string]string, len(l.conf.Labels)+len(l.conf.FieldLabels)+2)
+	for k, v := range l.conf.Labels {
+		labels[k] = v
+	}
+	if l.conf.LevelLabel != "" {
+		labels[l.conf.LevelLabel] = e.Level
+	}
+	if l.conf.ModeLabel != "" && e.Mode != "" {
+		labels[l.conf.ModeLabel] = e.Mode
+	}
+
+	fields := make(Fields, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	for _, k := range l.conf.FieldLabels {
+		if v, ok := fields[k]; ok {
+			labels[lokiLabelName(k)] = fmt.Sprint(v)
+			delete(fields, k)
+		}
+	}
+	if e.Caller != "" {
+		fields["caller"] = e.Caller
+	}
+
+	line := e.Message
+	if len(fields) > 0 {
+		line += "\t" + formatFields(fields)
+	}
+	return labels, line
+}
+
+// lokiStreamKey ラベルの組み合わせからストリームを区別する値を作る
+func lokiStreamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := &strings.Builder{}
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
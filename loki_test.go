@@ -0,0 +1,118 @@
+package filelogger
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lokiStub push APIのスタブ。受け取ったストリームを記録する
+type lokiStub struct {
+	mu      sync.Mutex
+	pushes  []lokiPush
+	tenant  string
+	failing int
+}
+
+func (ls *lokiStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.failing > 0 {
+		ls.failing--
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var p lokiPush
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ls.tenant = r.Header.Get("X-Scope-OrgID")
+	ls.pushes = append(ls.pushes, p)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestLokiSink(t *testing.T) {
+	stub := &lokiStub{failing: 1}
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	sink, err := NewLokiSink(LokiConfig{
+		URL:         ts.URL + "/loki/api/v1/push",
+		Labels:      map[string]string{"app": "test"},
+		LevelLabel:  "level",
+		ModeLabel:   "mode",
+		FieldLabels: []string{"service.name"},
+		TenantID:    "tenant1",
+		Batch:       BatchConfig{FlushInterval: time.Hour, RetryWait: time.Millisecond},
+	})
+	assert.NoError(t, err)
+
+	logger := newFileLogger(&Config{Mode: ModeProduction, Sinks: []Sink{sink}})
+	base := time.Unix(1575329115, 0)
+	for i, level := range []string{INFO, ERROR, INFO} {
+		logger.write(&Entry{
+			Time:    base.Add(time.Duration(i) * time.Second),
+			Level:   level,
+			Caller:  "main.go:1",
+			Message: "msg" + strconv.Itoa(i),
+			Fields:  Fields{"service.name": "api", "user": "alice bob"},
+		})
+	}
+	assert.NoError(t, logger.close())
+
+	assert.Len(t, stub.pushes, 1)
+	assert.Equal(t, "tenant1", stub.tenant)
+	streams := stub.pushes[0].Streams
+	assert.Len(t, streams, 2)
+	assert.Equal(t, map[string]string{"app": "test", "level": INFO, "mode": ModeProduction, "service_name": "api"}, streams[0].Stream)
+	assert.Equal(t, [][2]string{
+		{strconv.FormatInt(base.UnixNano(), 10), "msg0\tcaller=main.go:1 user=\"alice bob\""},
+		{strconv.FormatInt(base.Add(2*time.Second).UnixNano(), 10), "msg2\tcaller=main.go:1 user=\"alice bob\""},
+	}, streams[0].Values)
+	assert.Equal(t, ERROR, streams[1].Stream["level"])
+}
+
+// 前に送ったものより古い時刻が、ストリームごとに前に送った時刻にそろえられるか
+func TestLokiSinkOrdering(t *testing.T) {
+	stub := &lokiStub{}
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	sink, err := NewLokiSink(LokiConfig{
+		URL:        ts.URL + "/loki/api/v1/push",
+		LevelLabel: "level",
+		Batch:      BatchConfig{FlushInterval: time.Hour},
+	})
+	assert.NoError(t, err)
+
+	base := time.Unix(1575329115, 0)
+	assert.NoError(t, sink.Write(&Entry{Time: base.Add(time.Second), Level: INFO, Message: "a"}))
+	assert.NoError(t, sink.Flush())
+	assert.NoError(t, sink.Write(&Entry{Time: base, Level: INFO, Message: "b"}))
+	assert.NoError(t, sink.Write(&Entry{Time: base, Level: WARN, Message: "c"}))
+	assert.NoError(t, sink.Close())
+
+	assert.Len(t, stub.pushes, 2)
+	second := stub.pushes[1].Streams
+	assert.Equal(t, strconv.FormatInt(base.Add(time.Second).UnixNano(), 10), second[0].Values[0][0])
+	assert.Equal(t, strconv.FormatInt(base.UnixNano(), 10), second[1].Values[0][0])
+}
+
+func TestLokiSinkConfig(t *testing.T) {
+	_, err := NewLokiSink(LokiConfig{})
+	assert.Error(t, err)
+	_, err = NewLokiSink(LokiConfig{URL: "http://localhost", Labels: map[string]string{"bad-name": "x"}})
+	assert.Error(t, err)
+	assert.Equal(t, "_1a_b", lokiLabelName("1a.b"))
+}
@@ -0,0 +1,383 @@
+package filelogger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// msgpackEncoder Fluentdのforwardプロトコルで使う範囲のMessagePackのエンコーダー。
+// time.TimeはFluentdのEventTime(拡張型0)にし、対応していない型はfmt.Sprintで文字列にする
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (m *msgpackEncoder) bytes() []byte {
+	return m.buf
+}
+
+func (m *msgpackEncoder) encode(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		m.buf = append(m.buf, 0xc0)
+	case bool:
+		if v {
+			m.buf = append(m.buf, 0xc3)
+		} else {
+			m.buf = append(m.buf, 0xc2)
+		}
+	case int:
+		m.encodeInt(int64(v))
+	case int8:
+		m.encodeInt(int64(v))
+	case int16:
+		m.encodeInt(int64(v))
+	case int32:
+		m.encodeInt(int64(v))
+	case int64:
+		m.encodeInt(v)
+	case uint:
+		m.encodeUint(uint64(v))
+	case uint8:
+		m.encodeUint(uint64(v))
+	case uint16:
+		m.encodeUint(uint64(v))
+	case uint32:
+		m.encodeUint(uint64(v))
+	case uint64:
+		m.encodeUint(v)
+	case float32:
+		m.buf = append(m.buf, 0xca)
+		m.buf = appendUint32(m.buf, math.Float32bits(v))
+	case float64:
+		m.buf = append(m.buf, 0xcb)
+		m.buf = appendUint64(m.buf, math.Float64bits(v))
+	case string:
+		m.encodeString(v)
+	case []byte:
+		m.encodeBin(v)
+	case time.Time:
+		m.encodeEventTime(v)
+	case []interface{}:
+		m.encodeArrayLen(len(v))
+		for _, e := range v {
+			m.encode(e)
+		}
+	case map[string]interface{}:
+		m.encodeMap(v)
+	case Fields:
+		m.encodeMap(v)
+	case error, fmt.Stringer:
+		// nilのポインタでError()やString()がpanicしても、fmt.Sprintは"<nil>"にする
+		m.encodeString(fmt.Sprint(v))
+	default:
+		m.encodeReflect(v)
+	}
+}
+
+// encodeReflect スライスと、キーが文字列のマップを要素ごとにエンコードする。それ以外は文字列にする
+func (m *msgpackEncoder) encodeReflect(v interface{}) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		m.encodeArrayLen(rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			m.encode(rv.Index(i).Interface())
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			mp := make(map[string]interface{}, rv.Len())
+			iter := rv.MapRange()
+			for iter.Next() {
+				mp[iter.Key().String()] = iter.Value().Interface()
+			}
+			m.encodeMap(mp)
+			return
+		}
+		m.encodeString(fmt.Sprint(v))
+	case reflect.Ptr:
+		if rv.IsNil() {
+			m.encode(nil)
+			return
+		}
+		m.encode(rv.Elem().Interface())
+	default:
+		m.encodeString(fmt.Sprint(v))
+	}
+}
+
+// encodeMap 出力が毎回同じになるようにキーの順に並べる
+func (m *msgpackEncoder) encodeMap(v map[string]interface{}) {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	m.encodeMapLen(len(v))
+	for _, k := range keys {
+		m.encodeString(k)
+		m.encode(v[k])
+	}
+}
+
+func (m *msgpackEncoder) encodeInt(v int64) {
+	switch {
+	case v >= 0:
+		m.encodeUint(uint64(v))
+	case v >= -32:
+		m.buf = append(m.buf, byte(v))
+	case v >= math.MinInt8:
+		m.buf = append(m.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		m.buf = append(m.buf, 0xd1)
+		m.buf = appendUint16(m.buf, uint16(v))
+	case v >= math.MinInt32:
+		m.buf = append(m.buf, 0xd2)
+		m.buf = appendUint32(m.buf, uint32(v))
+	default:
+		m.buf = append(m.buf, 0xd3)
+		m.buf = appendUint64(m.buf, uint64(v))
+	}
+}
+
+func (m *msgpackEncoder) encodeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		m.buf = append(m.buf, byte(v))
+	case v <= math.MaxUint8:
+		m.buf = append(m.buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		m.buf = append(m.buf, 0xcd)
+		m.buf = appendUint16(m.buf, uint16(v))
+	case v <= math.MaxUint32:
+		m.buf = append(m.buf, 0xce)
+		m.buf = appendUint32(m.buf, uint32(v))
+	default:
+		m.buf = append(m.buf, 0xcf)
+		m.buf = appendUint64(m.buf, v)
+	}
+}
+
+func (m *msgpackEncoder) encodeString(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		m.buf = append(m.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xda)
+		m.buf = appendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xdb)
+		m.buf = appendUint32(m.buf, uint32(n))
+	}
+	m.buf = append(m.buf, s...)
+}
+
+func (m *msgpackEncoder) encodeBin(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xc5)
+		m.buf = appendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xc6)
+		m.buf = appendUint32(m.buf, uint32(n))
+	}
+	m.buf = append(m.buf, b...)
+}
+
+func (m *msgpackEncoder) encodeArrayLen(n int) {
+	switch {
+	case n <= 15:
+		m.buf = append(m.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xdc)
+		m.buf = appendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xdd)
+		m.buf = appendUint32(m.buf, uint32(n))
+	}
+}
+
+func (m *msgpackEncoder) encodeMapLen(n int) {
+	switch {
+	case n <= 15:
+		m.buf = append(m.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xde)
+		m.buf = appendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xdf)
+		m.buf = appendUint32(m.buf, uint32(n))
+	}
+}
+
+// encodeEventTime FluentdのEventTime。fixext 8で、拡張型0に秒とナノ秒をそれぞれ32bitで入れる
+func (m *msgpackEncoder) encodeEventTime(t time.Time) {
+	m.buf = append(m.buf, 0xd7, 0x00)
+	m.buf = appendUint32(m.buf, uint32(t.Unix()))
+	m.buf = appendUint32(m.buf, uint32(t.Nanosecond()))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+// msgpackDecoder ackの応答を読むためのMessagePackのデコーダー。
+// マップはmap[string]interface{}、配列は[]interface{}、整数はint64かuint64、EventTimeはtime.Timeにする
+type msgpackDecoder struct {
+	r *bufio.Reader
+}
+
+func newMsgpackDecoder(r io.Reader) *msgpackDecoder {
+	return &msgpackDecoder{r: bufio.NewReader(r)}
+}
+
+var errMsgpackUnsupported = errors.New("filelogger: unsupported msgpack type")
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return d.readString(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.readArray(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return d.readMap(int(c & 0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.readUint(1 << (c - 0xcc))
+		return v, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		v, err := d.readUint(size)
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, err
+	case 0xca:
+		v, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readUint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.readArray(int(n))
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.readMap(int(n))
+	case 0xd7:
+		b, err := d.readBytes(9)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != 0 {
+			return nil, errMsgpackUnsupported
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(b[1:5])), int64(binary.BigEndian.Uint32(b[5:]))), nil
+	}
+	return nil, errMsgpackUnsupported
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.readBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (d *msgpackDecoder) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return b, err
+}
+
+func (d *msgpackDecoder) readString(n int) (string, error) {
+	b, err := d.readBytes(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) readArray(n int) ([]interface{}, error) {
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) readMap(n int) (map[string]interface{}, error) {
+	mp := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		mp[fmt.Sprint(k)] = v
+	}
+	return mp, nil
+}
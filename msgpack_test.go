@@ -0,0 +1,79 @@
+package filelogger
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeMsgpack(t *testing.T, v interface{}) interface{} {
+	enc := &msgpackEncoder{}
+	enc.encode(v)
+	dec := newMsgpackDecoder(bytes.NewReader(enc.bytes()))
+	got, err := dec.decode()
+	assert.NoError(t, err)
+	return got
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	now := time.Unix(1575329115, 123456789)
+	tests := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{nil, nil},
+		{true, true},
+		{false, false},
+		{0, int64(0)},
+		{127, int64(127)},
+		{128, uint64(128)},
+		{70000, uint64(70000)},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{-1, int64(-1)},
+		{-32, int64(-32)},
+		{-33, int64(-33)},
+		{-200, int64(-200)},
+		{-40000, int64(-40000)},
+		{int64(math.MinInt64), int64(math.MinInt64)},
+		{float32(1.5), 1.5},
+		{2.25, 2.25},
+		{"", ""},
+		{strings.Repeat("a", 31), strings.Repeat("a", 31)},
+		{strings.Repeat("b", 200), strings.Repeat("b", 200)},
+		{strings.Repeat("c", 70000), strings.Repeat("c", 70000)},
+		{[]byte{1, 2, 3}, []byte{1, 2, 3}},
+		{now, now},
+		{errors.New("boom"), "boom"},
+		{(*os.PathError)(nil), "<nil>"},
+		{(*url.URL)(nil), "<nil>"},
+		{time.Second, "1s"},
+		{[]string{"x", "y"}, []interface{}{"x", "y"}},
+		{make([]interface{}, 20), make([]interface{}, 20)},
+		{map[string]int{"a": 1}, map[string]interface{}{"a": int64(1)}},
+		{Fields{"k": []interface{}{1, "v"}}, map[string]interface{}{"k": []interface{}{int64(1), "v"}}},
+		{struct{ A int }{1}, "{1}"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, decodeMsgpack(t, tt.in), "%#v", tt.in)
+	}
+}
+
+// マップはキーの順に並べるので、同じ値からは同じバイト列になるか
+func TestMsgpackMapOrder(t *testing.T) {
+	enc := &msgpackEncoder{}
+	enc.encode(map[string]interface{}{"b": 2, "a": 1})
+	assert.Equal(t, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}, enc.bytes())
+
+	big := map[string]interface{}{}
+	for i := 0; i < 20; i++ {
+		big[strings.Repeat("k", i+1)] = i
+	}
+	assert.Len(t, decodeMsgpack(t, big), 20)
+}
@@ -0,0 +1,322 @@
+package filelogger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLPConfig OTLPSinkの設定
+type OTLPConfig struct {
+	URL                string                 // OTLP/HTTPのlogsのURL。空の場合は"http://localhost:4318/v1/logs"
+	ServiceName        string                 // リソースのservice.name
+	ResourceAttributes map[string]interface{} // service.name以外のリソースの属性
+	TraceIDField       string                 // trace_idにするFieldsのキー。空の場合は"trace_id"
+	SpanIDField        string                 // span_idにするFieldsのキー。空の場合は"span_id"
+	Headers            map[string]string
+	Client             *http.Client // nilの場合はタイムアウトが10秒のhttp.Client
+	Batch              BatchConfig
+}
+
+const (
+	defaultOTLPURL      = "http://localhost:4318/v1/logs"
+	defaultTraceIDField = "trace_id"
+	defaultSpanIDField  = "span_id"
+	otlpScopeName       = "github.com/ha-ya4/file-logger"
+)
+
+// OTLPのSeverityNumber
+const (
+	otlpSeverityUnspecified = 0
+	otlpSeverityDebug       = 5
+	otlpSeverityInfo        = 9
+	otlpSeverityWarn        = 13
+	otlpSeverityError       = 17
+	otlpSeverityFatal       = 21
+	otlpSeverityFatal2      = 22
+)
+
+// OTLPSeverity このパッケージのログレベルをOTLPのSeverityNumberにする。PANICはFATALより一つ上にする。定義されていないレベルは0(UNSPECIFIED)
+func OTLPSeverity(level string) int {
+	switch level {
+	case DEBUG:
+		return otlpSeverityDebug
+	case INFO:
+		return otlpSeverityInfo
+	case WARN:
+		return otlpSeverityWarn
+	case ERROR:
+		return otlpSeverityError
+	case FATAL:
+		return otlpSeverityFatal
+	case PANIC:
+		return otlpSeverityFatal2
+	}
+	return otlpSeverityUnspecified
+}
+
+// OTLPSink OpenTelemetryのログのデータモデルに変換し、OTLP/HTTPのJSONでCollectorに送るSink。
+// Fieldsは属性に、呼び出し元はcode.filepathとcode.linenoにする。
+// TraceIDField、SpanIDFieldの値が16進数の正しい長さであれば、属性ではなくtraceId、spanIdにする
+type OTLPSink struct {
+	*batcher
+	conf     OTLPConfig
+	resource otlpResource
+}
+
+// NewOTLPSink conf.URLに送るOTLPSinkを作成する
+func NewOTLPSink(conf OTLPConfig) (*OTLPSink, error) {
+	if conf.URL == "" {
+		conf.URL = defaultOTLPURL
+	}
+	if conf.TraceIDField == "" {
+		conf.TraceIDField = defaultTraceIDField
+	}
+	if conf.SpanIDField == "" {
+		conf.SpanIDField = defaultSpanIDField
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	attrs := make(map[string]interface{}, len(conf.ResourceAttributes)+1)
+	for k, v := range conf.ResourceAttributes {
+		attrs[k] = v
+	}
+	if conf.ServiceName != "" {
+		attrs["service.name"] = conf.ServiceName
+	}
+
+	s := &OTLPSink{conf: conf, resource: otlpResource{Attributes: otlpAttributes(attrs)}}
+	b, err := newBatcher(conf.Batch, "otlp.spool", s.send)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = b
+	return s, nil
+}
+
+// OTLPのJSONエンコーディング。64bitの整数は文字列にする
+type (
+	otlpRequest struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes,omitempty"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		TimeUnixNano         string         `json:"timeUnixNano"`
+		ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+		SeverityNumber       int            `json:"severityNumber"`
+		SeverityText         string         `json:"severityText,omitempty"`
+		Body                 otlpAnyValue   `json:"body"`
+		Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+		TraceID              string         `json:"traceId,omitempty"`
+		SpanID               string         `json:"spanId,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string         `json:"stringValue,omitempty"`
+		BoolValue   *bool           `json:"boolValue,omitempty"`
+		IntValue    *string         `json:"intValue,omitempty"`
+		DoubleValue *float64        `json:"doubleValue,omitempty"`
+		ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+		KvlistValue *otlpKvlist     `json:"kvlistValue,omitempty"`
+	}
+	otlpArrayValue struct {
+		Values []otlpAnyValue `json:"values"`
+	}
+	otlpKvlist struct {
+		Values []otlpKeyValue `json:"values"`
+	}
+	otlpResponse struct {
+		PartialSuccess *struct {
+			RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+			ErrorMessage       string      `json:"errorMessage"`
+		} `json:"partialSuccess"`
+	}
+)
+
+func (s *OTLPSink) send(batch []*Entry) error {
+	observed := strconv.FormatInt(time.Now().UnixNano(), 10)
+	records := make([]otlpLogRecord, len(batch))
+	for i, e := range batch {
+		records[i] = s.logRecord(e, observed)
+	}
+	body, err := json.Marshal(&otlpRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource: s.resource,
+		ScopeLogs: []otlpScopeLogs{{
+			Scope:      otlpScope{Name: otlpScopeName},
+			LogRecords: records,
+		}},
+	}}})
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	respBody, err := doHTTP(s.conf.Client, req)
+	if err != nil {
+		return err
+	}
+
+	// 一部だけ受け付けられなかった場合は、どれかがわからないので件数だけを捨てたものとして数える
+	var resp otlpResponse
+	if json.Unmarshal(respBody, &resp) != nil || resp.PartialSuccess == nil {
+		return nil
+	}
+	rejected, _ := resp.PartialSuccess.RejectedLogRecords.Int64()
+	if rejected <= 0 {
+		return nil
+	}
+	return &partialError{
+		err:     fmt.Errorf("filelogger: OTLP collector rejected %d log records: %s", rejected, resp.PartialSuccess.ErrorMessage),
+		dropped: int(rejected),
+	}
+}
+
+func (s *OTLPSink) logRecord(e *Entry, observed string) otlpLogRecord {
+	r := otlpLogRecord{
+		TimeUnixNano:         strconv.FormatInt(e.Time.UnixNano(), 10),
+		ObservedTimeUnixNano: observed,
+		SeverityNumber:       OTLPSeverity(e.Level),
+		SeverityText:         e.Level,
+		Body:                 otlpValue(e.Message),
+	}
+
+	attrs := make(map[string]interface{}, len(e.Fields)+2)
+	for k, v := range e.Fields {
+		attrs[k] = v
+	}
+	if id, ok := otlpID(attrs[s.conf.TraceIDField], 16); ok {
+		r.TraceID = id
+		delete(attrs, s.conf.TraceIDField)
+	}
+	if id, ok := otlpID(attrs[s.conf.SpanIDField], 8); ok {
+		r.SpanID = id
+		delete(attrs, s.conf.SpanIDField)
+	}
+	if e.Caller != "" {
+		if i := strings.LastIndexByte(e.Caller, ':'); i >= 0 {
+			attrs["code.filepath"] = e.Caller[:i]
+			if line, err := strconv.Atoi(e.Caller[i+1:]); err == nil {
+				attrs["code.lineno"] = line
+			}
+		} else {
+			attrs["code.filepath"] = e.Caller
+		}
+	}
+	r.Attributes = otlpAttributes(attrs)
+	return r
+}
+
+// otlpID vがsizeバイトの16進数の文字列で、すべて0でなければ小文字にして返す
+func otlpID(v interface{}, size int) (string, bool) {
+	s, ok := v.(string)
+	if !ok || len(s) != size*2 {
+		return "", false
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || bytes.Equal(b, make([]byte, size)) {
+		return "", false
+	}
+	return hex.EncodeToString(b), true
+}
+
+// otlpAttributes キーの順に並べた属性にする
+func otlpAttributes(m map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, len(keys))
+	for i, k := range keys {
+		kvs[i] = otlpKeyValue{Key: k, Value: otlpValue(m[k])}
+	}
+	return kvs
+}
+
+// otlpDouble 浮動小数点数をAnyValueにする。NaNと無限大はJSONにできず、一件のためにまとめて送るほかのEntryも送れなくなるので文字列にする
+func otlpDouble(f float64) otlpAnyValue {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		return otlpAnyValue{StringValue: &s}
+	}
+	return otlpAnyValue{DoubleValue: &f}
+}
+
+// otlpValue 値をAnyValueにする。スライスとキーが文字列のマップは要素ごとに変換し、対応していない型はfmt.Sprintで文字列にする
+func otlpValue(v interface{}) otlpAnyValue {
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{IntValue: &s}
+	case float32:
+		return otlpDouble(float64(v))
+	case float64:
+		return otlpDouble(v)
+	case []byte:
+		s := string(v)
+		return otlpAnyValue{StringValue: &s}
+	case error, fmt.Stringer:
+		// nilのポインタでError()やString()がpanicしても、fmt.Sprintは"<nil>"にする
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		values := make([]otlpAnyValue, rv.Len())
+		for i := range values {
+			values[i] = otlpValue(rv.Index(i).Interface())
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, rv.Len())
+			iter := rv.MapRange()
+			for iter.Next() {
+				m[iter.Key().String()] = iter.Value().Interface()
+			}
+			return otlpAnyValue{KvlistValue: &otlpKvlist{Values: otlpAttributes(m)}}
+		}
+	}
+	s := fmt.Sprint(v)
+	return otlpAnyValue{StringValue: &s}
+}
@@ -0,0 +1,110 @@
+package filelogger
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTLPSink(t *testing.T) {
+	var got otlpRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"partialSuccess":{"rejectedLogRecords":"1","errorMessage":"too old"}}`))
+	}))
+	defer ts.Close()
+
+	sink, err := NewOTLPSink(OTLPConfig{
+		URL:                ts.URL + "/v1/logs",
+		ServiceName:        "checkout",
+		ResourceAttributes: map[string]interface{}{"deployment.environment": "prod"},
+		Batch:              BatchConfig{FlushInterval: time.Hour},
+	})
+	assert.NoError(t, err)
+
+	now := time.Unix(1575329115, 123)
+	assert.NoError(t, sink.Write(&Entry{
+		Time:    now,
+		Level:   WARN,
+		Caller:  "/src/app/main.go:42",
+		Message: "slow request",
+		Fields: Fields{
+			"trace_id": "4BF92F3577B34DA6A3CE929D0E0E4736",
+			"span_id":  "00f067aa0ba902b7",
+			"ms":       1500,
+			"ok":       false,
+			"ratio":    0.5,
+			"tags":     []string{"a", "b"},
+		},
+	}))
+	assert.NoError(t, sink.Write(&Entry{Time: now, Level: "TRACE", Message: "x", Fields: Fields{"trace_id": "bad"}}))
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, uint64(1), sink.Dropped())
+
+	rl := got.ResourceLogs[0]
+	assert.Equal(t, otlpAttributes(map[string]interface{}{
+		"deployment.environment": "prod",
+		"service.name":           "checkout",
+	}), rl.Resource.Attributes)
+	assert.Equal(t, otlpScopeName, rl.ScopeLogs[0].Scope.Name)
+
+	records := rl.ScopeLogs[0].LogRecords
+	assert.Len(t, records, 2)
+	r := records[0]
+	assert.Equal(t, "1575329115000000123", r.TimeUnixNano)
+	assert.NotEmpty(t, r.ObservedTimeUnixNano)
+	assert.Equal(t, 13, r.SeverityNumber)
+	assert.Equal(t, WARN, r.SeverityText)
+	assert.Equal(t, "slow request", *r.Body.StringValue)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", r.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", r.SpanID)
+	assert.Equal(t, otlpAttributes(map[string]interface{}{
+		"code.filepath": "/src/app/main.go",
+		"code.lineno":   42,
+		"ms":            1500,
+		"ok":            false,
+		"ratio":         0.5,
+		"tags":          []interface{}{"a", "b"},
+	}), r.Attributes)
+
+	assert.Equal(t, 0, records[1].SeverityNumber)
+	assert.Empty(t, records[1].TraceID)
+	assert.Equal(t, "bad", *records[1].Attributes[0].Value.StringValue)
+}
+
+// nilのポインタのerrorやfmt.Stringerでpanicしないか
+func TestOTLPValueNilPointer(t *testing.T) {
+	for _, v := range []interface{}{(*os.PathError)(nil), (*url.URL)(nil)} {
+		got := otlpValue(v)
+		if assert.NotNil(t, got.StringValue) {
+			assert.Equal(t, "<nil>", *got.StringValue)
+		}
+	}
+}
+
+// JSONにできないNaNと無限大は文字列にするか
+func TestOTLPValueNaN(t *testing.T) {
+	for v, want := range map[float64]string{math.Inf(1): "+Inf", math.Inf(-1): "-Inf"} {
+		assert.Equal(t, want, *otlpValue(v).StringValue)
+	}
+	assert.Equal(t, "NaN", *otlpValue(float32(math.NaN())).StringValue)
+	_, err := json.Marshal(otlpValue(math.NaN()))
+	assert.NoError(t, err)
+}
+
+func TestOTLPSeverity(t *testing.T) {
+	for level, want := range map[string]int{DEBUG: 5, INFO: 9, WARN: 13, ERROR: 17, FATAL: 21, PANIC: 22, "OTHER": 0} {
+		assert.Equal(t, want, OTLPSeverity(level), level)
+	}
+}
@@ -0,0 +1,475 @@
+package filelogger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader ローテーションしたファイルと出力中のファイルを古い順に読み、Entryにして返す。
+// 圧縮や暗号化されたファイルは復号、解凍しながら読む。ファイルの一覧はNewReaderの時点のもので、読んでいる間に削除されたファイルは飛ばす。
+//
+//	r, err := filelogger.NewReader(conf)
+//	defer r.Close()
+//	for r.Next() {
+//		e := r.Entry()
+//	}
+//	err = r.Err()
+type Reader struct {
+	parser entryParser
+	files  []string
+	since  time.Time
+	keys   KeyProvider
+
+	file       *os.File
+	scanner    *entryScanner
+	compressed bool // 最後に開いたファイルが圧縮されていたか
+	encrypted  bool // 最後に開いたファイルが暗号化されていたか
+	entry      *Entry
+	err        error
+}
+
+// NewReader confのFilePathのファイルを読むReaderを作成する。行はconfのLoggerFlagsとPrefixで出力されたものとして解析する
+func NewReader(conf *Config) (*Reader, error) {
+	if conf.FilePath == "" {
+		return nil, errors.New("filelogger: FilePath is empty")
+	}
+	return &Reader{
+		parser: entryParser{flags: conf.LoggerFlags, prefix: conf.Prefix},
+		files:  LogFiles(conf.FilePath),
+		keys:   conf.Encrypt.Keys,
+	}, nil
+}
+
+// NewPathReader pathのファイルを、このパッケージのLoggerFlagsで出力されたものとして読むReaderを作成する
+func NewPathReader(path string) (*Reader, error) {
+	return NewReader(&Config{FilePath: path, LoggerFlags: LoggerFlags})
+}
+
+// LogFiles pathのファイルとローテーションしたファイルのパスを古い順に返す。出力中のファイルが最後になる
+func LogFiles(path string) []string {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	var rotated []string
+	active := false
+	for _, fi := range logFileList(dir, name) {
+		if fi.Name() == name {
+			active = true
+			continue
+		}
+		rotated = append(rotated, fi.Name())
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		ti, _ := rotatedTime(rotated[i])
+		tj, _ := rotatedTime(rotated[j])
+		return ti.Before(tj)
+	})
+
+	files := make([]string, 0, len(rotated)+1)
+	for _, n := range rotated {
+		files = append(files, filepath.Join(dir, n))
+	}
+	if active {
+		files = append(files, path)
+	}
+	return files
+}
+
+// SeekTime t以降のEntryだけを読むようにする。最初のNextの前に呼ぶ。
+// ローテーションした日時がtより前のファイルは開かず、索引があるファイルはtの少し前のチェックポイントから読む。
+// 圧縮されたファイルも区間ごとに圧縮されていれば先頭から解凍せずに済む。暗号化されたファイルは先頭から読む。日時はLoggerFlagsにLdateがある場合にだけ使える
+func (r *Reader) SeekTime(t time.Time) {
+	r.since = t
+	for len(r.files) > 1 {
+		rt, ok := rotatedTime(filepath.Base(r.files[0]))
+		if !ok || !rt.Before(t) {
+			break
+		}
+		r.files = r.files[1:]
+	}
+}
+
+// Next 次のEntryを読む。読み終えたかエラーの場合はfalseを返す
+func (r *Reader) Next() bool {
+	for r.err == nil {
+		if r.scanner == nil {
+			if len(r.files) == 0 {
+				return false
+			}
+			path := r.files[0]
+			r.files = r.files[1:]
+			if err := r.open(path); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				r.err = err
+				return false
+			}
+		}
+
+		if e, ok := r.scanner.next(); ok {
+			if !r.since.IsZero() && e.Time.Before(r.since) {
+				continue
+			}
+			r.entry = e
+			return true
+		}
+		if err := r.scanner.err(); err != nil {
+			r.err = err
+		}
+		r.closeFile()
+	}
+	return false
+}
+
+// Entry Nextで読んだEntryを返す
+func (r *Reader) Entry() *Entry {
+	return r.entry
+}
+
+// Err 読んでいる間に起きたエラーを返す
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close 開いているファイルを閉じる
+func (r *Reader) Close() error {
+	r.files = nil
+	return r.closeFile()
+}
+
+// open ファイルを開く。暗号化されていれば復号し、gzipで圧縮されていれば解凍しながら読む
+func (r *Reader) open(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	br, encrypted, err := decryptLogFile(bufio.NewReader(f), r.keys)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	r.encrypted = encrypted
+	r.compressed = isGzip(br)
+	if !r.since.IsZero() && !r.encrypted {
+		if br, err = r.seek(f, br, path); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	var src io.Reader = br
+	if r.compressed {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		src = zr
+	}
+	r.file = f
+	r.scanner = newEntryScanner(src, r.parser)
+	return nil
+}
+
+// seek 索引があればsinceの少し前のチェックポイントに移る。圧縮されたファイルは区間ごとに圧縮されている場合だけ移る
+func (r *Reader) seek(f *os.File, br *bufio.Reader, path string) (*bufio.Reader, error) {
+	idx, err := readIndex(path)
+	if err != nil {
+		return br, nil
+	}
+	cp, ok := idx.seek(r.since)
+	if !ok {
+		return br, nil
+	}
+	offset := cp.Offset
+	if r.compressed {
+		if !idx.Blocks {
+			return br, nil
+		}
+		offset = cp.CompressedOffset
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(f), nil
+}
+
+func (r *Reader) closeFile() error {
+	r.scanner = nil
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// openLogFile pathのログファイルを開く。暗号化されていればkeysの鍵で復号し、圧縮されていれば解凍しながら読む
+func openLogFile(path string, keys KeyProvider) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br, _, err := decryptLogFile(bufio.NewReader(f), keys)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if !isGzip(br) {
+		return readCloser{br, f}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return readCloser{zr, f}, nil
+}
+
+// readCloser Readerと、閉じる元のファイルを組み合わせる
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// isGzip 先頭がgzipのマジックナンバーかを返す。CompressFileは名前を変えずに圧縮するので中身で判断する
+func isGzip(br *bufio.Reader) bool {
+	b, err := br.Peek(2)
+	return err == nil && b[0] == 0x1f && b[1] == 0x8b
+}
+
+// entryScanner 行を読んでEntryにする
+type entryScanner struct {
+	r       *bufio.Reader
+	asm     entryAssembler
+	readErr error
+}
+
+func newEntryScanner(r io.Reader, parser entryParser) *entryScanner {
+	return &entryScanner{r: bufio.NewReader(r), asm: entryAssembler{parser: parser}}
+}
+
+func (s *entryScanner) next() (*Entry, bool) {
+	for s.readErr == nil {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			s.readErr = err
+			if line == "" {
+				break
+			}
+		}
+		if e := s.asm.add(strings.TrimSuffix(line, "\n")); e != nil {
+			return e, true
+		}
+	}
+
+	if e := s.asm.flush(); e != nil {
+		return e, true
+	}
+	return nil, false
+}
+
+func (s *entryScanner) err() error {
+	if s.readErr == io.EOF {
+		return nil
+	}
+	return s.readErr
+}
+
+// entryAssembler 行をEntryにまとめる。ヘッダーのない行は、メッセージに改行が含まれていたものとして前のEntryに続ける
+type entryAssembler struct {
+	parser  entryParser
+	pending *Entry // 次の行を読むまで続きがあるかわからないEntry
+	rest    []string
+}
+
+// add 一行を加える。次のEntryが始まって前のEntryが完成した場合は前のEntryを返す
+func (a *entryAssembler) add(line string) *Entry {
+	// 監査ログのファイルの先頭の行はEntryではない
+	if strings.HasPrefix(line, auditHeaderPrefix) {
+		return nil
+	}
+	e, ok := a.parser.parseHeader(line)
+	if !ok {
+		if a.pending == nil {
+			a.pending = &Entry{}
+		}
+		a.rest = append(a.rest, line)
+		return nil
+	}
+
+	prev := a.flush()
+	a.pending, a.rest = e, []string{e.Message}
+	return prev
+}
+
+// flush 続きの行をまとめて、メッセージとFieldsに分けたEntryを返す
+func (a *entryAssembler) flush() *Entry {
+	e := a.pending
+	if e == nil {
+		return nil
+	}
+	a.pending = nil
+	e.Message, e.Fields = splitFields(strings.Join(a.rest, "\n"))
+	a.rest = nil
+	return e
+}
+
+// entryParser formatEntryで出力した行を解析する
+type entryParser struct {
+	flags  int
+	prefix string
+}
+
+// callerPattern 呼び出し元の"path:line: "
+var callerPattern = regexp.MustCompile(`^(.+?:\d+): `)
+
+// parseHeader prefix、日時、呼び出し元、[LEVEL]を読み取る。Messageには残りをそのまま入れる
+func (p entryParser) parseHeader(line string) (*Entry, bool) {
+	if !strings.HasPrefix(line, p.prefix) {
+		return nil, false
+	}
+	line = line[len(p.prefix):]
+	e := &Entry{}
+
+	if p.flags&(log.Ldate|log.Ltime|log.Lmicroseconds) != 0 {
+		var layout string
+		if p.flags&log.Ldate != 0 {
+			layout = "2006/01/02 "
+		}
+		if p.flags&log.Lmicroseconds != 0 {
+			layout += "15:04:05.000000 "
+		} else if p.flags&log.Ltime != 0 {
+			layout += "15:04:05 "
+		}
+		if len(line) < len(layout) {
+			return nil, false
+		}
+		loc := time.Local
+		if p.flags&log.LUTC != 0 {
+			loc = time.UTC
+		}
+		t, err := time.ParseInLocation(layout, line[:len(layout)], loc)
+		if err != nil {
+			return nil, false
+		}
+		e.Time = t
+		line = line[len(layout):]
+	}
+
+	if p.flags&callerFlags != 0 {
+		m := callerPattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, false
+		}
+		e.Caller = m[1]
+		line = line[len(m[0]):]
+	}
+
+	if !strings.HasPrefix(line, "[") {
+		return nil, false
+	}
+	end := strings.Index(line, "] ")
+	if end < 0 {
+		if !strings.HasSuffix(line, "]") {
+			return nil, false
+		}
+		end = len(line) - 1
+		e.Level = line[1:end]
+		return e, true
+	}
+	e.Level = line[1:end]
+	e.Message = line[end+2:]
+	return e, true
+}
+
+// splitFields メッセージのあとにタブで区切って続くkey=valueをFieldsにする。値は文字列になる
+func splitFields(s string) (string, Fields) {
+	i := strings.LastIndexByte(s, '\t')
+	if i < 0 {
+		return s, nil
+	}
+	fields, ok := parseFields(s[i+1:])
+	if !ok {
+		return s, nil
+	}
+	return s[:i], fields
+}
+
+// parseFields formatFieldsで出力したものを読む。形式が違えばfalseを返す
+func parseFields(s string) (Fields, bool) {
+	fields := Fields{}
+	for len(s) > 0 {
+		// 空白や=を含む名前はクォートされている
+		var key string
+		if strings.HasPrefix(s, `"`) {
+			q, ok := quotedPrefix(s)
+			if !ok || !strings.HasPrefix(s[len(q):], "=") {
+				return nil, false
+			}
+			key, _ = strconv.Unquote(q)
+			s = s[len(q)+1:]
+		} else {
+			eq := strings.IndexByte(s, '=')
+			if eq <= 0 || strings.ContainsAny(s[:eq], " \"") {
+				return nil, false
+			}
+			key = s[:eq]
+			s = s[eq+1:]
+		}
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			q, ok := quotedPrefix(s)
+			if !ok {
+				return nil, false
+			}
+			value, _ = strconv.Unquote(q)
+			s = s[len(q):]
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			value = s[:end]
+			s = s[end:]
+		}
+		fields[key] = value
+
+		if len(s) > 0 {
+			if s[0] != ' ' {
+				return nil, false
+			}
+			s = s[1:]
+		}
+	}
+	if len(fields) == 0 {
+		return nil, false
+	}
+	return fields, true
+}
+
+// quotedPrefix sの先頭のstrconv.Quoteで出力された文字列を返す
+func quotedPrefix(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			if _, err := strconv.Unquote(s[:i+1]); err != nil {
+				return "", false
+			}
+			return s[:i+1], true
+		}
+	}
+	return "", false
+}
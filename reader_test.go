@@ -0,0 +1,104 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAllEntries(t *testing.T, r *Reader) []*Entry {
+	var entries []*Entry
+	for r.Next() {
+		entries = append(entries, r.Entry())
+	}
+	assert.NoError(t, r.Err())
+	assert.NoError(t, r.Close())
+	return entries
+}
+
+// ローテーションして圧縮されたファイルも含めて、出力した順に読めるか
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:    filepath.Join(dir, "app.log"),
+		LoggerFlags: log.Ldate | log.Lmicroseconds | log.Lshortfile,
+		Prefix:      "app: ",
+		Rotate:      RotateConfig{MaxLine: 5, MaxRotation: 10},
+		Compress:    true,
+	}
+	l := newFileLogger(conf)
+	for i := 0; i < 12; i++ {
+		l.write(newEntry(1, INFO, "message "+strconv.Itoa(i), Fields{"i": i, "user": "alice bob"}))
+		// ローテーションした時刻で並べるので、同じ時刻にならないようにする
+		time.Sleep(2 * time.Millisecond)
+	}
+	l.write(newEntry(1, ERROR, "multi\nline\tmessage", nil))
+	l.write(newEntry(1, WARN, "", nil))
+	assert.NoError(t, l.close())
+
+	files := LogFiles(conf.FilePath)
+	assert.Len(t, files, 3)
+	assert.Equal(t, conf.FilePath, files[2])
+
+	r, err := NewReader(conf)
+	assert.NoError(t, err)
+	entries := readAllEntries(t, r)
+	assert.Len(t, entries, 14)
+	for i := 0; i < 12; i++ {
+		e := entries[i]
+		assert.Equal(t, INFO, e.Level)
+		assert.Equal(t, "message "+strconv.Itoa(i), e.Message)
+		assert.Equal(t, Fields{"i": strconv.Itoa(i), "user": "alice bob"}, e.Fields)
+		assert.Regexp(t, `^reader_test\.go:\d+$`, e.Caller)
+		assert.WithinDuration(t, time.Now(), e.Time, time.Minute)
+	}
+	assert.Equal(t, "multi\nline\tmessage", entries[12].Message)
+	assert.Nil(t, entries[12].Fields)
+	assert.Equal(t, WARN, entries[13].Level)
+	assert.Equal(t, "", entries[13].Message)
+}
+
+func TestReaderDefaultFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plain.log")
+	content := "garbage before header\n" +
+		"2019/12/03 08:25:15 [INFO] started\tport=8080\n" +
+		"2019/12/03 08:25:16 [DEBUG] x\ty\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	r, err := NewPathReader(path)
+	assert.NoError(t, err)
+	entries := readAllEntries(t, r)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "garbage before header", entries[0].Message)
+	assert.Equal(t, time.Date(2019, 12, 3, 8, 25, 15, 0, time.Local), entries[1].Time)
+	assert.Equal(t, Fields{"port": "8080"}, entries[1].Fields)
+	assert.Equal(t, "x\ty", entries[2].Message)
+
+	_, err = NewReader(&Config{})
+	assert.Error(t, err)
+}
+
+func TestParseFields(t *testing.T) {
+	fields := Fields{"a": "1", "quoted": "x \"y\"\tz", "empty": "", "eq": "k=v", "key with space": "1", "k=v": "2", `"`: "3"}
+	got, ok := parseFields(formatFields(fields))
+	assert.True(t, ok)
+	assert.Equal(t, fields, got)
+
+	for _, s := range []string{"", "novalue", "=v", `a="unterminated`, `a="x"b=1`, "a b=1"} {
+		_, ok := parseFields(s)
+		assert.False(t, ok, s)
+	}
+}
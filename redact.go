@@ -0,0 +1,287 @@
+package filelogger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RedactStrategy 機密情報の置き換え方
+type RedactStrategy int
+
+// 機密情報の置き換え方
+const (
+	RedactMask RedactStrategy = iota // Maskの文字列に置き換える
+	RedactHash                       // Saltを鍵にしたHMAC-SHA256の先頭16桁に置き換える。元の値は分からないが同じ値かどうかは比べられる
+	RedactDrop                       // 取り除く。Fieldsの場合は項目ごと取り除く
+)
+
+// defaultRedactMask RedactConfig.Maskが空の場合に使う文字列
+const defaultRedactMask = "[REDACTED]"
+
+var redactStrategyNames = map[RedactStrategy]string{
+	RedactMask: "mask",
+	RedactHash: "hash",
+	RedactDrop: "drop",
+}
+
+func (s RedactStrategy) String() string {
+	if name, ok := redactStrategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("RedactStrategy(%d)", int(s))
+}
+
+// ParseRedactStrategy "mask"、"hash"、"drop"をRedactStrategyにする
+func ParseRedactStrategy(s string) (RedactStrategy, error) {
+	for strategy, name := range redactStrategyNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return strategy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid redact strategy %q", s)
+}
+
+// RedactRule メッセージとFieldsの値から機密情報を探す正規表現。
+// Patternにグループがある場合は、一致したグループのうち最後のものだけを置き換える
+type RedactRule struct {
+	Name     string
+	Pattern  string
+	Strategy RedactStrategy
+	Validate func(match string) bool // 設定されていれば、trueを返した一致だけを置き換える
+}
+
+// 組み込みのルール
+var (
+	RedactCreditCard  = RedactRule{Name: "credit_card", Pattern: `\b\d(?:[ -]?\d){12,18}\b`, Validate: luhnValid}
+	RedactEmail       = RedactRule{Name: "email", Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`}
+	RedactBearerToken = RedactRule{Name: "bearer_token", Pattern: `(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`}
+)
+
+// builtinRedactRules 設定ファイルと環境変数で名前だけで指定できるルール
+var builtinRedactRules = []RedactRule{RedactCreditCard, RedactEmail, RedactBearerToken}
+
+// lookupRedactRule 組み込みのルールを名前で探す
+func lookupRedactRule(name string) (RedactRule, bool) {
+	for _, rule := range builtinRedactRules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return RedactRule{}, false
+}
+
+// RedactConfig 出力前に機密情報を置き換える設定。RulesとFieldsのどちらも空なら何もしない
+type RedactConfig struct {
+	Rules    []RedactRule
+	Fields   []string       // 値を置き換えるFieldsの名前。大文字小文字は区別せず、メッセージ中の"name=value"や"name: value"の値も置き換える
+	Strategy RedactStrategy // Fieldsの値の置き換え方
+	Salt     string         // RedactHashで使う鍵
+	Mask     string         // RedactMaskで置き換える文字列。空の場合は"[REDACTED]"
+}
+
+func (c RedactConfig) enabled() bool {
+	return len(c.Rules) > 0 || len(c.Fields) > 0
+}
+
+// Redactor RedactConfigをコンパイルしたもの。nilの場合は何も置き換えない
+type Redactor struct {
+	rules    []redactRule
+	fields   map[string]bool
+	strategy RedactStrategy
+	salt     []byte
+	mask     string
+	failed   bool // 設定に誤りがある場合。機密情報を残さないようにメッセージとFieldsをすべて置き換える
+}
+
+type redactRule struct {
+	re       *regexp.Regexp
+	strategy RedactStrategy
+	validate func(string) bool
+}
+
+// NewRedactor confの正規表現をコンパイルしてRedactorを作成する。confが空の場合はnilを返す
+func NewRedactor(conf RedactConfig) (*Redactor, error) {
+	if !conf.enabled() {
+		return nil, nil
+	}
+	r := &Redactor{
+		fields:   map[string]bool{},
+		strategy: conf.Strategy,
+		salt:     []byte(conf.Salt),
+		mask:     conf.Mask,
+	}
+	if r.mask == "" {
+		r.mask = defaultRedactMask
+	}
+
+	if len(conf.Fields) > 0 {
+		names := make([]string, len(conf.Fields))
+		for i, name := range conf.Fields {
+			r.fields[strings.ToLower(name)] = true
+			names[i] = regexp.QuoteMeta(name)
+		}
+		// "password=secret"、"password: secret"、`"password":"secret"`、"Authorization: Bearer token"、
+		// "Authorization:[Bearer token]"の値
+		re := regexp.MustCompile(`(?i)(["']?\b(?:` + strings.Join(names, "|") + `)\b["']?\s*[:=]\s*)` +
+			`(?:"([^"]*)"|'([^']*)'|\[([^\]]*)\]|((?:(?:basic|bearer|digest|token)\s+)?[^\s,;&"'}\]]+))`)
+		r.rules = append(r.rules, redactRule{re: re, strategy: conf.Strategy})
+	}
+	for i, rule := range conf.Rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redact rule %d (%s): %w", i, rule.Name, err)
+		}
+		r.rules = append(r.rules, redactRule{re: re, strategy: rule.Strategy, validate: rule.Validate})
+	}
+	return r, nil
+}
+
+// Redact 置き換えたEntryを返す。eとFieldsは変更しない
+func (r *Redactor) Redact(e *Entry) *Entry {
+	if r == nil {
+		return e
+	}
+	redacted := *e
+	if r.failed {
+		redacted.Message = r.mask
+		redacted.Fields = nil
+		return &redacted
+	}
+	redacted.Message = r.String(e.Message)
+	if len(e.Fields) > 0 {
+		redacted.Fields = Fields(r.redactMap(e.Fields))
+	}
+	return &redacted
+}
+
+// String sに含まれる機密情報を置き換える
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	if r.failed {
+		return r.mask
+	}
+	for _, rule := range r.rules {
+		s = r.replace(rule, s)
+	}
+	return s
+}
+
+// replace ruleに一致した部分をruleの置き換え方で置き換える
+func (r *Redactor) replace(rule redactRule, s string) string {
+	matches := rule.re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	b := &strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		for i := len(m)/2 - 1; i > 0; i-- {
+			if m[2*i] >= 0 {
+				start, end = m[2*i], m[2*i+1]
+				break
+			}
+		}
+		if rule.validate != nil && !rule.validate(s[start:end]) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(r.apply(rule.strategy, s[start:end]))
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// apply 一つの値を置き換え方に従って置き換える
+func (r *Redactor) apply(strategy RedactStrategy, value string) string {
+	switch strategy {
+	case RedactHash:
+		mac := hmac.New(sha256.New, r.salt)
+		mac.Write([]byte(value))
+		return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	case RedactDrop:
+		return ""
+	default:
+		return r.mask
+	}
+}
+
+// redactMap Fieldsの名前と値を見て置き換えたコピーを返す。入れ子のマップも同じように置き換える
+func (r *Redactor) redactMap(m map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if r.fields[strings.ToLower(k)] {
+			if r.strategy == RedactDrop {
+				continue
+			}
+			redacted[k] = r.apply(r.strategy, fmt.Sprint(v))
+			continue
+		}
+		redacted[k] = r.redactValue(v)
+	}
+	return redacted
+}
+
+// redactValue 文字列以外の値は文字列にしたときに置き換えが必要な場合だけ文字列に置き換える
+func (r *Redactor) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.String(v)
+	case Fields:
+		return Fields(r.redactMap(v))
+	case map[string]interface{}:
+		return r.redactMap(v)
+	}
+	s := fmt.Sprint(v)
+	if redacted := r.String(s); redacted != s {
+		return redacted
+	}
+	return v
+}
+
+// luhnValid 数字の並びがLuhnのチェックディジットを満たすか。空白とハイフンは無視する
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// newLoggerRedactor confのRedactからRedactorを作成する。設定に誤りがあれば、
+// 機密情報がそのまま出力されないように、メッセージとFieldsをすべて置き換えるRedactorを返す
+func newLoggerRedactor(conf *Config) *Redactor {
+	r, err := NewRedactor(conf.Redact)
+	if err != nil {
+		logPrintln(err.Error())
+		mask := conf.Redact.Mask
+		if mask == "" {
+			mask = defaultRedactMask
+		}
+		return &Redactor{failed: true, mask: mask}
+	}
+	return r
+}
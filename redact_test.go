@@ -0,0 +1,140 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := NewRedactor(RedactConfig{
+		Rules:  []RedactRule{RedactCreditCard, RedactEmail, RedactBearerToken},
+		Fields: []string{"password", "Authorization"},
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		in     string
+		expect string
+	}{
+		{"card 4111 1111 1111 1111 charged", "card [REDACTED] charged"},
+		{"order 4111111111111112 is not a card", "order 4111111111111112 is not a card"},
+		{"mail to alice@example.com", "mail to [REDACTED]"},
+		{"Authorization: Bearer abc.def-123", "Authorization: [REDACTED]"},
+		{"header bearer abc.def-123", "header bearer [REDACTED]"},
+		{"login user=alice password=hunter2 ok", "login user=alice password=[REDACTED] ok"},
+		{`{"user":"alice","PASSWORD":"hunter2"}`, `{"user":"alice","PASSWORD":"[REDACTED]"}`},
+		{"&{Method:GET Header:map[Authorization:[Basic YWxhZGRpbg==]]}", "&{Method:GET Header:map[Authorization:[[REDACTED]]]}"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expect, r.String(tt.in), tt.in)
+	}
+
+	// Fieldsは名前と値を見て置き換え、元のEntryは変更しない
+	fields := Fields{
+		"password": "hunter2",
+		"user":     "alice@example.com",
+		"count":    3,
+		"request":  map[string]interface{}{"authorization": "Basic x", "path": "/"},
+	}
+	e := &Entry{Level: INFO, Message: "token Bearer abc", Fields: fields}
+	redacted := r.Redact(e)
+	assert.Equal(t, "token Bearer [REDACTED]", redacted.Message)
+	assert.Equal(t, Fields{
+		"password": "[REDACTED]",
+		"user":     "[REDACTED]",
+		"count":    3,
+		"request":  map[string]interface{}{"authorization": "[REDACTED]", "path": "/"},
+	}, redacted.Fields)
+	assert.Equal(t, "token Bearer abc", e.Message)
+	assert.Equal(t, "hunter2", fields["password"])
+
+	var nilRedactor *Redactor
+	assert.Equal(t, e, nilRedactor.Redact(e))
+	r, err = NewRedactor(RedactConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	_, err = NewRedactor(RedactConfig{Rules: []RedactRule{{Name: "bad", Pattern: "("}}})
+	assert.Error(t, err)
+}
+
+func TestRedactStrategies(t *testing.T) {
+	r, err := NewRedactor(RedactConfig{
+		Rules:    []RedactRule{{Name: "id", Pattern: `user-\d+`, Strategy: RedactHash}},
+		Fields:   []string{"secret"},
+		Strategy: RedactDrop,
+		Salt:     "pepper",
+		Mask:     "***",
+	})
+	assert.NoError(t, err)
+
+	// 同じ値は同じハッシュになり、saltが違えば別のハッシュになる
+	a := r.String("by user-42")
+	assert.Regexp(t, `^by hash:[0-9a-f]{16}$`, a)
+	assert.Equal(t, a, r.String("by user-42"))
+	assert.NotEqual(t, a, r.String("by user-43"))
+	other, _ := NewRedactor(RedactConfig{Rules: []RedactRule{{Pattern: `user-\d+`, Strategy: RedactHash}}, Salt: "salt"})
+	assert.NotEqual(t, a, other.String("by user-42"))
+
+	e := r.Redact(&Entry{Message: "secret=abc done", Fields: Fields{"secret": "abc", "k": "v"}})
+	assert.Equal(t, "secret= done", e.Message)
+	assert.Equal(t, Fields{"k": "v"}, e.Fields)
+
+	s, err := ParseRedactStrategy(" Hash ")
+	assert.NoError(t, err)
+	assert.Equal(t, RedactHash, s)
+	_, err = ParseRedactStrategy("shred")
+	assert.Error(t, err)
+}
+
+// Loggerに設定すると、ファイルにもSinkにも置き換えてから出力するか
+func TestRedactLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &memorySink{}
+	conf := &Config{
+		FilePath:    filepath.Join(dir, "app.log"),
+		LoggerFlags: 0,
+		Sinks:       []Sink{sink},
+		Redact: RedactConfig{
+			Rules:  []RedactRule{RedactBearerToken},
+			Fields: []string{"password"},
+		},
+	}
+	l := newFileLogger(conf)
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	l.write(newEntry(1, INFO, "request "+strings.Join(req.Header["Authorization"], ""), Fields{"password": "hunter2"}))
+
+	b, err := ioutil.ReadFile(conf.FilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "[INFO] request Bearer [REDACTED]\tpassword=[REDACTED]\n", string(b))
+	assert.Equal(t, "request Bearer [REDACTED]", sink.Entries()[0].Message)
+
+	// panicの値も置き換える
+	assert.PanicsWithValue(t, "token Bearer [REDACTED]", func() {
+		l.panic("token Bearer s3cr3t")
+	})
+
+	// 設定に誤りがあれば、すべて置き換えて出力する
+	conf.Redact.Rules = append(conf.Redact.Rules, RedactRule{Pattern: "["})
+	l = newFileLogger(conf)
+	l.write(newEntry(1, INFO, "Bearer s3cr3t", Fields{"k": "v"}))
+	b, err = ioutil.ReadFile(conf.FilePath)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(b), "[INFO] [REDACTED]\n"), string(b))
+
+	assert.Error(t, conf.Validate())
+	conf.Redact = RedactConfig{Rules: []RedactRule{{Pattern: "x", Strategy: RedactHash}}}
+	assert.Error(t, conf.Validate())
+	conf.Redact.Salt = "pepper"
+	assert.NoError(t, conf.Validate())
+}
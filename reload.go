@@ -0,0 +1,200 @@
+package filelogger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Reload 実行中のLoggerにconfを反映する。詳しくはfileLogger.reloadを参照
+func Reload(conf *Config) error {
+	return Logger.reload(conf)
+}
+
+// reload Mode、LogLevelConf、Rotate、Prefix、LoggerFlagsなどをまとめて差し替える。
+// 書き込みと同じロックの中で差し替えるので、途中の書き込みが古い設定と新しい設定で混ざることはない。
+// FilePathが変わった場合は、今のファイルをローテーションと同じ名前に変更してから新しいpathに移る
+func (l *fileLogger) reload(conf *Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	conf = addMissingConfParts(conf)
+
+	l.Mutex.Lock()
+	unlock, err := l.lockFile()
+	if err != nil {
+		l.Mutex.Unlock()
+		return err
+	}
+	prevFileName, err := l.switchPath(conf.FilePath)
+	unlock()
+	if err != nil {
+		l.Mutex.Unlock()
+		return err
+	}
+	archive := l.archiveOptions()
+	compress := prevFileName != "" && archive.enabled()
+	locker := l.fileLocker()
+
+	l.file.perm = conf.FilePerm
+	l.file.flag = conf.FileFlags
+	l.Logger.SetPrefix(conf.Prefix)
+	l.Conf = conf
+	l.redactor = newLoggerRedactor(conf)
+
+	if compress {
+		l.compressing.Add(1)
+	}
+	l.Mutex.Unlock()
+
+	if compress {
+		if err = l.archiveFile(prevFileName, archive, locker); err != nil {
+			logPrintln(err.Error())
+		}
+		l.compressing.Done()
+	}
+	return nil
+}
+
+// switchPath pathが今のファイルと違えば、今のファイルに日時を付与した名前に変更して出力先をpathにする。
+// 変更後のファイル名を返す。ファイルがまだない場合や変更の必要がない場合は空文字を返す
+func (l *fileLogger) switchPath(path string) (string, error) {
+	if path == l.file.fm.path {
+		return "", nil
+	}
+	// Sinksにだけ出力していた場合は変更するファイルがない
+	if l.file.fm.path == "" {
+		l.file.fm = newFileNameManager(path)
+		return "", nil
+	}
+
+	size, err := l.syncIndex()
+	if err != nil {
+		logPrintln(err.Error())
+	}
+	fileName := filepath.Join(l.file.fm.dir, l.file.fm.getNameAddTimeNow())
+	err = os.Rename(l.file.fm.path, fileName)
+	if os.IsNotExist(err) {
+		fileName, err = "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if fileName != "" {
+		if err = l.rotateIndex(fileName, size); err != nil {
+			logPrintln(err.Error())
+		}
+		// 変更前のファイル名でもローテーションしたファイルが増えるので、ローテーションと同じように古いものを削除する
+		if err = l.applyRetention(); err != nil {
+			logPrintln(err.Error())
+		}
+	}
+
+	l.file.fm = newFileNameManager(path)
+	return fileName, nil
+}
+
+// ConfigWatcher 設定ファイルを定期的に読み込み、内容が変わっていればLoggerに反映する
+type ConfigWatcher struct {
+	path     string
+	interval time.Duration
+	logger   *fileLogger
+	last     []byte // 最後に反映した内容
+	lastErr  string // 最後にonErrorに渡したエラー。同じエラーが続く間は繰り返し呼ばない
+	onError  func(error)
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// WatchConfig pathのJSON設定ファイル(LoadConfigJSONの形式)をinterval毎に確認し、変更があればLoggerに反映する。
+// 読み込みやValidateに失敗した場合は今の設定のままにしてonErrorを呼び、内容が変わらなくても次の確認で再び反映を試みる。
+// ディレクトリの作成などで反映できるようになる場合があるため。onErrorがnilならエラーを出力する。
+// 設定ファイルにないFileFlagsとSinksは今の設定を引き継ぐ
+func WatchConfig(path string, interval time.Duration, onError func(error)) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("filelogger: watch interval must be positive, got %v", interval)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if onError == nil {
+		onError = func(err error) {
+			logPrintln("reload " + path + ": " + err.Error())
+		}
+	}
+	w := &ConfigWatcher{
+		path:     path,
+		interval: interval,
+		logger:   Logger,
+		last:     b,
+		onError:  onError,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Stop 設定ファイルの確認をやめる
+func (w *ConfigWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *ConfigWatcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			err := w.check()
+			if err == nil {
+				w.lastErr = ""
+			} else if err.Error() != w.lastErr {
+				w.lastErr = err.Error()
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// check 設定ファイルの内容が最後に反映したものと変わっていれば読み込んで反映する。
+// ConfigMapのようにシンボリックリンクの差し替えで更新されることもあるので、更新日時ではなく内容で比較する
+func (w *ConfigWatcher) check() error {
+	b, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(b, w.last) {
+		return nil
+	}
+
+	conf, err := parseConfigJSON(b)
+	if err != nil {
+		return err
+	}
+
+	w.logger.Mutex.Lock()
+	conf.FileFlags = w.logger.Conf.FileFlags
+	conf.Sinks = w.logger.Conf.Sinks
+	w.logger.Mutex.Unlock()
+
+	if err = w.logger.reload(conf); err != nil {
+		return err
+	}
+	w.last = b
+	return nil
+}
@@ -0,0 +1,171 @@
+package filelogger
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ディレクトリ内の全ファイルの行をまとめて返す
+func readAllLines(t *testing.T, dir string) []string {
+	fi, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	var lines []string
+	for _, f := range fi {
+		if f.IsDir() {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, f.Name()))
+		assert.NoError(t, err)
+		s := bufio.NewScanner(file)
+		for s.Scan() {
+			lines = append(lines, s.Text())
+		}
+		file.Close()
+	}
+	return lines
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldPath := filepath.Join(dir, "old.log")
+	newPath := filepath.Join(dir, "new.log")
+	logger := newFileLogger(&Config{
+		Mode:        ModeProduction,
+		LoggerFlags: LoggerFlags,
+		FilePath:    oldPath,
+		LogLevelConf: LogLevelConfig{
+			{Mode: ModeProduction, ExcludedLevel: []string{DEBUG}},
+			{Mode: ModeDebug},
+		},
+	})
+	logger.printLevel(DEBUG, "hidden")
+	logger.printLevel(INFO, "before reload")
+
+	// 書き込み中に再読み込みしても、すべての行がどちらかのファイルに一行ずつ書かれているか
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			logger.printLevel(INFO, fmt.Sprintf("concurrent %d", i))
+			wg.Done()
+		}(i)
+	}
+	err = logger.reload(&Config{
+		Mode:        ModeDebug,
+		LoggerFlags: 0,
+		Prefix:      "reloaded ",
+		FilePath:    newPath,
+		LogLevelConf: LogLevelConfig{
+			{Mode: ModeProduction, ExcludedLevel: []string{DEBUG}},
+			{Mode: ModeDebug},
+		},
+	})
+	assert.NoError(t, err)
+	wg.Wait()
+	logger.printLevel(DEBUG, "visible")
+
+	b, err := ioutil.ReadFile(newPath)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(b), "reloaded [DEBUG] visible\n"))
+
+	_, err = os.Stat(oldPath)
+	assert.True(t, os.IsNotExist(err))
+
+	lines := readAllLines(t, dir)
+	assert.Len(t, lines, 102)
+	for _, line := range lines {
+		assert.False(t, strings.Contains(line, "hidden"))
+		assert.Equal(t, 1, strings.Count(line, "["), line)
+	}
+
+	// 不正な設定は反映しない
+	err = logger.reload(&Config{FilePath: newPath, Rotate: RotateConfig{MaxLine: -1}})
+	assert.Error(t, err)
+	assert.Equal(t, ModeDebug, logger.Conf.Mode)
+
+	// パスを変更した場合も、変更前の名前でローテーションしたファイルにMaxRotationを適用する
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, logger.reload(&Config{FilePath: oldPath, Rotate: RotateConfig{MaxRotation: 2}}))
+		logger.printLevel(INFO, "switch")
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, logger.reload(&Config{FilePath: newPath, Rotate: RotateConfig{MaxRotation: 2}}))
+	}
+	assert.Len(t, LogFiles(oldPath), 2)
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "watch.log")
+	confPath := filepath.Join(dir, "config.json")
+	writeConf := func(mode string) {
+		content := fmt.Sprintf(`{
+			"mode": %q,
+			"file_path": %q,
+			"log_level_conf": [
+				{"mode": "ProductionMode", "excluded_level": ["DEBUG"]},
+				{"mode": "DebugMode"}
+			]
+		}`, mode, logPath)
+		assert.NoError(t, ioutil.WriteFile(confPath, []byte(content), 0644))
+	}
+	writeConf(ModeProduction)
+
+	conf, err := LoadConfigJSON(confPath)
+	assert.NoError(t, err)
+	orig := Logger
+	defer func() { Logger = orig }()
+	Initialize(conf)
+	logger := Logger
+
+	errs := make(chan error, 10)
+	w, err := WatchConfig(confPath, 10*time.Millisecond, func(err error) { errs <- err })
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	assert.True(t, logger.excluded(DEBUG))
+	writeConf(ModeDebug)
+	assert.Eventually(t, func() bool { return !logger.excluded(DEBUG) }, time.Second, 10*time.Millisecond)
+
+	// 壊れた設定ファイルはエラーになり、設定は変わらない
+	assert.NoError(t, ioutil.WriteFile(confPath, []byte(`{"mode": `), 0644))
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("expected reload error")
+	}
+	assert.False(t, logger.excluded(DEBUG))
+
+	// ディレクトリがないために反映できなかった設定は、内容が変わらなくてもディレクトリができれば反映する
+	logPath = filepath.Join(dir, "later", "watch.log")
+	writeConf(ModeProduction)
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "does not exist")
+	case <-time.After(time.Second):
+		t.Fatal("expected reload error")
+	}
+	assert.False(t, logger.excluded(DEBUG))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "later"), 0755))
+	assert.Eventually(t, func() bool { return logger.excluded(DEBUG) }, time.Second, 10*time.Millisecond)
+	assert.Empty(t, errs)
+
+	_, err = WatchConfig(confPath, 0, nil)
+	assert.Error(t, err)
+}
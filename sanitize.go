@@ -0,0 +1,146 @@
+package filelogger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ControlCharPolicy メッセージに含まれる改行などの制御文字の扱い
+type ControlCharPolicy int
+
+// 制御文字の扱い
+const (
+	ControlCharsAllow  ControlCharPolicy = iota // そのまま出力する。改行を含むメッセージは複数行になる
+	ControlCharsEscape                          // "\n"や"\x1b"のようにエスケープして一行にする
+	ControlCharsReject                          // 制御文字を含むEntryは出力しない
+)
+
+var controlCharPolicyNames = map[ControlCharPolicy]string{
+	ControlCharsAllow:  "allow",
+	ControlCharsEscape: "escape",
+	ControlCharsReject: "reject",
+}
+
+func (p ControlCharPolicy) String() string {
+	if name, ok := controlCharPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("ControlCharPolicy(%d)", int(p))
+}
+
+// ParseControlCharPolicy "allow"、"escape"、"reject"をControlCharPolicyにする
+func ParseControlCharPolicy(s string) (ControlCharPolicy, error) {
+	for policy, name := range controlCharPolicyNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid control character policy %q", s)
+}
+
+// SanitizeConfig 出力前にメッセージを整える設定。
+// 利用者の入力をそのまま出力すると、改行で"[ERROR]"の行を偽装したり、MaxLineの行数がずれたりするのを防ぐ
+type SanitizeConfig struct {
+	ControlChars       ControlCharPolicy // メッセージ、ログレベル、Fieldsの名前に含まれる制御文字の扱い。Fieldsの名前と値は制御文字を含めばクォートされる
+	MaxLength          int               // メッセージとFieldsの文字列の値の最大バイト数。エスケープする前の長さで数え、超えた分は切り詰めて"...[truncated N bytes]"を付ける。0の場合は制限しない
+	ReplaceInvalidUTF8 bool              // UTF-8として正しくないバイトをU+FFFDに置き換える
+}
+
+// truncateMarker MaxLengthで切り詰めたメッセージの後ろに付ける。%dは切り詰めたバイト数
+const truncateMarker = "...[truncated %d bytes]"
+
+func (c SanitizeConfig) enabled() bool {
+	return c.ControlChars != ControlCharsAllow || c.MaxLength > 0 || c.ReplaceInvalidUTF8
+}
+
+// entry 整えたEntryのコピーを返す。ControlCharsRejectで制御文字を含む場合はfalseを返す
+func (c SanitizeConfig) entry(e *Entry) (*Entry, bool) {
+	if !c.enabled() {
+		return e, true
+	}
+	sanitized := *e
+	var ok bool
+	if sanitized.Level, ok = c.text(e.Level); !ok {
+		return nil, false
+	}
+	// エスケープした後に切り詰めるとエスケープの途中で切れるので、先に切り詰める
+	if sanitized.Message, ok = c.text(c.truncate(e.Message)); !ok {
+		return nil, false
+	}
+	if len(e.Fields) > 0 {
+		sanitized.Fields = make(Fields, len(e.Fields))
+		for k, v := range e.Fields {
+			if k, ok = c.text(k); !ok {
+				return nil, false
+			}
+			if s, isString := v.(string); isString {
+				if c.ReplaceInvalidUTF8 {
+					s = strings.ToValidUTF8(s, string(utf8.RuneError))
+				}
+				v = c.truncate(s)
+			}
+			sanitized.Fields[k] = v
+		}
+	}
+	return &sanitized, true
+}
+
+// truncate MaxLengthが設定されていれば切り詰める
+func (c SanitizeConfig) truncate(s string) string {
+	if c.MaxLength > 0 {
+		return truncateMessage(s, c.MaxLength)
+	}
+	return s
+}
+
+// text 正しくないUTF-8を置き換え、制御文字をControlCharsに従って扱う
+func (c SanitizeConfig) text(s string) (string, bool) {
+	if c.ReplaceInvalidUTF8 {
+		s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+	switch c.ControlChars {
+	case ControlCharsEscape:
+		return escapeControlChars(s), true
+	case ControlCharsReject:
+		return s, strings.IndexFunc(s, isControlChar) < 0
+	}
+	return s, true
+}
+
+// isControlChar C0、DEL、C1の制御文字と、行の区切りとして扱われるU+2028、U+2029か
+func isControlChar(r rune) bool {
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f) || r == '\u2028' || r == '\u2029'
+}
+
+// escapeControlChars 制御文字をGoの文字列リテラルと同じ形式でエスケープする。正しくないUTF-8のバイトはそのまま残す
+func escapeControlChars(s string) string {
+	if strings.IndexFunc(s, isControlChar) < 0 {
+		return s
+	}
+	b := &strings.Builder{}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 || !isControlChar(r) {
+			b.WriteString(s[i : i+size])
+		} else {
+			q := strconv.QuoteRune(r)
+			b.WriteString(q[1 : len(q)-1])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// truncateMessage maxバイトを超える場合に、文字の途中で切らないように切り詰めて印を付ける
+func truncateMessage(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + fmt.Sprintf(truncateMarker, len(s)-n)
+}
@@ -0,0 +1,106 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	escape := SanitizeConfig{ControlChars: ControlCharsEscape}
+	tests := []struct {
+		in     string
+		expect string
+	}{
+		{"plain message", "plain message"},
+		{"line1\n2019/12/03 08:00:00 [ERROR] forged", `line1\n2019/12/03 08:00:00 [ERROR] forged`},
+		{"tab\tkey=value\r", `tab\tkey=value\r`},
+		{"color \x1b[31mred\x7f", `color \x1b[31mred\x7f`},
+		{"next line\u0085sep\u2028", `next line\u0085sep\u2028`},
+		{"日本語\xff", "日本語\xff"},
+	}
+	for _, tt := range tests {
+		s, ok := escape.text(tt.in)
+		assert.True(t, ok)
+		assert.Equal(t, tt.expect, s, tt.in)
+	}
+
+	utf8 := SanitizeConfig{ReplaceInvalidUTF8: true}
+	s, _ := utf8.text("bad \xff\xfe byte")
+	assert.Equal(t, "bad � byte", s)
+
+	reject := SanitizeConfig{ControlChars: ControlCharsReject}
+	_, ok := reject.text("one line")
+	assert.True(t, ok)
+	_, ok = reject.text("two\nlines")
+	assert.False(t, ok)
+	_, ok = reject.entry(&Entry{Level: INFO, Message: "ok", Fields: Fields{"k\nx": "v"}})
+	assert.False(t, ok)
+
+	// 文字の途中で切らずに切り詰める
+	assert.Equal(t, "short", truncateMessage("short", 5))
+	assert.Equal(t, "abc...[truncated 3 bytes]", truncateMessage("abcdef", 3))
+	assert.Equal(t, "あ...[truncated 6 bytes]", truncateMessage("あいう", 4))
+
+	e := &Entry{Level: INFO, Message: "a\nb", Fields: Fields{"k": "v\xff", "n": 1}}
+	sanitized, ok := SanitizeConfig{ControlChars: ControlCharsEscape, ReplaceInvalidUTF8: true}.entry(e)
+	assert.True(t, ok)
+	assert.Equal(t, `a\nb`, sanitized.Message)
+	assert.Equal(t, Fields{"k": "v�", "n": 1}, sanitized.Fields)
+	assert.Equal(t, "a\nb", e.Message)
+
+	// エスケープの途中で切らず、Fieldsの文字列の値も切り詰める
+	limited := SanitizeConfig{ControlChars: ControlCharsEscape, MaxLength: 4}
+	sanitized, ok = limited.entry(&Entry{Level: INFO, Message: "abc\ndef", Fields: Fields{"k": "123456", "n": 123456}})
+	assert.True(t, ok)
+	assert.Equal(t, `abc\n...[truncated 3 bytes]`, sanitized.Message)
+	assert.Equal(t, Fields{"k": "1234...[truncated 2 bytes]", "n": 123456}, sanitized.Fields)
+
+	p, err := ParseControlCharPolicy("Escape")
+	assert.NoError(t, err)
+	assert.Equal(t, ControlCharsEscape, p)
+	_, err = ParseControlCharPolicy("strip")
+	assert.Error(t, err)
+}
+
+// 改行で行を偽装できず、MaxLineの行数もずれないか
+func TestSanitizeLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:    filepath.Join(dir, "app.log"),
+		LoggerFlags: LoggerFlags,
+		Rotate:      RotateConfig{MaxLine: 3, MaxRotation: 5},
+		Sanitize:    SanitizeConfig{ControlChars: ControlCharsEscape, MaxLength: 64},
+	}
+	l := newFileLogger(conf)
+	l.write(newEntry(1, INFO, "user input\n2019/12/03 08:00:00 [ERROR] forged", nil))
+	l.write(newEntry(1, INFO, "second", nil))
+
+	r, err := NewReader(conf)
+	assert.NoError(t, err)
+	entries := readAllEntries(t, r)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, INFO, entries[0].Level)
+		assert.Equal(t, `user input\n2019/12/03 08:00:00 [ERROR] forged`, entries[0].Message)
+	}
+	assert.Len(t, LogFiles(conf.FilePath), 1)
+
+	conf.Sanitize = SanitizeConfig{ControlChars: ControlCharsReject, MaxLength: 10}
+	l.write(newEntry(1, ERROR, "rejected\nline", nil))
+	l.write(newEntry(1, WARN, "a long message over the limit", nil))
+	r, err = NewReader(conf)
+	assert.NoError(t, err)
+	entries = readAllEntries(t, r)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "a long mes...[truncated 19 bytes]", entries[2].Message)
+	}
+
+	conf.Sanitize.MaxLength = -1
+	assert.Error(t, conf.Validate())
+}
@@ -0,0 +1,343 @@
+package filelogger
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Server Unixドメインソケットで他のプロセスからEntryを受け取り、Configの設定でローテーションしながらファイルに出力する。
+// プロトコルは一行に一つのEntryをJSONで書いたもの
+type Server struct {
+	SocketPerm os.FileMode // ソケットファイルのパーミッション。0の場合は変更しない
+
+	logger   *fileLogger
+	path     string
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer confの設定で出力するServerを作成する。socketPathで待ち受ける
+func NewServer(conf *Config, socketPath string) *Server {
+	if conf.CreateDir {
+		if err := conf.prepareDir(); err != nil {
+			logPrintln(err.Error())
+		}
+	}
+	return &Server{
+		logger: newFileLogger(conf),
+		path:   socketPath,
+		conns:  map[net.Conn]struct{}{},
+	}
+}
+
+// ErrServerClosed Closeの後にListenAndServeが返すエラー
+var ErrServerClosed = errors.New("filelogger: server closed")
+
+// ListenAndServe ソケットで待ち受け、Closeされるまで接続を受け付ける。
+// 前回の終了時に残ったソケットファイルは、接続できなければ削除してから待ち受ける
+func (s *Server) ListenAndServe() error {
+	removeStaleSocket(s.path)
+	ln, err := net.Listen("unix", s.path)
+	if err != nil {
+		return err
+	}
+	if s.SocketPerm != 0 {
+		if err = os.Chmod(s.path, s.SocketPerm); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		// Closeがconnsに期限を設定してwgを待ち始めた後に加えないよう、ロック中にもう一度確かめる
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+// serve 接続が切れるまでEntryを読み込んで出力する
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	dec := json.NewDecoder(conn)
+	for {
+		e := &Entry{}
+		if err := dec.Decode(e); err != nil {
+			if err != io.EOF && !isTimeout(err) {
+				logPrintln("server: " + err.Error())
+			}
+			return
+		}
+		if e.Time.IsZero() {
+			e.Time = time.Now()
+		}
+		s.logger.write(e)
+	}
+}
+
+// serverCloseGrace Closeのあと、接続中のクライアントから届いているEntryを読み込む時間
+const serverCloseGrace = 100 * time.Millisecond
+
+// Close 待ち受けをやめ、接続中のクライアントから届いているEntryを出力してからファイルとSinkを閉じる
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now().Add(serverCloseGrace))
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	if e := s.logger.close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// removeStaleSocket 接続できないソケットファイルが残っていれば削除する
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// daemonSink用の設定値
+const (
+	daemonDialTimeout   = time.Second
+	daemonWriteTimeout  = time.Second
+	daemonRetryInterval = time.Second
+)
+
+// DaemonSink Serverにログを送るSink。Writeはバッファに追加するだけで、接続と送信はバックグラウンドのgoroutineで行うので、
+// Serverが止まっていても書き込み側は待たされない。接続が切れた場合は再接続し、接続できない間はbufferSize件までメモリに保持する。
+// 保持しきれない場合は古いものから捨てる
+type DaemonSink struct {
+	path       string
+	bufferSize int
+
+	mu      sync.Mutex
+	buf     []*Entry
+	dropped uint64
+	closed  bool
+
+	kick    chan struct{}
+	flushCh chan chan error
+	done    chan struct{}
+	stopped chan struct{}
+
+	// runのgoroutineだけが使う
+	conn     net.Conn
+	enc      *json.Encoder
+	lastDial time.Time
+}
+
+// NewDaemonSink socketPathで待ち受けているServerに送るSinkを作成し、送信用のgoroutineを開始する。接続は最初の送信時に行う
+func NewDaemonSink(socketPath string, bufferSize int) *DaemonSink {
+	d := &DaemonSink{
+		path:       socketPath,
+		bufferSize: bufferSize,
+		kick:       make(chan struct{}, 1),
+		flushCh:    make(chan chan error),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Write Entryをバッファに追加して送信用のgoroutineに知らせる。bufferSizeを超えた場合は古いものから捨てる
+func (d *DaemonSink) Write(e *Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return errBatcherClosed
+	}
+
+	d.buf = append(d.buf, e)
+	d.trim()
+	select {
+	case d.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// trim bufferSizeを超えた分を古いものから捨てる。muを取ってから呼ぶ
+func (d *DaemonSink) trim() {
+	if d.bufferSize > 0 && len(d.buf) > d.bufferSize {
+		n := len(d.buf) - d.bufferSize
+		d.buf = append([]*Entry(nil), d.buf[n:]...)
+		d.dropped += uint64(n)
+	}
+}
+
+// Flush バッファに保持しているEntryを送り終わるまで待つ。接続できなかった場合はエラーを返す
+func (d *DaemonSink) Flush() error {
+	ch := make(chan error, 1)
+	select {
+	case d.flushCh <- ch:
+		return <-ch
+	case <-d.stopped:
+		return nil
+	}
+}
+
+// Close バッファに保持しているEntryを送ってから送信用のgoroutineを止め、接続を閉じる
+func (d *DaemonSink) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.mu.Unlock()
+
+	err := d.Flush()
+	close(d.done)
+	<-d.stopped
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+	return err
+}
+
+// Dropped バッファがあふれて捨てたEntryの数を返す
+func (d *DaemonSink) Dropped() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dropped
+}
+
+func (d *DaemonSink) run() {
+	defer close(d.stopped)
+	ticker := time.NewTicker(daemonRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.kick:
+			d.flush(false)
+		case <-ticker.C:
+			d.flush(false)
+		case ch := <-d.flushCh:
+			ch <- d.flush(true)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// flush 接続していなければ接続し、バッファのEntryを古い順に送る。送れなかったものはバッファの先頭に戻す。
+// Serverが止まっている間に書き込みのたびに接続を試みないよう、forceでなければdaemonRetryIntervalの間は再接続しない。
+// Serverが再起動して古い接続が切れていた場合は、一度だけ接続し直して送る
+func (d *DaemonSink) flush(force bool) error {
+	d.mu.Lock()
+	batch := d.buf
+	d.buf = nil
+	d.mu.Unlock()
+
+	var err error
+	for retry := 0; retry < 2 && len(batch) > 0; retry++ {
+		if d.conn == nil {
+			if !force && retry == 0 && time.Since(d.lastDial) < daemonRetryInterval {
+				err = nil
+				break
+			}
+			if err = d.dial(); err != nil {
+				if !force {
+					err = nil
+				}
+				break
+			}
+		}
+
+		for len(batch) > 0 {
+			d.conn.SetWriteDeadline(time.Now().Add(daemonWriteTimeout))
+			if err = d.enc.Encode(batch[0]); err != nil {
+				d.conn.Close()
+				d.conn = nil
+				break
+			}
+			batch[0] = nil
+			batch = batch[1:]
+		}
+	}
+
+	if len(batch) > 0 {
+		d.mu.Lock()
+		d.buf = append(batch, d.buf...)
+		d.trim()
+		d.mu.Unlock()
+	}
+	return err
+}
+
+func (d *DaemonSink) dial() error {
+	d.lastDial = time.Now()
+	conn, err := net.DialTimeout("unix", d.path, daemonDialTimeout)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	d.enc = json.NewEncoder(conn)
+	return nil
+}
@@ -0,0 +1,154 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startServer ソケットで待ち受けるServerを起動し、接続できるようになるまで待つ
+func startServer(t *testing.T, conf *Config, socketPath string) (*Server, chan error) {
+	s := NewServer(conf, socketPath)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.ListenAndServe()
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+	return s, errc
+}
+
+// waitLines ファイルの行数がnになるまで待つ。Serverが受け取る前に閉じないように使う
+func waitLines(t *testing.T, path string, n int) {
+	assert.Eventually(t, func() bool {
+		b, err := ioutil.ReadFile(path)
+		return err == nil && strings.Count(string(b), "\n") == n
+	}, time.Second, 5*time.Millisecond)
+}
+
+// 他のプロセスのloggerから送ったログが、Serverの設定でローテーションされながらファイルに出力されるか
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "log.sock")
+	logPath := filepath.Join(dir, "logs", "server.log")
+	s, errc := startServer(t, &Config{
+		Rotate:      RotateConfig{MaxLine: 10, MaxRotation: 3},
+		LoggerFlags: LoggerFlags | log.Lshortfile,
+		FilePath:    logPath,
+		CreateDir:   true,
+		Compress:    true,
+	}, socketPath)
+
+	sink := NewDaemonSink(socketPath, 100)
+	client := newFileLogger(&Config{Sinks: []Sink{sink}})
+	for i := 0; i < 25; i++ {
+		client.printLevel(INFO, "from client")
+	}
+	assert.NoError(t, client.close())
+	waitLines(t, logPath, 5)
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ErrServerClosed, <-errc)
+
+	fileList := logFileList(filepath.Dir(logPath), "server.log")
+	assert.Len(t, fileList, 3)
+
+	b, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 5)
+	// 呼び出し元はクライアント側で取得したもの
+	assert.Contains(t, lines[0], "server_test.go:")
+	assert.Contains(t, lines[0], "[INFO] from client")
+}
+
+// Serverが起動していない間はバッファに保持し、起動したら順番通りに送るか
+func TestDaemonSinkReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "log.sock")
+	logPath := filepath.Join(dir, "server.log")
+
+	sink := NewDaemonSink(socketPath, 3)
+	client := newFileLogger(&Config{Sinks: []Sink{sink}})
+	for _, msg := range []string{"dropped", "first", "second", "third"} {
+		client.printLevel(INFO, msg)
+	}
+	// Serverが起動していないので送れず、bufferSizeを超えた分を捨てる
+	assert.Error(t, sink.Flush())
+	assert.Equal(t, uint64(1), sink.Dropped())
+
+	// 再接続は一定時間ごとなので、Flushで保持しているものを送る
+	s, _ := startServer(t, &Config{FilePath: logPath}, socketPath)
+	assert.NoError(t, sink.Flush())
+	client.printLevel(INFO, "fourth")
+	waitLines(t, logPath, 4)
+
+	// Serverを再起動しても送り続けられるか
+	assert.NoError(t, s.Close())
+	s, _ = startServer(t, &Config{FilePath: logPath}, socketPath)
+	client.printLevel(INFO, "fifth")
+	waitLines(t, logPath, 5)
+	assert.NoError(t, client.close())
+	assert.NoError(t, s.Close())
+
+	b, err := ioutil.ReadFile(logPath)
+	assert.NoError(t, err)
+	var msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		msgs = append(msgs, line[strings.Index(line, "] ")+2:])
+	}
+	assert.Equal(t, []string{"first", "second", "third", "fourth", "fifth"}, msgs)
+}
+
+// Serverが受け取らずに止まっていても、書き込み側は送信を待たされないか
+func TestDaemonSinkStalled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 接続は受け付けるが読まない
+	socketPath := filepath.Join(dir, "log.sock")
+	ln, err := net.Listen("unix", socketPath)
+	assert.NoError(t, err)
+	conns := make(chan net.Conn, 100)
+	go func() {
+		defer close(conns)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	sink := NewDaemonSink(socketPath, 10)
+	client := newFileLogger(&Config{Sinks: []Sink{sink}})
+	msg := strings.Repeat("x", 64<<10)
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		client.printLevel(INFO, msg)
+	}
+	assert.True(t, time.Since(start) < daemonWriteTimeout/2, "writes took %v", time.Since(start))
+
+	ln.Close()
+	for conn := range conns {
+		conn.Close()
+	}
+	client.close()
+}
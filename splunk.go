@@ -0,0 +1,99 @@
+package filelogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// SplunkConfig SplunkSinkの設定
+type SplunkConfig struct {
+	URL        string // HTTP Event CollectorのURL。例: https://splunk:8088/services/collector/event
+	Token      string // HECのトークン
+	Host       string // 空の場合はos.Hostname
+	Source     string
+	SourceType string
+	Index      string // 空の場合はトークンに設定されたインデックス
+	Headers    map[string]string
+	Client     *http.Client // nilの場合はタイムアウトが10秒のhttp.Client
+	Batch      BatchConfig
+}
+
+// SplunkSink SplunkのHTTP Event Collectorに送るSink。eventはentryRecordで作ったマップ
+type SplunkSink struct {
+	*batcher
+	conf SplunkConfig
+}
+
+// NewSplunkSink conf.URLに送るSplunkSinkを作成する
+func NewSplunkSink(conf SplunkConfig) (*SplunkSink, error) {
+	if conf.URL == "" {
+		return nil, errors.New("filelogger: Splunk sink URL is empty")
+	}
+	if conf.Host == "" {
+		conf.Host, _ = os.Hostname()
+	}
+	if conf.Client == nil {
+		conf.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+
+	s := &SplunkSink{conf: conf}
+	b, err := newBatcher(conf.Batch, "splunk.spool", s.send)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = b
+	return s, nil
+}
+
+type splunkEvent struct {
+	Time       float64                `json:"time"`
+	Host       string                 `json:"host,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	SourceType string                 `json:"sourcetype,omitempty"`
+	Index      string                 `json:"index,omitempty"`
+	Event      map[string]interface{} `json:"event"`
+}
+
+// send HECはJSONのオブジェクトを続けて並べたものを一度に受け付ける
+func (s *SplunkSink) send(batch []*Entry) error {
+	eb := encodeEach(batch, func(e *Entry) ([]byte, error) {
+		return json.Marshal(&splunkEvent{
+			Time:       epochSeconds(e.Time),
+			Host:       s.conf.Host,
+			Source:     s.conf.Source,
+			SourceType: s.conf.SourceType,
+			Index:      s.conf.Index,
+			Event:      entryRecord(e),
+		})
+	})
+	if len(eb.entries) == 0 {
+		return eb.result(nil)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, b := range eb.data {
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.conf.URL, buf)
+	if err != nil {
+		return eb.result(&permanentError{err})
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+s.conf.Token)
+	for k, v := range s.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	_, err = doHTTP(s.conf.Client, req)
+	return eb.result(err)
+}
+
+// epochSeconds Unix時間の秒をミリ秒の精度の小数にする
+func epochSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()/int64(time.Millisecond)) / 1000
+}
@@ -0,0 +1,83 @@
+package filelogger
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplunkSink(t *testing.T) {
+	var events []splunkEvent
+	var auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		dec := json.NewDecoder(r.Body)
+		for {
+			var ev splunkEvent
+			if err := dec.Decode(&ev); err == io.EOF {
+				break
+			} else if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			events = append(events, ev)
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	sink, err := NewSplunkSink(SplunkConfig{
+		URL:        ts.URL + "/services/collector/event",
+		Token:      "secret",
+		Host:       "web01",
+		Source:     "myapp",
+		SourceType: "_json",
+		Index:      "main",
+		Batch:      BatchConfig{FlushInterval: time.Hour},
+	})
+	assert.NoError(t, err)
+
+	now := time.Unix(1575329115, 123456789)
+	assert.NoError(t, sink.Write(&Entry{Time: now, Level: WARN, Message: "disk", Fields: Fields{"free": 10}}))
+	assert.NoError(t, sink.Write(&Entry{Time: now, Level: INFO, Message: "nan", Fields: Fields{"v": math.Inf(1)}}))
+	assert.NoError(t, sink.Write(&Entry{Time: now, Level: INFO, Message: "ok"}))
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, "Splunk secret", auth)
+	// JSONにできないEntryは、そのEntryだけ捨てる
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint64(1), sink.Dropped())
+	assert.Equal(t, 1575329115.123, events[0].Time)
+	assert.Equal(t, "web01", events[0].Host)
+	assert.Equal(t, "myapp", events[0].Source)
+	assert.Equal(t, "_json", events[0].SourceType)
+	assert.Equal(t, "main", events[0].Index)
+	assert.Equal(t, map[string]interface{}{"message": "disk", "level": WARN, "free": float64(10)}, events[0].Event)
+}
+
+// トークンが誤っている場合は再試行せずに捨てるか
+func TestSplunkSinkForbidden(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"text":"Invalid token","code":4}`))
+	}))
+	defer ts.Close()
+
+	sink, err := NewSplunkSink(SplunkConfig{URL: ts.URL, Batch: BatchConfig{FlushInterval: time.Hour}})
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Write(&Entry{Time: time.Now(), Level: INFO}))
+	err = sink.Flush()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid token")
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, uint64(1), sink.Dropped())
+}
@@ -0,0 +1,161 @@
+package filelogger
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSubscribeBuffer Subscriptionのチャネルのバッファの初期値
+const defaultSubscribeBuffer = 100
+
+// SubscribeOptions Subscriptionで受け取るEntryとバッファの設定
+type SubscribeOptions struct {
+	Levels     []string // 受け取るログレベル。空の場合はすべて
+	BufferSize int      // チャネルのバッファ。0以下の場合は100
+}
+
+// Subscription 出力されたEntryをCで受け取る。
+// Cに送るEntryはSinkに渡すものと同じなので変更しないこと。受け取りが遅れてバッファがいっぱいの場合は捨ててDroppedで数える
+//
+//	sub := filelogger.Subscribe(filelogger.SubscribeOptions{Levels: []string{filelogger.ERROR}})
+//	defer sub.Close()
+//	for e := range sub.C {
+//		...
+//	}
+type Subscription struct {
+	dropped uint64 // atomicで扱うので、32bit環境でも64bitに揃うように先頭に置く
+
+	C <-chan *Entry // Closeするか、Loggerが閉じられると閉じる
+
+	ch     chan *Entry
+	levels map[string]bool
+	once   sync.Once
+	close  func()
+	err    error
+}
+
+func newSubscription(opts SubscribeOptions) *Subscription {
+	size := opts.BufferSize
+	if size <= 0 {
+		size = defaultSubscribeBuffer
+	}
+	s := &Subscription{ch: make(chan *Entry, size)}
+	s.C = s.ch
+	if len(opts.Levels) > 0 {
+		s.levels = make(map[string]bool, len(opts.Levels))
+		for _, l := range opts.Levels {
+			s.levels[l] = true
+		}
+	}
+	return s
+}
+
+// Subscribe Loggerに出力されるEntryを受け取るSubscriptionを作成する。
+// 受け取るのはログレベルの設定で出力されたものだけで、Subscribeした時点のLoggerに結びつく
+func Subscribe(opts SubscribeOptions) *Subscription {
+	return Logger.subscribe(opts)
+}
+
+// Subscribe アクセスログに出力されるEntryを受け取るSubscriptionを作成する
+func (a *AccessLog) Subscribe(opts SubscribeOptions) *Subscription {
+	return a.logger.subscribe(opts)
+}
+
+func (l *fileLogger) subscribe(opts SubscribeOptions) *Subscription {
+	s := newSubscription(opts)
+	s.close = func() {
+		l.Mutex.Lock()
+		defer l.Mutex.Unlock()
+		for i, sub := range l.subs {
+			if sub == s {
+				l.subs = append(l.subs[:i:i], l.subs[i+1:]...)
+				close(s.ch)
+				break
+			}
+		}
+	}
+
+	l.Mutex.Lock()
+	l.subs = append(l.subs, s)
+	l.Mutex.Unlock()
+	return s
+}
+
+// publish Subscriptionに送る。ロック中に呼ぶこと
+func (l *fileLogger) publish(e *Entry) {
+	for _, s := range l.subs {
+		s.send(e)
+	}
+}
+
+// closeSubscriptions すべてのSubscriptionのチャネルを閉じる。ロック中に呼ぶこと
+func (l *fileLogger) closeSubscriptions() {
+	for _, s := range l.subs {
+		close(s.ch)
+	}
+	l.subs = nil
+}
+
+// send 受け取るログレベルであれば送る。バッファがいっぱいなら待たずに捨てる
+func (s *Subscription) send(e *Entry) {
+	if s.levels != nil && !s.levels[e.Level] {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped バッファがいっぱいで捨てたEntryの数を返す
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Err ファイルから読むSubscriptionで、読んでいる間に起きたエラーを返す。Cが閉じてから呼ぶ
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Close 受け取るのをやめてCを閉じる
+func (s *Subscription) Close() {
+	s.once.Do(s.close)
+}
+
+// SubscribeFile confのFilePathのファイルに追記されたEntryを受け取るSubscriptionを作成する。
+// 別のプロセスが出力しているファイルも読め、ローテーションで名前が変わっても新しいファイルに移って読み続ける。
+// ファイルから読むので、受け取りが遅れてもEntryを捨てずに待つ
+func SubscribeFile(conf *Config, opts SubscribeOptions) (*Subscription, error) {
+	f, err := NewFollower(conf, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSubscription(opts)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	s.close = func() {
+		close(done)
+		f.Close()
+		<-exited
+	}
+
+	go func() {
+		defer close(exited)
+		defer close(s.ch)
+		for f.Next() {
+			e := f.Entry()
+			if s.levels != nil && !s.levels[e.Level] {
+				continue
+			}
+			select {
+			case s.ch <- e:
+			case <-done:
+				return
+			}
+		}
+		s.err = f.Err()
+	}()
+	return s, nil
+}
@@ -0,0 +1,121 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receive(t *testing.T, s *Subscription) *Entry {
+	select {
+	case e, ok := <-s.C:
+		assert.True(t, ok, "channel closed")
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for entry")
+	}
+	return nil
+}
+
+func TestSubscribe(t *testing.T) {
+	l := newFileLogger(&Config{
+		Mode:         ModeProduction,
+		LogLevelConf: LogLevelConfig{{Mode: ModeProduction, ExcludedLevel: []string{DEBUG}}},
+	})
+	all := l.subscribe(SubscribeOptions{})
+	errs := l.subscribe(SubscribeOptions{Levels: []string{ERROR}, BufferSize: 1})
+
+	l.printLevel(DEBUG, "excluded")
+	l.printLevel(INFO, "info")
+	l.write(newEntry(1, ERROR, "first", Fields{"k": "v"}))
+	l.printLevel(ERROR, "second")
+
+	e := receive(t, all)
+	assert.Equal(t, INFO, e.Level)
+	assert.Equal(t, "info", e.Message)
+	assert.Equal(t, ModeProduction, e.Mode)
+	assert.Equal(t, "first", receive(t, all).Message)
+	assert.Equal(t, "second", receive(t, all).Message)
+	assert.Equal(t, uint64(0), all.Dropped())
+
+	e = receive(t, errs)
+	assert.Equal(t, "first", e.Message)
+	assert.Equal(t, Fields{"k": "v"}, e.Fields)
+	assert.Equal(t, uint64(1), errs.Dropped())
+
+	// Closeしたものには送らず、Loggerを閉じると残りも閉じる
+	errs.Close()
+	errs.Close()
+	_, ok := <-errs.C
+	assert.False(t, ok)
+	l.printLevel(ERROR, "after close")
+	assert.Equal(t, "after close", receive(t, all).Message)
+
+	assert.NoError(t, l.close())
+	_, ok = <-all.C
+	assert.False(t, ok)
+	all.Close()
+}
+
+func TestSubscribeAccessLog(t *testing.T) {
+	access := NewAccessLog(&Config{}, AccessLogCommon)
+	sub := access.Subscribe(SubscribeOptions{Levels: []string{WARN}})
+	defer sub.Close()
+
+	rec := &accessRecord{Time: time.Now(), RemoteIP: "127.0.0.1", Method: "GET", URI: "/missing", Proto: "HTTP/1.1", Status: 404}
+	access.write(rec)
+	rec.Status = 200
+	access.write(rec)
+
+	e := receive(t, sub)
+	assert.Equal(t, 404, e.Fields["status"])
+	assert.NoError(t, access.Close())
+	_, ok := <-sub.C
+	assert.False(t, ok)
+}
+
+// 別のloggerが出力してローテーションしたファイルを読み続けるか
+func TestSubscribeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{
+		FilePath:    filepath.Join(dir, "app.log"),
+		LoggerFlags: LoggerFlags,
+		Rotate:      RotateConfig{MaxLine: 3, MaxRotation: 10},
+	}
+	sub, err := SubscribeFile(conf, SubscribeOptions{Levels: []string{WARN}, BufferSize: 1})
+	assert.NoError(t, err)
+
+	l := newFileLogger(conf)
+	for i := 0; i < 10; i++ {
+		level := INFO
+		if i%2 == 1 {
+			level = WARN
+		}
+		l.write(newEntry(1, level, "message "+strconv.Itoa(i), nil))
+	}
+	assert.NoError(t, l.close())
+
+	// バッファが1件でも捨てずに待つ
+	for i := 1; i < 10; i += 2 {
+		e := receive(t, sub)
+		assert.Equal(t, WARN, e.Level)
+		assert.Equal(t, "message "+strconv.Itoa(i), e.Message)
+	}
+	assert.Equal(t, uint64(0), sub.Dropped())
+
+	sub.Close()
+	_, ok := <-sub.C
+	assert.False(t, ok)
+	assert.NoError(t, sub.Err())
+
+	_, err = SubscribeFile(&Config{}, SubscribeOptions{})
+	assert.Error(t, err)
+}
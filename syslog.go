@@ -0,0 +1,307 @@
+package filelogger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyslogFormat syslogのメッセージ形式
+type SyslogFormat int
+
+// syslogのメッセージ形式
+const (
+	RFC5424 SyslogFormat = iota
+	RFC3164
+)
+
+// syslogのfacility
+const (
+	FacilityKern   = 0
+	FacilityUser   = 1
+	FacilityDaemon = 3
+	FacilityAuth   = 4
+	FacilityLocal0 = 16
+	FacilityLocal1 = 17
+	FacilityLocal2 = 18
+	FacilityLocal3 = 19
+	FacilityLocal4 = 20
+	FacilityLocal5 = 21
+	FacilityLocal6 = 22
+	FacilityLocal7 = 23
+)
+
+// syslogのseverity
+const (
+	SeverityEmergency = 0
+	SeverityAlert     = 1
+	SeverityCritical  = 2
+	SeverityError     = 3
+	SeverityWarning   = 4
+	SeverityNotice    = 5
+	SeverityInfo      = 6
+	SeverityDebug     = 7
+)
+
+// SyslogSeverity このパッケージのログレベルをsyslogのseverityにする。定義されていないレベルはnoticeとして扱う
+func SyslogSeverity(level string) int {
+	switch level {
+	case DEBUG:
+		return SeverityDebug
+	case INFO:
+		return SeverityInfo
+	case WARN:
+		return SeverityWarning
+	case ERROR:
+		return SeverityError
+	case FATAL:
+		return SeverityCritical
+	case PANIC:
+		return SeverityAlert
+	}
+	return SeverityNotice
+}
+
+// SyslogConfig SyslogSinkの設定
+type SyslogConfig struct {
+	Network  string       // "unixgram"、"unix"、"udp"、"tcp"。空の場合は/dev/logなどローカルのsyslogに接続する
+	Address  string       // 接続先。Networkが空の場合は使わない
+	Format   SyslogFormat // RFC5424かRFC3164
+	Facility int          // 0(kern)はユーザープロセスからは使わないので、0の場合はFacilityUserにする
+	AppName  string       // 空の場合は実行ファイル名
+	Hostname string       // 空の場合はos.Hostname
+	SDID     string       // RFC5424でFieldsを出力するstructured dataのID。空の場合は"fields@32473"
+	Batch    BatchConfig
+}
+
+// syslogLocalPaths Networkが空の場合に接続を試すローカルのsyslogのソケット
+var syslogLocalPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+const (
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	defaultSDID        = "fields@32473"
+)
+
+// SyslogSink syslogに出力するSink。TCPではRFC 6587のoctet countingで区切る。
+// 送信はbatcherのgoroutineで行い、送れない間はBatch.MaxBufferまで保持して、あふれた分は捨てるかBatch.SpoolDirに保存する
+type SyslogSink struct {
+	*batcher
+	conf SyslogConfig
+	pid  int
+
+	// batcherのgoroutineだけが使う
+	conn    net.Conn
+	network string
+}
+
+// NewSyslogSink confの接続先に接続してSyslogSinkを作成する。接続先が見つからない場合はエラーを返す
+func NewSyslogSink(conf SyslogConfig) (*SyslogSink, error) {
+	if conf.AppName == "" {
+		conf.AppName = filepath.Base(os.Args[0])
+	}
+	if conf.Hostname == "" {
+		conf.Hostname, _ = os.Hostname()
+	}
+	if conf.SDID == "" {
+		conf.SDID = defaultSDID
+	}
+	if conf.Facility == FacilityKern {
+		conf.Facility = FacilityUser
+	}
+
+	s := &SyslogSink{conf: conf, pid: os.Getpid()}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	b, err := newBatcher(conf.Batch, "syslog.spool", s.send)
+	if err != nil {
+		s.disconnect()
+		return nil, err
+	}
+	s.batcher = b
+	return s, nil
+}
+
+// connect 設定された接続先に接続する。Networkが空の場合はローカルのsyslogを順に試す
+func (s *SyslogSink) connect() error {
+	if s.conf.Network != "" {
+		conn, err := net.DialTimeout(s.conf.Network, s.conf.Address, syslogDialTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn, s.network = conn, s.conf.Network
+		return nil
+	}
+
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range syslogLocalPaths {
+			conn, err := net.DialTimeout(network, path, syslogDialTimeout)
+			if err == nil {
+				s.conn, s.network = conn, network
+				return nil
+			}
+		}
+	}
+	return errors.New("filelogger: local syslog server not found")
+}
+
+// Close バッファのEntryを送ってから接続を閉じる
+func (s *SyslogSink) Close() error {
+	err := s.batcher.Close()
+	s.disconnect()
+	return err
+}
+
+// send batchを一件ずつsyslogの形式にして送る。送れなかった場合は、そのEntryから後ろを送り直す
+func (s *SyslogSink) send(batch []*Entry) error {
+	for i, e := range batch {
+		if err := s.write(s.format(e)); err != nil {
+			return &partialError{err: err, retry: batch[i:]}
+		}
+	}
+	return nil
+}
+
+// write msgを送る。接続が切れていた場合は一度だけ接続し直す
+func (s *SyslogSink) write(msg string) error {
+	var err error
+	for retry := 0; retry < 2; retry++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err = s.conn.Write(s.frame(msg)); err == nil {
+			return nil
+		}
+		s.disconnect()
+	}
+	return err
+}
+
+func (s *SyslogSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// frame ストリームで送る場合の区切りを付ける。TCPはoctet counting、Unixドメインソケットは改行で区切る
+func (s *SyslogSink) frame(msg string) []byte {
+	switch s.network {
+	case "tcp", "tcp4", "tcp6":
+		return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+	case "unix":
+		return []byte(msg + "\n")
+	}
+	return []byte(msg)
+}
+
+func (s *SyslogSink) format(e *Entry) string {
+	pri := s.conf.Facility*8 + SyslogSeverity(e.Level)
+	if s.conf.Format == RFC3164 {
+		return s.formatRFC3164(pri, e)
+	}
+	return s.formatRFC5424(pri, e)
+}
+
+// formatRFC5424 <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *SyslogSink) formatRFC5424(pri int, e *Entry) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d - %s %s",
+		pri,
+		e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.conf.Hostname, 255),
+		syslogHeaderField(s.conf.AppName, 48),
+		s.pid,
+		s.structuredData(e.Fields),
+		e.Message,
+	)
+}
+
+// formatRFC3164 <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG。Fieldsはメッセージのあとにkey=value形式で続ける。
+// HOSTNAMEとTAGに空白や制御文字があると区切りがずれるので、RFC5424と同じように_に置き換える。TAGは32文字までにし、PIDやMSGとの区切りに使う:と[]も置き換える
+func (s *SyslogSink) formatRFC3164(pri int, e *Entry) string {
+	msg := e.Message
+	if len(e.Fields) > 0 {
+		msg += " " + formatFields(e.Fields)
+	}
+	return fmt.Sprintf("<%d>%s %s %s[%d]: %s",
+		pri,
+		e.Time.Format(time.Stamp),
+		syslogHeaderField(s.conf.Hostname, 255),
+		syslogTag(s.conf.AppName),
+		s.pid,
+		msg,
+	)
+}
+
+// structuredData Fieldsを[SDID key="value" ...]の形式にする。Fieldsがない場合は"-"を返す
+func (s *SyslogSink) structuredData(fields Fields) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := &strings.Builder{}
+	b.WriteString("[" + s.conf.SDID)
+	for _, k := range keys {
+		fmt.Fprintf(b, ` %s="%s"`, sdName(k), sdEscaper.Replace(fmt.Sprint(fields[k])))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// sdEscaper structured dataの値でエスケープが必要な文字
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// sdName structured dataのパラメータ名に使えない文字を_に置き換え、32文字までにする
+func sdName(name string) string {
+	s := printableASCII(name, 32, `=]"`)
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+// syslogTag RFC3164のTAGにする。空の場合は"-"を返す
+func syslogTag(name string) string {
+	s := printableASCII(name, 32, ":[]")
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// syslogHeaderField ヘッダーの項目を空白を含まないmax文字までの文字列にする。空の場合は"-"を返す
+func syslogHeaderField(field string, max int) string {
+	s := printableASCII(field, max, "")
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// printableASCII 空白と制御文字、ASCII以外とinvalidに含まれる文字を_に置き換え、max文字までにする
+func printableASCII(s string, max int, invalid string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(invalid, c) >= 0 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	return string(b)
+}
@@ -0,0 +1,195 @@
+package filelogger
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var syslogTestEntry = &Entry{
+	Time:    time.Date(2019, 12, 2, 23, 25, 15, 123456000, time.UTC),
+	Level:   ERROR,
+	Message: "disk full",
+	Fields:  Fields{"path": "/var", "quote": `a"b]`},
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	assert.Equal(t, SeverityDebug, SyslogSeverity(DEBUG))
+	assert.Equal(t, SeverityInfo, SyslogSeverity(INFO))
+	assert.Equal(t, SeverityWarning, SyslogSeverity(WARN))
+	assert.Equal(t, SeverityError, SyslogSeverity(ERROR))
+	assert.Equal(t, SeverityCritical, SyslogSeverity(FATAL))
+	assert.Equal(t, SeverityAlert, SyslogSeverity(PANIC))
+	assert.Equal(t, SeverityNotice, SyslogSeverity("TRACE"))
+}
+
+func TestSyslogFormat(t *testing.T) {
+	s := &SyslogSink{
+		conf: SyslogConfig{Facility: FacilityLocal0, AppName: "my app", Hostname: "host1", SDID: defaultSDID},
+		pid:  123,
+	}
+	assert.Equal(t,
+		`<131>1 2019-12-02T23:25:15.123456Z host1 my_app 123 - [fields@32473 path="/var" quote="a\"b\]"] disk full`,
+		s.format(syslogTestEntry))
+
+	s.conf.Format = RFC3164
+	assert.Equal(t,
+		`<131>Dec  2 23:25:15 host1 my_app[123]: disk full path=/var quote="a\"b]"`,
+		s.format(syslogTestEntry))
+
+	// HOSTNAMEの空白や制御文字でTAGとの区切りがずれない
+	s.conf.Hostname = "host 1\nfake"
+	assert.Equal(t,
+		`<131>Dec  2 23:25:15 host_1_fake my_app[123]: disk full path=/var quote="a\"b]"`,
+		s.format(syslogTestEntry))
+
+	// TAGも区切りの文字を置き換えて32文字までにする
+	s.conf.Hostname = "host1"
+	s.conf.AppName = "app: x[1]" + strings.Repeat("a", 40)
+	assert.Equal(t,
+		`<131>Dec  2 23:25:15 host1 app__x_1_`+strings.Repeat("a", 23)+`[123]: disk full path=/var quote="a\"b]"`,
+		s.format(syslogTestEntry))
+
+	s.conf.AppName = "my app"
+	s.conf.Format = RFC5424
+	assert.Equal(t,
+		`<134>1 2019-12-02T23:25:15.123456Z host1 my_app 123 - - hello`,
+		s.format(&Entry{Time: syslogTestEntry.Time, Level: INFO, Message: "hello"}))
+}
+
+var rfc5424Pattern = regexp.MustCompile(`^<131>1 \S+ testhost filelogger \d+ - \[fields@32473 path="/var" quote="a\\"b\\\]"\] disk full$`)
+
+func TestSyslogSinkUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	s, err := NewSyslogSink(SyslogConfig{
+		Network:  "udp",
+		Address:  pc.LocalAddr().String(),
+		Facility: FacilityLocal0,
+		AppName:  "filelogger",
+		Hostname: "testhost",
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.NoError(t, s.Write(syslogTestEntry))
+	assert.NoError(t, s.Flush())
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Regexp(t, rfc5424Pattern, string(buf[:n]))
+}
+
+// TCPではoctet countingで区切られているか
+func TestSyslogSinkTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var msgs []string
+		for len(msgs) < 2 {
+			l, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(l))
+			b := make([]byte, n)
+			if _, err = r.Read(b); err != nil {
+				break
+			}
+			msgs = append(msgs, string(b))
+		}
+		received <- msgs
+	}()
+
+	s, err := NewSyslogSink(SyslogConfig{
+		Network:  "tcp",
+		Address:  ln.Addr().String(),
+		Facility: FacilityLocal0,
+		AppName:  "filelogger",
+		Hostname: "testhost",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Write(syslogTestEntry))
+	assert.NoError(t, s.Write(syslogTestEntry))
+	assert.NoError(t, s.Close())
+
+	select {
+	case msgs := <-received:
+		assert.Len(t, msgs, 2)
+		for _, msg := range msgs {
+			assert.Regexp(t, rfc5424Pattern, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+// loggerのSinkとして/dev/logと同じunixgramのソケットに出力できるか
+func TestSyslogSinkUnixgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	pc, err := net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	s, err := NewSyslogSink(SyslogConfig{Network: "unixgram", Address: path, Format: RFC3164, Hostname: "testhost"})
+	assert.NoError(t, err)
+	logger := newFileLogger(&Config{Sinks: []Sink{s}})
+	(&FieldLogger{logger: logger, fields: Fields{"id": 1}}).Rprintln(WARN, "low memory")
+	assert.NoError(t, logger.close())
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^<12>\w{3} [ \d]\d \d\d:\d\d:\d\d testhost \S+\[\d+\]: low memory id=1$`, string(buf[:n]))
+}
+
+// 接続先が止まっていてもWriteは送信を待たずに返り、送れなかったものは捨てるか
+func TestSyslogSinkUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	s, err := NewSyslogSink(SyslogConfig{
+		Network: "tcp",
+		Address: ln.Addr().String(),
+		Batch:   BatchConfig{MaxRetries: -1},
+	})
+	assert.NoError(t, err)
+	conn, err := ln.Accept()
+	assert.NoError(t, err)
+	conn.Close()
+	ln.Close()
+
+	logger := newFileLogger(&Config{Sinks: []Sink{s}})
+	for i := 0; i < 3; i++ {
+		logger.printLevel(INFO, "lost")
+	}
+	// 切れた接続への最初の書き込みは成功することがあるので、何件捨てたかは確かめない
+	assert.Error(t, s.Flush())
+	assert.True(t, s.Dropped() > 0)
+	logger.close()
+}
@@ -0,0 +1,221 @@
+package filelogger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ConfigError 設定の項目ごとのエラー
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// ValidationError Validateで見つかったすべてのエラー
+type ValidationError []*ConfigError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, ce := range e {
+		msgs[i] = ce.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// ValidateAndInitialize 設定をチェックしてからLoggerを初期化する。設定に問題があればLoggerは変更せずにエラーを返す。
+// CreateDirが設定されていればディレクトリを作成し、書き込めなければエラーを返す
+func ValidateAndInitialize(conf *Config) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	if conf.CreateDir {
+		if err := conf.prepareDir(); err != nil {
+			return err
+		}
+	}
+	Logger = newFileLogger(conf)
+	return nil
+}
+
+// Validate 最初の書き込みまで気づけない設定の誤りをチェックする。問題があればValidationErrorを返す。
+// FilePermとFileFlagsが0の場合はaddMissingConfPartsで補われる値としてチェックする。
+// FilePathが空の場合はSinksにだけ出力するので、Sinksが設定されていればエラーにしない
+func (c *Config) Validate() error {
+	var errs ValidationError
+	add := func(field, format string, v ...interface{}) {
+		errs = append(errs, &ConfigError{Field: field, Reason: fmt.Sprintf(format, v...)})
+	}
+
+	perm := c.FilePerm
+	if perm == 0 {
+		perm = FilePerm
+	}
+	if perm&^os.ModePerm != 0 {
+		add("FilePerm", "%#o contains bits other than permissions", perm)
+	} else if perm&0200 == 0 {
+		add("FilePerm", "%#o has no owner write permission, the created file cannot be written", perm)
+	}
+
+	if c.FilePath == "" {
+		if len(c.Sinks) == 0 {
+			add("FilePath", "must not be empty unless Sinks are set")
+		}
+	} else {
+		c.validatePath(add)
+	}
+
+	if c.CreateDir {
+		if c.DirPerm&^os.ModePerm != 0 {
+			add("DirPerm", "%#o contains bits other than permissions", c.DirPerm)
+		} else if c.DirPerm != 0 && c.DirPerm&0300 != 0300 {
+			add("DirPerm", "%#o has no owner write and execute permission, files cannot be created in the directory", c.DirPerm)
+		}
+		if _, err := lookupGroup(c.DirGroup); err != nil {
+			add("DirGroup", "%v", err)
+		}
+	}
+
+	if c.Rotate.MaxLine < 0 {
+		add("Rotate.MaxLine", "must not be negative, got %d", c.Rotate.MaxLine)
+	} else if c.Rotate.MaxLine == 1 {
+		add("Rotate.MaxLine", "1 disables rotation, use 0 to disable or a value greater than 1")
+	}
+	if c.Rotate.MaxRotation < 0 {
+		add("Rotate.MaxRotation", "must not be negative, got %d", c.Rotate.MaxRotation)
+	}
+	if c.Rotate.MaxSize < 0 {
+		add("Rotate.MaxSize", "must not be negative, got %d", c.Rotate.MaxSize)
+	}
+	if c.Rotate.MaxAge < 0 {
+		add("Rotate.MaxAge", "must not be negative, got %v", c.Rotate.MaxAge)
+	}
+
+	if c.IndexInterval < 0 {
+		add("IndexInterval", "must not be negative, got %d", c.IndexInterval)
+	}
+
+	c.validateRedact(add)
+	if _, ok := controlCharPolicyNames[c.Sanitize.ControlChars]; !ok {
+		add("Sanitize.ControlChars", "unknown policy %v", c.Sanitize.ControlChars)
+	}
+	if c.Audit.Enabled && c.FilePath == "" {
+		add("Audit", "requires FilePath, the chain is written to the file")
+	}
+	if c.Sanitize.MaxLength < 0 {
+		add("Sanitize.MaxLength", "must not be negative, got %d", c.Sanitize.MaxLength)
+	}
+	c.validateEncrypt(add)
+
+	seen := map[string]bool{}
+	for i, lc := range c.LogLevelConf {
+		field := fmt.Sprintf("LogLevelConf[%d].Mode", i)
+		if lc.Mode == "" {
+			add(field, "must not be empty")
+			continue
+		}
+		if seen[lc.Mode] {
+			add(field, "duplicate mode %q", lc.Mode)
+		}
+		seen[lc.Mode] = true
+	}
+	if len(c.LogLevelConf) > 0 && !seen[c.Mode] {
+		add("Mode", "%q is not defined in LogLevelConf, no level would be excluded", c.Mode)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validatePath 出力先のディレクトリが存在して書き込めるか、既存のファイルに追記できるかをチェックする。
+// CreateDirが設定されていてディレクトリがない場合は、作成できるかを一番近い既存の親ディレクトリでチェックする
+func (c *Config) validatePath(add func(field, format string, v ...interface{})) {
+	dir := filepath.Dir(c.FilePath)
+	di, err := os.Stat(dir)
+	if os.IsNotExist(err) && c.CreateDir {
+		if missing := missingDirs(dir); len(missing) > 0 {
+			dir = filepath.Dir(missing[0])
+			di, err = os.Stat(dir)
+		}
+	}
+	switch {
+	case os.IsNotExist(err):
+		add("FilePath", "directory %q does not exist", dir)
+		return
+	case err != nil:
+		add("FilePath", "%v", err)
+		return
+	case !di.IsDir():
+		add("FilePath", "%q is not a directory", dir)
+		return
+	}
+
+	if err = checkDirWritable(dir); err != nil {
+		add("FilePath", "%v", err)
+		return
+	}
+
+	fi, err := os.Stat(c.FilePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil && fi.IsDir() {
+		add("FilePath", "%q is a directory", c.FilePath)
+		return
+	}
+	f, err := os.OpenFile(c.FilePath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		add("FilePath", "existing file is not writable: %v", err)
+		return
+	}
+	f.Close()
+}
+
+// validateRedact 置き換えの正規表現がコンパイルできるか、RedactHashに鍵が設定されているかをチェックする
+func (c *Config) validateRedact(add func(field, format string, v ...interface{})) {
+	hash := len(c.Redact.Fields) > 0 && c.Redact.Strategy == RedactHash
+	if _, ok := redactStrategyNames[c.Redact.Strategy]; !ok {
+		add("Redact.Strategy", "unknown strategy %v", c.Redact.Strategy)
+	}
+	for i, rule := range c.Redact.Rules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			add(fmt.Sprintf("Redact.Rules[%d].Pattern", i), "%v", err)
+		}
+		if _, ok := redactStrategyNames[rule.Strategy]; !ok {
+			add(fmt.Sprintf("Redact.Rules[%d].Strategy", i), "unknown strategy %v", rule.Strategy)
+		}
+		hash = hash || rule.Strategy == RedactHash
+	}
+	if hash && c.Redact.Salt == "" {
+		add("Redact.Salt", "must not be empty when hashing, unsalted hashes of short values can be reversed")
+	}
+}
+
+// validateEncrypt 今の鍵で暗号化できるかを確かめる。ローテーションした後で失敗すると、ファイルが平文のまま残るため
+func (c *Config) validateEncrypt(add func(field, format string, v ...interface{})) {
+	if c.Encrypt.ChunkSize < 0 || c.Encrypt.ChunkSize > maxEncryptChunkSize {
+		add("Encrypt.ChunkSize", "must be between 0 and %d, got %d", maxEncryptChunkSize, c.Encrypt.ChunkSize)
+	}
+	if !c.Encrypt.enabled() {
+		return
+	}
+	id, key, err := c.Encrypt.Keys.CurrentKey()
+	if err != nil {
+		add("Encrypt.Keys", "%v", err)
+		return
+	}
+	if len(id) > 255 {
+		add("Encrypt.Keys", "key id %q is longer than 255 bytes", id)
+	}
+	if n := len(key); n != 16 && n != 24 && n != 32 {
+		add("Encrypt.Keys", "key %q must be 16, 24 or 32 bytes for AES, got %d", id, n)
+	}
+}
@@ -0,0 +1,100 @@
+package filelogger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "validate.log")
+	valid := func() *Config {
+		return &Config{
+			Rotate:   RotateConfig{MaxLine: 100, MaxRotation: 5},
+			Mode:     ModeProduction,
+			FilePath: path,
+			LogLevelConf: LogLevelConfig{
+				{Mode: ModeProduction, ExcludedLevel: []string{DEBUG}},
+				{Mode: ModeDebug},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		expect []string // 期待するConfigErrorのField。空ならエラーなし
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"valid without LogLevelConf", func(c *Config) { c.Mode, c.LogLevelConf = "", nil }, nil},
+		{"missing FilePath", func(c *Config) { c.FilePath = "" }, []string{"FilePath"}},
+		{"missing directory", func(c *Config) { c.FilePath = filepath.Join(dir, "none", "a.log") }, []string{"FilePath"}},
+		{"FilePath is directory", func(c *Config) { c.FilePath = dir }, []string{"FilePath"}},
+		{"unwritable FilePerm", func(c *Config) { c.FilePerm = 0444 }, []string{"FilePerm"}},
+		{"FilePerm with mode bits", func(c *Config) { c.FilePerm = os.ModeDir | 0755 }, []string{"FilePerm"}},
+		{"negative MaxLine", func(c *Config) { c.Rotate.MaxLine = -1 }, []string{"Rotate.MaxLine"}},
+		{"MaxLine 1", func(c *Config) { c.Rotate.MaxLine = 1 }, []string{"Rotate.MaxLine"}},
+		{"negative MaxRotation", func(c *Config) { c.Rotate.MaxRotation = -5 }, []string{"Rotate.MaxRotation"}},
+		{"negative MaxSize", func(c *Config) { c.Rotate.MaxSize = -1 }, []string{"Rotate.MaxSize"}},
+		{"negative MaxAge", func(c *Config) { c.Rotate.MaxAge = -time.Hour }, []string{"Rotate.MaxAge"}},
+		{"duplicate mode", func(c *Config) {
+			c.LogLevelConf = append(c.LogLevelConf, LevelConfig{Mode: ModeDebug})
+		}, []string{"LogLevelConf[2].Mode"}},
+		{"empty mode", func(c *Config) {
+			c.LogLevelConf = append(c.LogLevelConf, LevelConfig{})
+		}, []string{"LogLevelConf[2].Mode"}},
+		{"mode absent from LogLevelConf", func(c *Config) { c.Mode = "StagingMode" }, []string{"Mode"}},
+		{"multiple errors", func(c *Config) {
+			c.FilePath = ""
+			c.Rotate.MaxLine = -1
+			c.Mode = "StagingMode"
+		}, []string{"FilePath", "Rotate.MaxLine", "Mode"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := valid()
+			tt.modify(conf)
+			err := conf.Validate()
+			if len(tt.expect) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			verr, ok := err.(ValidationError)
+			if !assert.True(t, ok, "%v", err) {
+				return
+			}
+			var fields []string
+			for _, ce := range verr {
+				fields = append(fields, ce.Field)
+			}
+			assert.Equal(t, tt.expect, fields, err.Error())
+		})
+	}
+}
+
+func TestValidateAndInitialize(t *testing.T) {
+	orig := Logger
+	defer func() { Logger = orig }()
+
+	err := ValidateAndInitialize(&Config{FilePath: ""})
+	assert.EqualError(t, err, "invalid config: FilePath: must not be empty unless Sinks are set")
+	assert.True(t, Logger == orig)
+
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := &Config{FilePath: filepath.Join(dir, "init.log")}
+	assert.NoError(t, ValidateAndInitialize(conf))
+	assert.True(t, Logger.Conf == conf)
+}
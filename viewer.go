@@ -0,0 +1,502 @@
+package filelogger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultViewerLimit = 1000             // /api/entriesで返す件数の初期値
+	maxViewerLimit     = 10000            // /api/entriesで返す件数の上限
+	viewerHeartbeat    = 15 * time.Second // /api/tailで接続を保つためにコメントを送る間隔
+)
+
+// Viewer ログファイルをブラウザで見るためのhttp.Handler。
+// ローテーションしたファイルの一覧、ログレベルと期間とメッセージでの絞り込み(圧縮されたファイルは解凍しながら読む)、
+// Server-Sent Eventsでの追記の表示ができる。ログの内容をそのまま見せるので、認証は呼び出し側で行うこと。
+// パスは相対パスで参照しているので、http.StripPrefixで任意のパスの下に置ける
+//
+//	viewer, err := filelogger.NewViewer(conf)
+//	http.Handle("/logs/", http.StripPrefix("/logs", auth(viewer)))
+//
+// 以下のパスを扱う。
+//
+//	/              一覧と検索の画面
+//	/api/files     ファイルの一覧。名前、サイズ、更新日時、圧縮の有無、最初と最後のEntryの日時と件数
+//	/api/entries   Entryの検索。file、level(カンマ区切り)、since、until、q、limit。条件に合うものが多い場合は新しいものからlimit件
+//	/api/tail      追記されたEntryをServer-Sent Eventsで送る。level、qで絞り込める
+type Viewer struct {
+	conf *Config
+	mux  *http.ServeMux
+
+	mu        sync.Mutex
+	summaries map[string]*fileSummary // ファイルごとの集計。サイズと更新日時が変わったら集計し直す
+}
+
+// NewViewer confのFilePathのファイルを見るViewerを作成する。行はconfのLoggerFlagsとPrefixで解析する
+func NewViewer(conf *Config) (*Viewer, error) {
+	if conf.FilePath == "" {
+		return nil, errors.New("filelogger: FilePath is empty")
+	}
+	v := &Viewer{
+		conf:      conf,
+		mux:       http.NewServeMux(),
+		summaries: map[string]*fileSummary{},
+	}
+	v.mux.HandleFunc("/", v.serveIndex)
+	v.mux.HandleFunc("/api/files", v.serveFiles)
+	v.mux.HandleFunc("/api/entries", v.serveEntries)
+	v.mux.HandleFunc("/api/tail", v.serveTail)
+	return v, nil
+}
+
+func (v *Viewer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	v.mux.ServeHTTP(w, r)
+}
+
+func (v *Viewer) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, viewerHTML)
+}
+
+// fileSummary /api/filesで返す一つのファイルの情報
+type fileSummary struct {
+	Name       string     `json:"name"`
+	Size       int64      `json:"size"`
+	ModTime    time.Time  `json:"mod_time"`
+	Compressed bool       `json:"compressed"`
+	Encrypted  bool       `json:"encrypted"`
+	Active     bool       `json:"active"`
+	First      *time.Time `json:"first,omitempty"`
+	Last       *time.Time `json:"last,omitempty"`
+	Entries    int        `json:"entries"`
+}
+
+func (v *Viewer) serveFiles(w http.ResponseWriter, r *http.Request) {
+	paths := LogFiles(v.conf.FilePath)
+	v.pruneSummaries(paths)
+	files := make([]*fileSummary, 0, len(paths))
+	for _, path := range paths {
+		s, err := v.summary(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		files = append(files, s)
+	}
+	writeJSON(w, files)
+}
+
+// pruneSummaries ローテーションで削除されるなどしてpathsにないファイルの集計を捨てる
+func (v *Viewer) pruneSummaries(paths []string) {
+	exists := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		exists[path] = true
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for path := range v.summaries {
+		if !exists[path] {
+			delete(v.summaries, path)
+		}
+	}
+}
+
+// summary ファイルを最後まで読んで集計する。前回からサイズと更新日時が変わっていなければ前回の結果を返す
+func (v *Viewer) summary(path string) (*fileSummary, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	s, ok := v.summaries[path]
+	v.mu.Unlock()
+	if ok && s.Size == fi.Size() && s.ModTime.Equal(fi.ModTime()) {
+		return s, nil
+	}
+
+	s = &fileSummary{
+		Name:    filepath.Base(path),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Active:  path == v.conf.FilePath,
+	}
+	r := v.newReader([]string{path})
+	defer r.Close()
+	for r.Next() {
+		e := r.Entry()
+		s.Entries++
+		if e.Time.IsZero() {
+			continue
+		}
+		t := e.Time
+		if s.First == nil {
+			s.First = &t
+		}
+		s.Last = &t
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	s.Compressed = r.compressed
+	s.Encrypted = r.encrypted
+
+	v.mu.Lock()
+	v.summaries[path] = s
+	v.mu.Unlock()
+	return s, nil
+}
+
+func (v *Viewer) newReader(files []string) *Reader {
+	return &Reader{parser: entryParser{flags: v.conf.LoggerFlags, prefix: v.conf.Prefix}, files: files, keys: v.conf.Encrypt.Keys}
+}
+
+// entriesResponse /api/entriesで返す結果。Truncatedは条件に合うEntryがLimitより多く、古いものを省いたかどうか
+type entriesResponse struct {
+	Entries   []*Entry `json:"entries"`
+	Truncated bool     `json:"truncated"`
+}
+
+func (v *Viewer) serveEntries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseViewerFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultViewerLimit
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit "+strconv.Quote(s), http.StatusBadRequest)
+			return
+		}
+		if limit > maxViewerLimit {
+			limit = maxViewerLimit
+		}
+	}
+
+	files := LogFiles(v.conf.FilePath)
+	if name := q.Get("file"); name != "" {
+		// 一覧にあるファイルだけを読めるようにし、他のパスを指定できないようにする
+		var found []string
+		for _, path := range files {
+			if filepath.Base(path) == name {
+				found = []string{path}
+			}
+		}
+		if found == nil {
+			http.Error(w, "unknown file "+strconv.Quote(name), http.StatusNotFound)
+			return
+		}
+		files = found
+	}
+
+	// 新しいものからlimit件を残すため、limit件の環状バッファに入れる
+	resp := &entriesResponse{Entries: []*Entry{}}
+	ring := make([]*Entry, 0, limit)
+	next := 0
+	reader := v.newReader(files)
+	defer reader.Close()
+	for reader.Next() {
+		e := reader.Entry()
+		if !filter.match(e) {
+			continue
+		}
+		if len(ring) < limit {
+			ring = append(ring, e)
+			continue
+		}
+		ring[next] = e
+		next = (next + 1) % limit
+		resp.Truncated = true
+	}
+	if err := reader.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Entries = append(append(resp.Entries, ring[next:]...), ring[:next]...)
+	writeJSON(w, resp)
+}
+
+// serveTail 追記されたEntryを一件ずつdataにJSONで入れて送る。接続を保つため、送るものがなくてもコメントを送る
+func (v *Viewer) serveTail(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	filter, err := parseViewerFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var levels []string
+	for l := range filter.levels {
+		levels = append(levels, l)
+	}
+	sub, err := SubscribeFile(v.conf, SubscribeOptions{Levels: levels})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer sub.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(viewerHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !filter.match(e) {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// viewerFilter /api/entriesと/api/tailの絞り込みの条件
+type viewerFilter struct {
+	levels map[string]bool
+	since  time.Time
+	until  time.Time
+	query  string // メッセージかFieldsの値に含まれる文字列。大文字と小文字は区別しない
+}
+
+// viewerTimeLayouts sinceとuntilで受け付ける日時の形式。datetime-localの値はタイムゾーンがないのでローカル時刻とする
+var viewerTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+func parseViewerFilter(q map[string][]string) (*viewerFilter, error) {
+	get := func(key string) string {
+		if v := q[key]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+
+	f := &viewerFilter{query: strings.ToLower(get("q"))}
+	if s := get("level"); s != "" {
+		f.levels = map[string]bool{}
+		for _, l := range strings.Split(s, ",") {
+			if l = strings.ToUpper(strings.TrimSpace(l)); l != "" {
+				f.levels[l] = true
+			}
+		}
+	}
+	for key, t := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		s := get(key)
+		if s == "" {
+			continue
+		}
+		parsed := false
+		for _, layout := range viewerTimeLayouts {
+			if v, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				*t, parsed = v, true
+				break
+			}
+		}
+		if !parsed {
+			return nil, fmt.Errorf("invalid %s %q", key, s)
+		}
+	}
+	return f, nil
+}
+
+func (f *viewerFilter) match(e *Entry) bool {
+	if f.levels != nil && !f.levels[e.Level] {
+		return false
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !e.Time.Before(f.until) {
+		return false
+	}
+	if f.query == "" || strings.Contains(strings.ToLower(e.Message), f.query) {
+		return true
+	}
+	for _, v := range e.Fields {
+		if strings.Contains(strings.ToLower(fmt.Sprint(v)), f.query) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logPrintln(err.Error())
+	}
+}
+
+// viewerHTML Viewerの画面。外部のファイルは読み込まず、APIは相対パスで呼ぶ
+const viewerHTML = `<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>file-logger</title>
+<style>
+body { font-family: sans-serif; margin: 1em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1em; }
+th, td { border-bottom: 1px solid #ddd; padding: 2px 6px; text-align: left; vertical-align: top; }
+td.msg { font-family: monospace; white-space: pre-wrap; word-break: break-all; }
+form { margin: 1em 0; display: flex; flex-wrap: wrap; gap: .5em; align-items: center; }
+.DEBUG { color: #888; } .WARN { color: #b60; } .ERROR, .FATAL, .PANIC { color: #c00; font-weight: bold; }
+#status { color: #666; }
+</style>
+</head>
+<body>
+<h1>file-logger</h1>
+<table id="files"><thead><tr><th>file</th><th>size</th><th>first</th><th>last</th><th>entries</th><th></th></tr></thead><tbody></tbody></table>
+<form id="search">
+<select name="file"><option value="">all files</option></select>
+<label><input type="checkbox" name="level" value="DEBUG">DEBUG</label>
+<label><input type="checkbox" name="level" value="INFO">INFO</label>
+<label><input type="checkbox" name="level" value="WARN">WARN</label>
+<label><input type="checkbox" name="level" value="ERROR">ERROR</label>
+<label><input type="checkbox" name="level" value="FATAL">FATAL</label>
+<label><input type="checkbox" name="level" value="PANIC">PANIC</label>
+<input type="datetime-local" name="since" step="1"> - <input type="datetime-local" name="until" step="1">
+<input type="search" name="q" placeholder="search">
+<button type="submit">search</button>
+<label><input type="checkbox" id="live">live</label>
+<span id="status"></span>
+</form>
+<table id="entries"><thead><tr><th>time</th><th>level</th><th>message</th><th>fields</th></tr></thead><tbody></tbody></table>
+<script>
+(function () {
+  var form = document.getElementById("search");
+  var body = document.querySelector("#entries tbody");
+  var status = document.getElementById("status");
+  var source = null;
+
+  function params() {
+    var p = new URLSearchParams();
+    var levels = [];
+    form.querySelectorAll("input[name=level]:checked").forEach(function (c) { levels.push(c.value); });
+    if (levels.length) p.set("level", levels.join(","));
+    ["file", "since", "until", "q"].forEach(function (k) {
+      if (form.elements[k].value) p.set(k, form.elements[k].value);
+    });
+    return p;
+  }
+
+  function cell(tr, text, cls) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    if (cls) td.className = cls;
+    tr.appendChild(td);
+  }
+
+  function row(e) {
+    var tr = document.createElement("tr");
+    cell(tr, e.time && e.time.indexOf("0001-") !== 0 ? new Date(e.time).toLocaleString() : "");
+    cell(tr, e.level, e.level);
+    cell(tr, e.message, "msg");
+    cell(tr, e.fields ? Object.keys(e.fields).sort().map(function (k) { return k + "=" + e.fields[k]; }).join(" ") : "", "msg");
+    return tr;
+  }
+
+  function loadFiles() {
+    fetch("api/files").then(function (r) { return r.json(); }).then(function (files) {
+      var tbody = document.querySelector("#files tbody");
+      var select = form.elements.file;
+      tbody.textContent = "";
+      select.length = 1;
+      files.forEach(function (f) {
+        var tr = document.createElement("tr");
+        cell(tr, f.name + (f.active ? " (active)" : "") + (f.compressed ? " (gzip)" : "") + (f.encrypted ? " (encrypted)" : ""));
+        cell(tr, f.size.toLocaleString());
+        cell(tr, f.first ? new Date(f.first).toLocaleString() : "");
+        cell(tr, f.last ? new Date(f.last).toLocaleString() : "");
+        cell(tr, f.entries.toLocaleString());
+        tbody.appendChild(tr);
+        select.add(new Option(f.name, f.name));
+      });
+    });
+  }
+
+  function search() {
+    status.textContent = "loading...";
+    fetch("api/entries?" + params()).then(function (r) {
+      if (!r.ok) return r.text().then(function (t) { throw new Error(t); });
+      return r.json();
+    }).then(function (res) {
+      body.textContent = "";
+      res.entries.forEach(function (e) { body.appendChild(row(e)); });
+      status.textContent = res.entries.length + " entries" + (res.truncated ? " (latest only)" : "");
+      window.scrollTo(0, document.body.scrollHeight);
+    }).catch(function (err) { status.textContent = err.message; });
+  }
+
+  function live(on) {
+    if (source) { source.close(); source = null; }
+    if (!on) return;
+    var p = params();
+    p.delete("file"); p.delete("since"); p.delete("until");
+    source = new EventSource("api/tail?" + p);
+    source.onmessage = function (ev) {
+      body.appendChild(row(JSON.parse(ev.data)));
+      window.scrollTo(0, document.body.scrollHeight);
+    };
+  }
+
+  form.addEventListener("submit", function (ev) {
+    ev.preventDefault();
+    search();
+    live(document.getElementById("live").checked);
+  });
+  document.getElementById("live").addEventListener("change", function (ev) { live(ev.target.checked); });
+  loadFiles();
+  search();
+})();
+</script>
+</body>
+</html>
+`
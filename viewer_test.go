@@ -0,0 +1,187 @@
+package filelogger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newViewerTest 圧縮したローテーション済みのファイルと出力中のファイルを作り、StripPrefixで/logs/の下に置いたViewerを起動する
+func newViewerTest(t *testing.T) (*httptest.Server, *Config, func()) {
+	dir, err := ioutil.TempDir("", "filelogger")
+	assert.NoError(t, err)
+	conf := &Config{FilePath: filepath.Join(dir, "app.log"), LoggerFlags: LoggerFlags}
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write([]byte("2019/12/03 08:10:00 [INFO] started\tport=8080\n2019/12/03 08:20:00 [DEBUG] config loaded\n"))
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Dec 3 08:20:01.000000000 2019_app.log"), buf.Bytes(), 0644))
+	assert.NoError(t, ioutil.WriteFile(conf.FilePath, []byte(
+		"2019/12/03 09:05:00 [WARN] slow request\tuser=alice\n"+
+			"2019/12/03 09:30:00 [ERROR] request failed\n"+
+			"2019/12/03 10:00:00 [INFO] done\n"), 0644))
+
+	v, err := NewViewer(conf)
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.Handle("/logs/", http.StripPrefix("/logs", v))
+	ts := httptest.NewServer(mux)
+	return ts, conf, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func TestViewerFiles(t *testing.T) {
+	ts, _, cleanup := newViewerTest(t)
+	defer cleanup()
+
+	var files []fileSummary
+	assert.Equal(t, http.StatusOK, getJSON(t, ts.URL+"/logs/api/files", &files))
+	assert.Len(t, files, 2)
+	assert.Equal(t, "Dec 3 08:20:01.000000000 2019_app.log", files[0].Name)
+	assert.True(t, files[0].Compressed)
+	assert.False(t, files[0].Active)
+	assert.Equal(t, 2, files[0].Entries)
+	assert.True(t, files[0].First.Equal(time.Date(2019, 12, 3, 8, 10, 0, 0, time.Local)))
+	assert.True(t, files[0].Last.Equal(time.Date(2019, 12, 3, 8, 20, 0, 0, time.Local)))
+	assert.Equal(t, "app.log", files[1].Name)
+	assert.True(t, files[1].Active)
+	assert.False(t, files[1].Compressed)
+	assert.Equal(t, 3, files[1].Entries)
+
+	resp, err := http.Get(ts.URL + "/logs/")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `fetch("api/files")`)
+
+	resp, err = http.Get(ts.URL + "/logs/missing")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL+"/logs/api/files", "text/plain", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+// 削除されたファイルの集計が残り続けないか
+func TestViewerPruneSummaries(t *testing.T) {
+	_, conf, cleanup := newViewerTest(t)
+	defer cleanup()
+
+	v, err := NewViewer(conf)
+	assert.NoError(t, err)
+	listFiles := func() {
+		rec := httptest.NewRecorder()
+		v.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	listFiles()
+	assert.Len(t, v.summaries, 2)
+	assert.NoError(t, os.Remove(filepath.Join(filepath.Dir(conf.FilePath), "Dec 3 08:20:01.000000000 2019_app.log")))
+	listFiles()
+	assert.Len(t, v.summaries, 1)
+	assert.Contains(t, v.summaries, conf.FilePath)
+}
+
+func TestViewerEntries(t *testing.T) {
+	ts, _, cleanup := newViewerTest(t)
+	defer cleanup()
+
+	messages := func(query string) ([]string, bool) {
+		var resp entriesResponse
+		assert.Equal(t, http.StatusOK, getJSON(t, ts.URL+"/logs/api/entries?"+query, &resp))
+		var m []string
+		for _, e := range resp.Entries {
+			m = append(m, e.Message)
+		}
+		return m, resp.Truncated
+	}
+
+	m, truncated := messages("")
+	assert.Equal(t, []string{"started", "config loaded", "slow request", "request failed", "done"}, m)
+	assert.False(t, truncated)
+
+	m, truncated = messages("limit=2")
+	assert.Equal(t, []string{"request failed", "done"}, m)
+	assert.True(t, truncated)
+
+	m, _ = messages("level=warn,ERROR")
+	assert.Equal(t, []string{"slow request", "request failed"}, m)
+	m, _ = messages("since=2019-12-03T08:15&until=2019-12-03T09:30:00")
+	assert.Equal(t, []string{"config loaded", "slow request"}, m)
+	m, _ = messages("q=ALICE")
+	assert.Equal(t, []string{"slow request"}, m)
+	m, _ = messages("file=" + strings.Replace("Dec 3 08:20:01.000000000 2019_app.log", " ", "+", -1))
+	assert.Equal(t, []string{"started", "config loaded"}, m)
+
+	var resp entriesResponse
+	assert.Equal(t, http.StatusNotFound, getJSON(t, ts.URL+"/logs/api/entries?file=..%2Fetc%2Fpasswd", &resp))
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, ts.URL+"/logs/api/entries?since=yesterday", &resp))
+	assert.Equal(t, http.StatusBadRequest, getJSON(t, ts.URL+"/logs/api/entries?limit=-1", &resp))
+}
+
+func TestViewerTail(t *testing.T) {
+	ts, conf, cleanup := newViewerTest(t)
+	defer cleanup()
+
+	resp, err := http.Get(ts.URL + "/logs/api/tail?level=ERROR")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	l := newFileLogger(conf)
+	l.printLevel(INFO, "not sent")
+	l.write(newEntry(1, ERROR, "tailed", Fields{"k": "v"}))
+	assert.NoError(t, l.close())
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), "data: ") {
+				lines <- strings.TrimPrefix(sc.Text(), "data: ")
+			}
+		}
+	}()
+	select {
+	case data := <-lines:
+		var e Entry
+		assert.NoError(t, json.Unmarshal([]byte(data), &e))
+		assert.Equal(t, ERROR, e.Level)
+		assert.Equal(t, "tailed", e.Message)
+		assert.Equal(t, Fields{"k": "v"}, e.Fields)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	_, err = NewViewer(&Config{})
+	assert.Error(t, err)
+}